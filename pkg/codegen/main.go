@@ -6,6 +6,7 @@ import (
 	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
 	appsv1 "k8s.io/api/apps/v1"
 	batchv1 "k8s.io/api/batch/v1"
+	certificatesv1 "k8s.io/api/certificates/v1"
 	coordinationv1 "k8s.io/api/coordination/v1"
 	v1 "k8s.io/api/core/v1"
 	discoveryv1 "k8s.io/api/discovery/v1"
@@ -104,6 +105,11 @@ func main() {
 					coordinationv1.Lease{},
 				},
 			},
+			certificatesv1.GroupName: {
+				Types: []interface{}{
+					certificatesv1.CertificateSigningRequest{},
+				},
+			},
 		},
 	})
 }
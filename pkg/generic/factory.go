@@ -30,6 +30,17 @@ type Factory struct {
 	threadiness       map[schema.GroupVersionKind]int
 	config            *rest.Config
 	opts              FactoryOptions
+
+	// leaderRegistered is set once Start registered with opts.Leader.
+	leaderRegistered bool
+	// leaderCTX is the context of the current term of opts.Leader, nil when not leading.
+	leaderCTX context.Context
+}
+
+// Leader calls funcs while the replica is leader, such as a leader.Manager. f is called with a context that is done
+// when leadership is lost, and again on each new term.
+type Leader interface {
+	OnLeader(f func(ctx context.Context) error)
 }
 
 type FactoryOptions struct {
@@ -38,6 +49,13 @@ type FactoryOptions struct {
 	SharedCacheFactory      cache.SharedCacheFactory
 	SharedControllerFactory controller.SharedControllerFactory
 	HealthCallback          func(bool)
+	// KeyFilter, if set, limits the keys the handlers of all controllers of the Factory run for. Informers still
+	// cache all objects.
+	KeyFilter KeyFilter
+	// Leader, if set, makes the handlers of all controllers of the Factory only run while the replica is leader.
+	// Caches and controllers run on every replica, keys are dropped while not leading and all keys are enqueued
+	// again on each term, so a new leader does not wait for its caches to sync.
+	Leader Leader
 }
 
 func NewFactoryFromConfigWithOptions(config *rest.Config, opts *FactoryOptions) (*Factory, error) {
@@ -56,6 +74,9 @@ func NewFactoryFromConfigWithOptions(config *rest.Config, opts *FactoryOptions)
 	if f.cacheFactory == nil && f.controllerFactory != nil {
 		f.cacheFactory = f.controllerFactory.SharedCacheFactory()
 	}
+	if f.controllerFactory != nil {
+		f.controllerFactory = f.wrapControllerFactory(f.controllerFactory)
+	}
 
 	return f, nil
 }
@@ -95,13 +116,31 @@ func (c *Factory) setControllerFactoryWithLock() error {
 	}
 
 	c.cacheFactory = cacheFactory
-	c.controllerFactory = controller.NewSharedControllerFactory(cacheFactory, &controller.SharedControllerFactoryOptions{
+	c.controllerFactory = c.wrapControllerFactory(controller.NewSharedControllerFactory(cacheFactory, &controller.SharedControllerFactoryOptions{
 		KindWorkers: c.threadiness,
-	})
+	}))
 
 	return nil
 }
 
+func (c *Factory) wrapControllerFactory(factory controller.SharedControllerFactory) controller.SharedControllerFactory {
+	if c.opts.KeyFilter == nil && c.opts.Leader == nil {
+		return factory
+	}
+	var leading func() bool
+	if c.opts.Leader != nil {
+		leading = c.leading
+	}
+	return newFilteredControllerFactory(factory, c.opts.KeyFilter, leading)
+}
+
+// leading returns whether the replica is leader of opts.Leader.
+func (c *Factory) leading() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.leaderCTX != nil && c.leaderCTX.Err() == nil
+}
+
 func (c *Factory) Sync(ctx context.Context) error {
 	if c.cacheFactory != nil {
 		if err := c.cacheFactory.Start(ctx); err != nil {
@@ -122,9 +161,43 @@ func (c *Factory) Start(ctx context.Context, defaultThreadiness int) error {
 		return err
 	}
 
-	if c.controllerFactory != nil {
-		return c.controllerFactory.Start(ctx, defaultThreadiness)
+	if c.controllerFactory == nil {
+		return nil
+	}
+	if err := c.controllerFactory.Start(ctx, defaultThreadiness); err != nil {
+		return err
+	}
+	if c.opts.Leader != nil {
+		c.registerLeader()
 	}
-
 	return nil
 }
+
+// registerLeader tracks the terms of opts.Leader, once, and enqueues all accepted keys on each term so keys
+// dropped while not leading are handled.
+func (c *Factory) registerLeader() {
+	c.lock.Lock()
+	register := !c.leaderRegistered
+	c.leaderRegistered = true
+	c.lock.Unlock()
+
+	if !register {
+		return
+	}
+	c.opts.Leader.OnLeader(func(leaderCTX context.Context) error {
+		c.lock.Lock()
+		c.leaderCTX = leaderCTX
+		c.lock.Unlock()
+		context.AfterFunc(leaderCTX, func() {
+			c.lock.Lock()
+			defer c.lock.Unlock()
+			if c.leaderCTX == leaderCTX {
+				c.leaderCTX = nil
+			}
+		})
+		if filtered, ok := c.controllerFactory.(*filteredControllerFactory); ok {
+			filtered.enqueueAccepted()
+		}
+		return nil
+	})
+}
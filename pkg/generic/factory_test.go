@@ -0,0 +1,212 @@
+package generic
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	lassocache "github.com/rancher/lasso/pkg/cache"
+	"github.com/rancher/lasso/pkg/client"
+	"github.com/rancher/lasso/pkg/controller"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// fakeLeader calls its funcs when a term is started by the test.
+type fakeLeader struct {
+	funcs []func(ctx context.Context) error
+}
+
+func (l *fakeLeader) OnLeader(f func(ctx context.Context) error) {
+	l.funcs = append(l.funcs, f)
+}
+
+func (l *fakeLeader) startTerm(t *testing.T, ctx context.Context) {
+	for _, f := range l.funcs {
+		require.NoError(t, f(ctx))
+	}
+}
+
+func TestFactory_StartOnLeader(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	controllerFactory := NewMockSharedControllerFactory(ctrl)
+	sharedController := NewMockSharedController(ctrl)
+	controllerFactory.EXPECT().SharedCacheFactory().Return(nil)
+	leader := &fakeLeader{}
+
+	factory, err := NewFactoryFromConfigWithOptions(nil, &FactoryOptions{
+		SharedControllerFactory: controllerFactory,
+		Leader:                  leader,
+	})
+	require.NoError(t, err)
+
+	controllerFactory.EXPECT().ForObject(gomock.Any()).Return(sharedController, nil)
+	c, err := factory.ControllerFactory().ForObject(&v1.Pod{})
+	require.NoError(t, err)
+
+	informer := cache.NewSharedIndexInformer(&cache.ListWatch{}, &v1.Pod{}, 0, cache.Indexers{})
+	require.NoError(t, informer.GetStore().Add(&v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "pod"}}))
+	sharedController.EXPECT().Informer().Return(informer).AnyTimes()
+
+	// enqueued keys are handled right away, as by running workers
+	var registered controller.SharedControllerHandler
+	sharedController.EXPECT().RegisterHandler(gomock.Any(), "test", gomock.Any()).Do(
+		func(_ context.Context, _ string, handler controller.SharedControllerHandler) {
+			registered = handler
+		})
+	handled := make(chan string, 100)
+	c.RegisterHandler(context.Background(), "test", controller.SharedControllerHandlerFunc(func(key string, obj runtime.Object) (runtime.Object, error) {
+		handled <- key
+		return obj, nil
+	}))
+	sharedController.EXPECT().EnqueueKey("ns/pod").Do(func(key string) {
+		_, _ = registered.OnChange(key, nil)
+	}).AnyTimes()
+
+	// controllers run on every replica, but handlers do not until the replica is leader
+	ctx := context.Background()
+	controllerFactory.EXPECT().Start(ctx, 2).Return(nil).Times(2)
+	require.NoError(t, factory.Start(ctx, 2))
+	require.NoError(t, factory.Start(ctx, 2))
+	require.Len(t, leader.funcs, 1, "Start should only register with the leader once")
+	_, err = registered.OnChange("ns/pod", nil)
+	require.NoError(t, err)
+	require.Empty(t, handled)
+
+	// all keys are handled on each term
+	leaderCtx, cancel := context.WithCancel(ctx)
+	leader.startTerm(t, leaderCtx)
+	requireHandled(t, handled, "ns/pod")
+	_, err = registered.OnChange("ns/pod", nil)
+	require.NoError(t, err)
+	requireHandled(t, handled, "ns/pod")
+
+	// and dropped once leadership is lost
+	cancel()
+	require.Eventually(t, func() bool {
+		return !factory.leading()
+	}, time.Second, 10*time.Millisecond)
+	_, err = registered.OnChange("ns/pod", nil)
+	require.NoError(t, err)
+	require.Empty(t, handled)
+
+	nextCtx, cancelNext := context.WithCancel(ctx)
+	defer cancelNext()
+	leader.startTerm(t, nextCtx)
+	requireHandled(t, handled, "ns/pod")
+}
+
+// TestFactory_StartOnLeaderTerms runs lasso controllers across terms, each term handling the keys in the cache
+// and keys enqueued while not leading being dropped.
+func TestFactory_StartOnLeaderTerms(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pods := &podListWatch{pods: []v1.Pod{{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "pod", ResourceVersion: "1"}}}}
+	informer := cache.NewSharedIndexInformer(pods, &v1.Pod{}, 0, cache.Indexers{})
+	go informer.Run(ctx.Done())
+	require.True(t, cache.WaitForCacheSync(ctx.Done(), informer.HasSynced))
+
+	leader := &fakeLeader{}
+	factory, err := NewFactoryFromConfigWithOptions(nil, &FactoryOptions{
+		SharedControllerFactory: controller.NewSharedControllerFactory(&testCacheFactory{informer: informer}, nil),
+		Leader:                  leader,
+	})
+	require.NoError(t, err)
+
+	handled := make(chan string, 100)
+	c := factory.ControllerFactory().ForResourceKind(v1.SchemeGroupVersion.WithResource("pods"), "Pod", true)
+	c.RegisterHandler(ctx, "test", controller.SharedControllerHandlerFunc(func(key string, obj runtime.Object) (runtime.Object, error) {
+		handled <- key
+		return obj, nil
+	}))
+	require.NoError(t, factory.Start(ctx, 1))
+
+	for term := 0; term < 3; term++ {
+		c.EnqueueKey("ns/pod")
+		time.Sleep(100 * time.Millisecond)
+		require.Empty(t, handled, "keys should not be handled while not leading")
+
+		termCtx, cancelTerm := context.WithCancel(ctx)
+		leader.startTerm(t, termCtx)
+		requireHandled(t, handled, "ns/pod")
+		c.EnqueueKey("ns/pod")
+		requireHandled(t, handled, "ns/pod")
+		cancelTerm()
+		require.Eventually(t, func() bool {
+			return !factory.leading()
+		}, time.Second, 10*time.Millisecond)
+	}
+}
+
+func requireHandled(t *testing.T, handled <-chan string, key string) {
+	t.Helper()
+	select {
+	case got := <-handled:
+		require.Equal(t, key, got)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "key was not handled", key)
+	}
+}
+
+// podListWatch lists the pods and never sends watch events.
+type podListWatch struct {
+	pods []v1.Pod
+}
+
+func (p *podListWatch) List(metav1.ListOptions) (runtime.Object, error) {
+	return &v1.PodList{ListMeta: metav1.ListMeta{ResourceVersion: "1"}, Items: p.pods}, nil
+}
+
+func (p *podListWatch) Watch(metav1.ListOptions) (watch.Interface, error) {
+	return watch.NewFake(), nil
+}
+
+func (p *podListWatch) IsWatchListSemanticsUnSupported() bool {
+	return true
+}
+
+// testCacheFactory is a lasso SharedCacheFactory of a single informer, started by the test.
+type testCacheFactory struct {
+	lassocache.SharedCacheFactory
+	informer cache.SharedIndexInformer
+}
+
+func (f *testCacheFactory) Start(context.Context) error {
+	return nil
+}
+
+func (f *testCacheFactory) StartGVK(context.Context, schema.GroupVersionKind) error {
+	return nil
+}
+
+func (f *testCacheFactory) ForResourceKind(schema.GroupVersionResource, string, bool) (cache.SharedIndexInformer, error) {
+	return f.informer, nil
+}
+
+func (f *testCacheFactory) WaitForCacheSync(context.Context) map[schema.GroupVersionKind]bool {
+	return map[schema.GroupVersionKind]bool{v1.SchemeGroupVersion.WithKind("Pod"): f.informer.HasSynced()}
+}
+
+func (f *testCacheFactory) SharedClientFactory() client.SharedClientFactory {
+	return &testClientFactory{}
+}
+
+// testClientFactory is a lasso SharedClientFactory of pods without clients.
+type testClientFactory struct {
+	client.SharedClientFactory
+}
+
+func (f *testClientFactory) ForResourceKind(schema.GroupVersionResource, string, bool) *client.Client {
+	return nil
+}
+
+func (f *testClientFactory) GVKForResource(schema.GroupVersionResource) (schema.GroupVersionKind, error) {
+	return v1.SchemeGroupVersion.WithKind("Pod"), nil
+}
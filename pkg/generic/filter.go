@@ -0,0 +1,145 @@
+package generic
+
+import (
+	"context"
+	"sync"
+
+	"github.com/rancher/lasso/pkg/controller"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// KeyFilter limits the keys the handlers of the controllers of a Factory run for, for example to the keys of the
+// shards a replica owns. Keys are namespace/name, or name for cluster scoped objects.
+type KeyFilter interface {
+	// Accepts returns whether handlers should run for the key.
+	Accepts(key string) bool
+	// OnChange registers f to be called whenever the keys accepted may have changed, until ctx is done.
+	OnChange(ctx context.Context, f func())
+}
+
+// filteredControllerFactory wraps the controllers of a SharedControllerFactory so their handlers only run for
+// keys accepted by the filter, if any, and while leading, if leading is set, and keeps track of them so their keys
+// can be enqueued again.
+type filteredControllerFactory struct {
+	controller.SharedControllerFactory
+	filter  KeyFilter
+	leading func() bool
+
+	lock        sync.Mutex
+	controllers map[controller.SharedController]*filteredController
+	// filterCtx is the context of the last Start, until which enqueueAccepted is registered with the filter.
+	filterCtx context.Context
+}
+
+func newFilteredControllerFactory(factory controller.SharedControllerFactory, filter KeyFilter, leading func() bool) *filteredControllerFactory {
+	return &filteredControllerFactory{
+		SharedControllerFactory: factory,
+		filter:                  filter,
+		leading:                 leading,
+		controllers:             map[controller.SharedController]*filteredController{},
+	}
+}
+
+func (f *filteredControllerFactory) wrap(c controller.SharedController) controller.SharedController {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if filtered, ok := f.controllers[c]; ok {
+		return filtered
+	}
+	filtered := &filteredController{
+		SharedController: c,
+		filter:           f.filter,
+		leading:          f.leading,
+	}
+	f.controllers[c] = filtered
+	return filtered
+}
+
+func (f *filteredControllerFactory) ForObject(obj runtime.Object) (controller.SharedController, error) {
+	c, err := f.SharedControllerFactory.ForObject(obj)
+	if err != nil {
+		return nil, err
+	}
+	return f.wrap(c), nil
+}
+
+func (f *filteredControllerFactory) ForKind(gvk schema.GroupVersionKind) (controller.SharedController, error) {
+	c, err := f.SharedControllerFactory.ForKind(gvk)
+	if err != nil {
+		return nil, err
+	}
+	return f.wrap(c), nil
+}
+
+func (f *filteredControllerFactory) ForResource(gvr schema.GroupVersionResource, namespaced bool) controller.SharedController {
+	return f.wrap(f.SharedControllerFactory.ForResource(gvr, namespaced))
+}
+
+func (f *filteredControllerFactory) ForResourceKind(gvr schema.GroupVersionResource, kind string, namespaced bool) controller.SharedController {
+	return f.wrap(f.SharedControllerFactory.ForResourceKind(gvr, kind, namespaced))
+}
+
+// Start starts the controllers, enqueueing the accepted keys whenever the filter changes until ctx is done. Starting
+// again with the same ctx, for example to start controllers registered since, does not register with the filter
+// again.
+func (f *filteredControllerFactory) Start(ctx context.Context, workers int) error {
+	f.lock.Lock()
+	register := f.filter != nil && f.filterCtx != ctx
+	if register {
+		f.filterCtx = ctx
+	}
+	f.lock.Unlock()
+
+	if register {
+		f.filter.OnChange(ctx, f.enqueueAccepted)
+	}
+	return f.SharedControllerFactory.Start(ctx, workers)
+}
+
+// enqueueAccepted enqueues all accepted keys in the caches of the controllers, so keys that were filtered out
+// are handled.
+func (f *filteredControllerFactory) enqueueAccepted() {
+	for _, c := range f.list() {
+		for _, key := range c.Informer().GetStore().ListKeys() {
+			if c.accepts(key) {
+				c.EnqueueKey(key)
+			}
+		}
+	}
+}
+
+func (f *filteredControllerFactory) list() []*filteredController {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	controllers := make([]*filteredController, 0, len(f.controllers))
+	for _, c := range f.controllers {
+		controllers = append(controllers, c)
+	}
+	return controllers
+}
+
+type filteredController struct {
+	controller.SharedController
+	filter  KeyFilter
+	leading func() bool
+}
+
+// accepts returns whether handlers should run for the key: while leading, if the factory runs on a Leader, and if
+// the filter, if any, accepts it.
+func (c *filteredController) accepts(key string) bool {
+	if c.leading != nil && !c.leading() {
+		return false
+	}
+	return c.filter == nil || c.filter.Accepts(key)
+}
+
+func (c *filteredController) RegisterHandler(ctx context.Context, name string, handler controller.SharedControllerHandler) {
+	c.SharedController.RegisterHandler(ctx, name, controller.SharedControllerHandlerFunc(func(key string, obj runtime.Object) (runtime.Object, error) {
+		if !c.accepts(key) {
+			return obj, nil
+		}
+		return handler.OnChange(key, obj)
+	}))
+}
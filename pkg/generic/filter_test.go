@@ -0,0 +1,78 @@
+package generic
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/rancher/lasso/pkg/controller"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+)
+
+// prefixFilter accepts the keys of a namespace.
+type prefixFilter struct {
+	namespace  string
+	onChange   func()
+	registered int
+}
+
+func (p *prefixFilter) Accepts(key string) bool {
+	return strings.HasPrefix(key, p.namespace+"/")
+}
+
+func (p *prefixFilter) OnChange(_ context.Context, f func()) {
+	p.onChange = f
+	p.registered++
+}
+
+func TestFilteredControllerFactory(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	factory := NewMockSharedControllerFactory(ctrl)
+	sharedController := NewMockSharedController(ctrl)
+	filter := &prefixFilter{namespace: "a"}
+	filtered := newFilteredControllerFactory(factory, filter, nil)
+
+	factory.EXPECT().ForObject(gomock.Any()).Return(sharedController, nil).Times(2)
+	c, err := filtered.ForObject(&v1.Pod{})
+	require.NoError(t, err)
+	again, err := filtered.ForObject(&v1.Pod{})
+	require.NoError(t, err)
+	require.Same(t, c, again, "the same controller should be wrapped once")
+
+	var registered controller.SharedControllerHandler
+	sharedController.EXPECT().RegisterHandler(gomock.Any(), "test", gomock.Any()).Do(
+		func(_ context.Context, _ string, handler controller.SharedControllerHandler) {
+			registered = handler
+		})
+	var handled []string
+	c.RegisterHandler(context.Background(), "test", controller.SharedControllerHandlerFunc(func(key string, obj runtime.Object) (runtime.Object, error) {
+		handled = append(handled, key)
+		return obj, nil
+	}))
+	for _, key := range []string{"a/pod", "b/pod"} {
+		_, err := registered.OnChange(key, nil)
+		require.NoError(t, err)
+	}
+	require.Equal(t, []string{"a/pod"}, handled, "handler should only run for accepted keys")
+
+	// accepted keys in the cache are enqueued when the filter changes
+	informer := cache.NewSharedIndexInformer(&cache.ListWatch{}, &v1.Pod{}, 0, cache.Indexers{})
+	for _, namespace := range []string{"a", "b"} {
+		require.NoError(t, informer.GetStore().Add(&v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "pod"}}))
+	}
+	factory.EXPECT().Start(gomock.Any(), 1).Return(nil).Times(2)
+	require.NoError(t, filtered.Start(context.Background(), 1))
+	require.NotNil(t, filter.onChange, "Start should register for filter changes")
+	require.NoError(t, filtered.Start(context.Background(), 1))
+	require.Equal(t, 1, filter.registered, "Start should register once per context")
+
+	filter.namespace = "b"
+	sharedController.EXPECT().Informer().Return(informer)
+	sharedController.EXPECT().EnqueueKey("b/pod")
+	filter.onChange()
+}
@@ -0,0 +1,88 @@
+package leader
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// observedLock records the lock renewals of a Manager.
+type observedLock struct {
+	resourcelock.Interface
+	m *Manager
+}
+
+func (o *observedLock) Create(ctx context.Context, ler resourcelock.LeaderElectionRecord) error {
+	start := time.Now()
+	err := o.Interface.Create(ctx, ler)
+	o.observe(ler, err, start)
+	return err
+}
+
+func (o *observedLock) Update(ctx context.Context, ler resourcelock.LeaderElectionRecord) error {
+	start := time.Now()
+	err := o.Interface.Update(ctx, ler)
+	o.observe(ler, err, start)
+	return err
+}
+
+func (o *observedLock) observe(ler resourcelock.LeaderElectionRecord, err error, start time.Time) {
+	// the lock is also written when releasing it on cancel, which clears the holder
+	if ler.HolderIdentity != o.Identity() {
+		return
+	}
+	end := time.Now()
+	observeRenew(o.m.name, err, start, end)
+	if err == nil {
+		o.m.Lock()
+		o.m.lastRenew = end
+		o.m.Unlock()
+	}
+}
+
+// HealthChecker checks that the leader of a Manager keeps renewing its lock. A leader that could not renew its lock
+// for longer than RenewDeadline is stuck: it should have given up leadership, so the process should be restarted.
+// Replicas that are not the leader are always healthy.
+//
+// HealthChecker implements the healthz.HealthChecker interface of k8s.io/apiserver and http.Handler, so it can be
+// served as a liveness probe.
+type HealthChecker struct {
+	m *Manager
+}
+
+// HealthChecker returns the HealthChecker of the Manager.
+func (m *Manager) HealthChecker() *HealthChecker {
+	return &HealthChecker{m: m}
+}
+
+// Name returns the name of the check.
+func (h *HealthChecker) Name() string {
+	return "leader-election-" + h.m.name
+}
+
+// Check returns an error if the replica is the leader and did not renew its lock within RenewDeadline.
+func (h *HealthChecker) Check(_ *http.Request) error {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	if h.m.leaderCTX == nil || h.m.lastRenew.IsZero() {
+		return nil
+	}
+	if since := time.Since(h.m.lastRenew); since > h.m.renewDeadline {
+		return fmt.Errorf("leader of %s did not renew its lock for %s, longer than the renew deadline of %s",
+			h.m.name, since.Round(time.Second), h.m.renewDeadline)
+	}
+	return nil
+}
+
+func (h *HealthChecker) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if err := h.Check(req); err != nil {
+		http.Error(rw, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write([]byte("ok"))
+}
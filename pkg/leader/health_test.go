@@ -0,0 +1,104 @@
+package leader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// TestMain registers the metrics before any election runs, as elections of earlier tests keep running in the
+// background.
+func TestMain(m *testing.M) {
+	MustRegisterMetrics(prometheus.NewRegistry())
+	os.Exit(m.Run())
+}
+
+func TestManager_State(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	newLeaders := make(chan string, 10)
+	opts := testOptions(t, "a")
+	opts.Recampaign = true
+	opts.OnNewLeader = func(identity string) {
+		newLeaders <- identity
+	}
+	m := NewManagerWithOptions("ns", "state", client, opts)
+	if m.IsLeader() || !m.LeaderSince().IsZero() || m.CurrentLeader() != "" {
+		t.Fatal("manager reports a leader before starting")
+	}
+
+	started := time.Now()
+	leading := make(chan struct{})
+	m.OnLeader(func(ctx context.Context) error {
+		close(leading)
+		return nil
+	})
+	m.Start(ctx)
+	select {
+	case <-leading:
+	case <-time.After(5 * time.Second):
+		t.Fatal("did not become leader")
+	}
+
+	if !m.IsLeader() {
+		t.Error("IsLeader is false while leading")
+	}
+	if since := m.LeaderSince(); since.Before(started) {
+		t.Errorf("LeaderSince %v is before the manager started", since)
+	}
+	if err := wait(func() bool { return m.CurrentLeader() == "a" }); err != nil {
+		t.Errorf("CurrentLeader is %q, want a", m.CurrentLeader())
+	}
+	select {
+	case <-newLeaders:
+	default:
+		t.Error("OnNewLeader of the options was not called")
+	}
+	if got := testutil.ToFloat64(isLeader.WithLabelValues("state")); got != 1 {
+		t.Errorf("is_leader is %v, want 1", got)
+	}
+	if got := testutil.CollectAndCount(renewDuration); got == 0 {
+		t.Error("no lock renewal observed")
+	}
+
+	health := m.HealthChecker()
+	if err := health.Check(nil); err != nil {
+		t.Errorf("healthy leader failed its check: %v", err)
+	}
+
+	// a leader that does not renew its lock within the renew deadline is reported as unhealthy
+	m.Lock()
+	m.renewDeadline = time.Nanosecond
+	m.Unlock()
+	if err := health.Check(nil); err == nil {
+		t.Error("stuck leader passed its check")
+	}
+	rec := httptest.NewRecorder()
+	health.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("stuck leader served status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
+
+// wait polls cond for up to five seconds.
+func wait(cond func() bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for !cond() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	return nil
+}
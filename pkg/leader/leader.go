@@ -2,11 +2,13 @@ package leader
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/uuid"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/leaderelection"
 	"k8s.io/client-go/tools/leaderelection/resourcelock"
@@ -14,6 +16,9 @@ import (
 
 type Callback func(cb context.Context)
 
+// ErrLeadershipLost is returned by Run when this candidate stops being the leader before its context is done.
+var ErrLeadershipLost = errors.New("leadership lost")
+
 const devModeEnvKey = "CATTLE_DEV_MODE"
 const leaseDurationEnvKey = "CATTLE_ELECTION_LEASE_DURATION"
 const renewDeadlineEnvKey = "CATTLE_ELECTION_RENEW_DEADLINE"
@@ -26,25 +31,76 @@ const defaultRetryPeriod = 2 * time.Second
 const developmentLeaseDuration = 45 * time.Hour
 const developmentRenewDeadline = 30 * time.Hour
 
+// Options configure the leader election. Zero values fall back to the CATTLE_ELECTION_* and CATTLE_DEV_MODE
+// environment variables, then to the defaults.
+type Options struct {
+	// Identity is the identity of this candidate in the lock. It must be unique across all candidates, defaults to
+	// the hostname followed by a random UUID so candidates sharing a hostname, such as pods using hostNetwork on
+	// the same node, don't collide.
+	Identity string
+	// LockType is the type of lock created with resourcelock.New. Defaults to resourcelock.LeasesResourceLock.
+	LockType string
+	// Lock is used as lock instead of creating one from LockType, for lock backends outside of client-go.
+	Lock resourcelock.Interface
+	// LeaseDuration is how long non-leader candidates wait before trying to acquire the lock.
+	LeaseDuration time.Duration
+	// RenewDeadline is how long the leader retries refreshing the lock before giving up leadership.
+	RenewDeadline time.Duration
+	// RetryPeriod is how long candidates wait between tries of actions.
+	RetryPeriod time.Duration
+	// OnNewLeader is called with the identity of the new leader when the leader changes, including when this
+	// candidate becomes the leader.
+	OnNewLeader func(identity string)
+	// Recampaign makes a Manager re-enter the election after losing leadership instead of exiting the process.
+	// The functions registered with OnLeader and OnLeaderOrDie are called again each time leadership is acquired.
+	Recampaign bool
+}
+
 func RunOrDie(ctx context.Context, namespace, name string, client kubernetes.Interface, cb Callback) {
+	RunOrDieWithOptions(ctx, namespace, name, client, cb, Options{})
+}
+
+// RunOrDieWithOptions is RunOrDie with Options for the leader election.
+func RunOrDieWithOptions(ctx context.Context, namespace, name string, client kubernetes.Interface, cb Callback, opts Options) {
 	if namespace == "" {
 		namespace = "kube-system"
 	}
 
-	err := run(ctx, namespace, name, client, cb)
+	err := run(ctx, namespace, name, client, cb, opts)
 	if err != nil {
-		logrus.Fatalf("Failed to start leader election for %s", name)
+		logrus.Fatalf("Failed to start leader election for %s: %v", name, err)
 	}
 	panic("Failed to start leader election for " + name)
 }
 
-func run(ctx context.Context, namespace, name string, client kubernetes.Interface, cb Callback) error {
-	id, err := os.Hostname()
+// identity returns the identity of this candidate.
+func identity(opts Options) (string, error) {
+	if opts.Identity != "" {
+		return opts.Identity, nil
+	}
+	hostname, err := os.Hostname()
 	if err != nil {
-		return err
+		return "", err
+	}
+	return hostname + "_" + string(uuid.NewUUID()), nil
+}
+
+// newLock returns the lock of the election.
+func newLock(namespace, name string, client kubernetes.Interface, opts Options) (resourcelock.Interface, error) {
+	if opts.Lock != nil {
+		return opts.Lock, nil
 	}
 
-	rl, err := resourcelock.New(resourcelock.LeasesResourceLock,
+	id, err := identity(opts)
+	if err != nil {
+		return nil, err
+	}
+	lockType := opts.LockType
+	if lockType == "" {
+		lockType = resourcelock.LeasesResourceLock
+	}
+
+	rl, err := resourcelock.New(lockType,
 		namespace,
 		name,
 		client.CoreV1(),
@@ -53,10 +109,19 @@ func run(ctx context.Context, namespace, name string, client kubernetes.Interfac
 			Identity: id,
 		})
 	if err != nil {
-		logrus.Fatalf("error creating leader lock for %s: %v", name, err)
+		return nil, fmt.Errorf("error creating leader lock for %s: %w", name, err)
+	}
+	return rl, nil
+}
+
+func run(ctx context.Context, namespace, name string, client kubernetes.Interface, cb Callback, opts Options) error {
+	rl, err := newLock(namespace, name, client, opts)
+	if err != nil {
+		return err
 	}
 
 	cbs := leaderelection.LeaderCallbacks{
+		OnNewLeader: opts.OnNewLeader,
 		OnStartedLeading: func(ctx context.Context) {
 			go cb(ctx)
 		},
@@ -82,7 +147,7 @@ func run(ctx context.Context, namespace, name string, client kubernetes.Interfac
 		},
 	}
 
-	config, err := computeConfig(rl, cbs)
+	config, err := computeConfig(rl, cbs, opts)
 	if err != nil {
 		return err
 	}
@@ -91,7 +156,46 @@ func run(ctx context.Context, namespace, name string, client kubernetes.Interfac
 	panic("unreachable")
 }
 
-func computeConfig(rl resourcelock.Interface, cbs leaderelection.LeaderCallbacks) (*leaderelection.LeaderElectionConfig, error) {
+// Run runs the leader election until ctx is done or leadership is lost. Once this candidate becomes the leader, cb
+// is called in a new goroutine with a context that is canceled when leadership is lost or ctx is done. Unlike
+// RunOrDie it never exits the process: it returns nil when ctx is done and an error wrapping ErrLeadershipLost
+// when leadership is lost, after which Run can be called again to re-enter the election.
+func Run(ctx context.Context, namespace, name string, client kubernetes.Interface, cb Callback, opts Options) error {
+	if namespace == "" {
+		namespace = "kube-system"
+	}
+
+	rl, err := newLock(namespace, name, client, opts)
+	if err != nil {
+		return err
+	}
+
+	cbs := leaderelection.LeaderCallbacks{
+		OnNewLeader: opts.OnNewLeader,
+		OnStartedLeading: func(ctx context.Context) {
+			go cb(ctx)
+		},
+		OnStoppedLeading: func() {},
+	}
+
+	config, err := computeConfig(rl, cbs, opts)
+	if err != nil {
+		return err
+	}
+	le, err := leaderelection.NewLeaderElector(*config)
+	if err != nil {
+		return fmt.Errorf("invalid leader election config for %s: %w", name, err)
+	}
+
+	// Run only returns before ctx is done when leadership is lost, the lock is acquired until ctx is done
+	le.Run(ctx)
+	if ctx.Err() != nil {
+		return nil
+	}
+	return fmt.Errorf("leaderelection lost for %s: %w", name, ErrLeadershipLost)
+}
+
+func computeConfig(rl resourcelock.Interface, cbs leaderelection.LeaderCallbacks, opts Options) (*leaderelection.LeaderElectionConfig, error) {
 	leaseDuration := defaultLeaseDuration
 	renewDeadline := defaultRenewDeadline
 	retryPeriod := defaultRetryPeriod
@@ -118,6 +222,15 @@ func computeConfig(rl resourcelock.Interface, cbs leaderelection.LeaderCallbacks
 			return nil, fmt.Errorf("%s value [%s] is not a valid duration: %w", retryPeriodEnvKey, d, err)
 		}
 	}
+	if opts.LeaseDuration != 0 {
+		leaseDuration = opts.LeaseDuration
+	}
+	if opts.RenewDeadline != 0 {
+		renewDeadline = opts.RenewDeadline
+	}
+	if opts.RetryPeriod != 0 {
+		retryPeriod = opts.RetryPeriod
+	}
 
 	return &leaderelection.LeaderElectionConfig{
 		Lock:            rl,
@@ -3,17 +3,20 @@ package leader
 import (
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/tools/leaderelection"
 	"k8s.io/client-go/tools/leaderelection/resourcelock"
 )
 
 func Test_computeConfig(t *testing.T) {
 	type args struct {
-		rl  resourcelock.Interface
-		cbs leaderelection.LeaderCallbacks
+		rl   resourcelock.Interface
+		cbs  leaderelection.LeaderCallbacks
+		opts Options
 	}
 	type env struct {
 		key   string
@@ -84,6 +87,31 @@ func Test_computeConfig(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "options override environment",
+			args: args{
+				rl:  nil,
+				cbs: leaderelection.LeaderCallbacks{},
+				opts: Options{
+					LeaseDuration: 10 * time.Second,
+					RetryPeriod:   time.Second,
+				},
+			},
+			envs: []env{
+				{key: leaseDurationEnvKey, value: "1s"},
+				{key: renewDeadlineEnvKey, value: "2s"},
+				{key: retryPeriodEnvKey, value: "3m"},
+			},
+			want: &leaderelection.LeaderElectionConfig{
+				Lock:            nil,
+				LeaseDuration:   10 * time.Second,
+				RenewDeadline:   2 * time.Second,
+				RetryPeriod:     time.Second,
+				Callbacks:       leaderelection.LeaderCallbacks{},
+				ReleaseOnCancel: true,
+			},
+			wantErr: false,
+		},
 		{
 			name: "unparseable lease duration",
 			args: args{
@@ -143,7 +171,7 @@ func Test_computeConfig(t *testing.T) {
 					return
 				}
 			}
-			got, err := computeConfig(tt.args.rl, tt.args.cbs)
+			got, err := computeConfig(tt.args.rl, tt.args.cbs, tt.args.opts)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("computeConfig() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -154,3 +182,51 @@ func Test_computeConfig(t *testing.T) {
 		})
 	}
 }
+
+func Test_identity(t *testing.T) {
+	id, err := identity(Options{Identity: "pod-a"})
+	if err != nil || id != "pod-a" {
+		t.Errorf("identity() = %q, %v, want pod-a", id, err)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := identity(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := identity(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(first, hostname+"_") {
+		t.Errorf("identity() = %q, want prefix %q", first, hostname+"_")
+	}
+	if first == second {
+		t.Errorf("identity() returned %q twice, candidates on the same host would collide", first)
+	}
+}
+
+func Test_newLock(t *testing.T) {
+	client := fake.NewSimpleClientset()
+
+	rl, err := newLock("ns", "lock", client, Options{Identity: "pod-a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rl.Identity() != "pod-a" || rl.Describe() != "ns/lock" {
+		t.Errorf("newLock() = %s %s, want pod-a ns/lock", rl.Identity(), rl.Describe())
+	}
+
+	if _, err := newLock("ns", "lock", client, Options{LockType: "unknown"}); err == nil {
+		t.Error("newLock() with unknown lock type succeeded")
+	}
+
+	custom := &resourcelock.LeaseLock{LockConfig: resourcelock.ResourceLockConfig{Identity: "custom"}}
+	rl, err = newLock("ns", "lock", client, Options{Lock: custom})
+	if err != nil || rl != custom {
+		t.Errorf("newLock() = %v, %v, want the custom lock", rl, err)
+	}
+}
@@ -2,29 +2,51 @@ package leader
 
 import (
 	"context"
+	"errors"
 	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
 )
 
+// retryDelay is how long to wait before calling a failed leader func again or re-entering a failed election.
+const retryDelay = 5 * time.Second
+
 type Manager struct {
 	sync.Mutex
-	leaderChan    chan struct{}
 	leaderStarted bool
-	leaderCTX     context.Context
-	namespace     string
-	name          string
-	k8s           kubernetes.Interface
+	// leaderCTX is the context of the current term, nil when not leading.
+	leaderCTX context.Context
+	// callbacks are called at the start of each term.
+	callbacks []func(ctx context.Context)
+	namespace string
+	name      string
+	k8s       kubernetes.Interface
+	opts      Options
+
+	// renewDeadline is the RenewDeadline of the election once started.
+	renewDeadline time.Duration
+	// currentLeader is the identity of the last leader observed.
+	currentLeader string
+	// leaderSince is when the current term started.
+	leaderSince time.Time
+	// lastRenew is when the lock was last acquired or renewed by this replica.
+	lastRenew time.Time
 }
 
 func NewManager(namespace, name string, k8s kubernetes.Interface) *Manager {
+	return NewManagerWithOptions(namespace, name, k8s, Options{})
+}
+
+// NewManagerWithOptions is NewManager with Options for the leader election.
+func NewManagerWithOptions(namespace, name string, k8s kubernetes.Interface, opts Options) *Manager {
 	return &Manager{
-		leaderChan: make(chan struct{}),
-		namespace:  namespace,
-		name:       name,
-		k8s:        k8s,
+		namespace: namespace,
+		name:      name,
+		k8s:       k8s,
+		opts:      opts,
 	}
 }
 
@@ -37,35 +59,149 @@ func (m *Manager) Start(ctx context.Context) {
 	}
 
 	m.leaderStarted = true
-	go RunOrDie(ctx, m.namespace, m.name, m.k8s, func(ctx context.Context) {
-		m.leaderCTX = ctx
-		close(m.leaderChan)
-	})
+	if err := m.observe(); err != nil {
+		logrus.Errorf("failed to observe leader election for %s: %v", m.name, err)
+	}
+	if m.opts.Recampaign {
+		go m.campaign(ctx)
+		return
+	}
+	go RunOrDieWithOptions(ctx, m.namespace, m.name, m.k8s, m.startedLeading, m.opts)
+}
+
+// observe wraps the lock and the callbacks of the election to track its state. It must be called with the lock
+// held.
+func (m *Manager) observe() error {
+	namespace := m.namespace
+	if namespace == "" {
+		namespace = "kube-system"
+	}
+	config, err := computeConfig(nil, leaderelection.LeaderCallbacks{}, m.opts)
+	if err != nil {
+		return err
+	}
+	rl, err := newLock(namespace, m.name, m.k8s, m.opts)
+	if err != nil {
+		return err
+	}
+
+	m.renewDeadline = config.RenewDeadline
+	m.opts.Lock = &observedLock{Interface: rl, m: m}
+	onNewLeader := m.opts.OnNewLeader
+	m.opts.OnNewLeader = func(identity string) {
+		m.Lock()
+		m.currentLeader = identity
+		m.Unlock()
+		observeTransition(m.name)
+		if onNewLeader != nil {
+			onNewLeader(identity)
+		}
+	}
+	return nil
+}
+
+// IsLeader returns whether this replica is the leader.
+func (m *Manager) IsLeader() bool {
+	m.Lock()
+	defer m.Unlock()
+	return m.leaderCTX != nil
+}
+
+// CurrentLeader returns the identity of the current leader, empty if no leader was observed yet.
+func (m *Manager) CurrentLeader() string {
+	m.Lock()
+	defer m.Unlock()
+	return m.currentLeader
+}
+
+// LeaderSince returns when this replica became the leader, the zero time if it is not the leader.
+func (m *Manager) LeaderSince() time.Time {
+	m.Lock()
+	defer m.Unlock()
+	if m.leaderCTX == nil {
+		return time.Time{}
+	}
+	return m.leaderSince
+}
+
+// campaign runs the leader election until ctx is done, re-entering it whenever leadership is lost.
+func (m *Manager) campaign(ctx context.Context) {
+	for {
+		err := Run(ctx, m.namespace, m.name, m.k8s, m.startedLeading, m.opts)
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, ErrLeadershipLost) {
+			logrus.Warnf("%v, re-entering leader election", err)
+			continue
+		}
+		logrus.Errorf("leader election for %s failed: %v", m.name, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryDelay):
+		}
+	}
+}
+
+// startedLeading starts a new term, calling all callbacks with its context.
+func (m *Manager) startedLeading(ctx context.Context) {
+	m.Lock()
+	m.leaderCTX = ctx
+	m.leaderSince = time.Now()
+	callbacks := append([]func(context.Context){}, m.callbacks...)
+	m.Unlock()
+	observeLeading(m.name, true)
+
+	for _, cb := range callbacks {
+		go cb(ctx)
+	}
+
+	<-ctx.Done()
+	m.Lock()
+	if m.leaderCTX == ctx {
+		m.leaderCTX = nil
+		observeLeading(m.name, false)
+	}
+	m.Unlock()
+}
+
+// register adds a callback, calling it right away if a term is in progress.
+func (m *Manager) register(cb func(ctx context.Context)) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.callbacks = append(m.callbacks, cb)
+	if m.leaderCTX != nil {
+		go cb(m.leaderCTX)
+	}
 }
 
 // OnLeaderOrDie this function will be called when leadership is acquired or die if failed
 func (m *Manager) OnLeaderOrDie(name string, f func(ctx context.Context) error) {
-	go func() {
-		<-m.leaderChan
-		if err := f(m.leaderCTX); err != nil {
+	m.register(func(ctx context.Context) {
+		if err := f(ctx); err != nil {
 			logrus.Fatalf("%s leader func failed be executed: %v", name, err)
 		} else {
 			logrus.Infof("%s leader func executed successfully", name)
 		}
-	}()
+	})
 }
 
 // OnLeader this function will be called when leadership is acquired.
 func (m *Manager) OnLeader(f func(ctx context.Context) error) {
-	go func() {
-		<-m.leaderChan
+	m.register(func(ctx context.Context) {
 		for {
-			if err := f(m.leaderCTX); err != nil {
+			if err := f(ctx); err != nil {
 				logrus.Errorf("failed to call leader func: %v", err)
-				time.Sleep(5 * time.Second)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(retryDelay):
+				}
 				continue
 			}
 			break
 		}
-	}()
+	})
 }
@@ -0,0 +1,107 @@
+package leader
+
+import (
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricsEnv      = "CATTLE_PROMETHEUS_METRICS"
+	leaderSubsystem = "wrangler_leader_election"
+
+	nameLabel   = "name"
+	resultLabel = "result"
+
+	resultSuccess = "success"
+	resultError   = "error"
+)
+
+var (
+	prometheusMetrics = false
+
+	isLeader = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Subsystem: leaderSubsystem,
+			Name:      "is_leader",
+			Help:      "Whether this replica is the leader of the election, 1 if it is and 0 if it is not",
+		},
+		[]string{nameLabel},
+	)
+
+	totalTransitions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: leaderSubsystem,
+			Name:      "total_transitions",
+			Help:      "Total count of leader changes observed per election",
+		},
+		[]string{nameLabel},
+	)
+
+	renewDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: leaderSubsystem,
+			Name:      "renew_duration_seconds",
+			Help:      "Histogram of the durations of lock renewals of the leader per election and result",
+		},
+		[]string{nameLabel, resultLabel},
+	)
+
+	lastRenewTimestamp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Subsystem: leaderSubsystem,
+			Name:      "last_renew_timestamp_seconds",
+			Help:      "Unix time of the last successful lock renewal of the leader per election",
+		},
+		[]string{nameLabel},
+	)
+)
+
+func init() {
+	if os.Getenv(metricsEnv) == "true" {
+		MustRegisterMetrics(prometheus.DefaultRegisterer)
+	}
+}
+
+// MustRegisterMetrics registers the leader election metrics of Managers with the provided registerer.
+func MustRegisterMetrics(registerer prometheus.Registerer) {
+	prometheusMetrics = true
+	registerer.MustRegister(
+		isLeader,
+		totalTransitions,
+		renewDuration,
+		lastRenewTimestamp,
+	)
+}
+
+func observeLeading(name string, leading bool) {
+	if !prometheusMetrics {
+		return
+	}
+	value := 0.0
+	if leading {
+		value = 1
+	}
+	isLeader.WithLabelValues(name).Set(value)
+}
+
+func observeTransition(name string) {
+	if !prometheusMetrics {
+		return
+	}
+	totalTransitions.WithLabelValues(name).Inc()
+}
+
+func observeRenew(name string, err error, start, end time.Time) {
+	if !prometheusMetrics {
+		return
+	}
+	result := resultSuccess
+	if err != nil {
+		result = resultError
+	} else {
+		lastRenewTimestamp.WithLabelValues(name).Set(float64(end.Unix()))
+	}
+	renewDuration.WithLabelValues(name, result).Observe(end.Sub(start).Seconds())
+}
@@ -0,0 +1,146 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// testOptions returns Options with short lease timings, clearing the environment variables that would override them.
+func testOptions(t *testing.T, identity string) Options {
+	for _, key := range []string{devModeEnvKey, leaseDurationEnvKey, renewDeadlineEnvKey, retryPeriodEnvKey} {
+		t.Setenv(key, "")
+	}
+	return Options{
+		Identity:      identity,
+		LeaseDuration: 600 * time.Millisecond,
+		RenewDeadline: 400 * time.Millisecond,
+		RetryPeriod:   50 * time.Millisecond,
+	}
+}
+
+// stealLease makes another candidate the holder of the lease for leaseSeconds.
+func stealLease(t *testing.T, client kubernetes.Interface, leaseSeconds int32) {
+	t.Helper()
+	lease, err := client.CoordinationV1().Leases("ns").Get(context.Background(), "lock", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := "other"
+	now := metav1.NewMicroTime(time.Now())
+	lease.Spec.HolderIdentity = &other
+	lease.Spec.RenewTime = &now
+	lease.Spec.AcquireTime = &now
+	lease.Spec.LeaseDurationSeconds = &leaseSeconds
+	if _, err := client.CoordinationV1().Leases("ns").Update(context.Background(), lease, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRun_ContextDone(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	leaderCtx := make(chan context.Context, 1)
+	result := make(chan error, 1)
+	go func() {
+		result <- Run(ctx, "ns", "lock", client, func(ctx context.Context) {
+			leaderCtx <- ctx
+		}, testOptions(t, "a"))
+	}()
+
+	var lctx context.Context
+	select {
+	case lctx = <-leaderCtx:
+	case <-time.After(5 * time.Second):
+		t.Fatal("did not become leader")
+	}
+
+	cancel()
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("Run() = %v, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return")
+	}
+	if lctx.Err() == nil {
+		t.Error("leader context was not canceled")
+	}
+}
+
+func TestRun_LeadershipLost(t *testing.T) {
+	client := fake.NewSimpleClientset()
+
+	leaderCtx := make(chan context.Context, 1)
+	result := make(chan error, 1)
+	go func() {
+		result <- Run(context.Background(), "ns", "lock", client, func(ctx context.Context) {
+			leaderCtx <- ctx
+		}, testOptions(t, "a"))
+	}()
+
+	var lctx context.Context
+	select {
+	case lctx = <-leaderCtx:
+	case <-time.After(5 * time.Second):
+		t.Fatal("did not become leader")
+	}
+
+	stealLease(t, client, 60)
+	select {
+	case err := <-result:
+		if !errors.Is(err, ErrLeadershipLost) {
+			t.Errorf("Run() = %v, want ErrLeadershipLost", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return")
+	}
+	if lctx.Err() == nil {
+		t.Error("leader context was not canceled")
+	}
+}
+
+func TestManager_Recampaign(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	opts := testOptions(t, "a")
+	opts.Recampaign = true
+	m := NewManagerWithOptions("ns", "lock", client, opts)
+
+	terms := make(chan context.Context, 2)
+	m.OnLeader(func(ctx context.Context) error {
+		terms <- ctx
+		return nil
+	})
+	m.Start(ctx)
+
+	var first context.Context
+	select {
+	case first = <-terms:
+	case <-time.After(5 * time.Second):
+		t.Fatal("did not become leader")
+	}
+
+	// the lease is held by another candidate for a second, after which it is acquired again
+	stealLease(t, client, 1)
+	select {
+	case second := <-terms:
+		if first.Err() == nil {
+			t.Error("context of the first term was not canceled")
+		}
+		if second.Err() != nil {
+			t.Error("context of the second term is canceled")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("did not become leader again")
+	}
+}
@@ -0,0 +1,418 @@
+package leader
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+)
+
+const (
+	// shardGroupLabel is set on the member Leases of a Sharder to its name, so live replicas can be counted.
+	shardGroupLabel = "wrangler.cattle.io/shard-group"
+	// staleMemberLeaseDurations is how many lease durations a member Lease is kept without being renewed, before
+	// its replica is considered gone for good and the Lease deleted.
+	staleMemberLeaseDurations = 10
+)
+
+// ShardOptions configure a Sharder.
+type ShardOptions struct {
+	// Options set the identity and lease timings of the replica. LockType and Lock are not used, shards are
+	// always Leases.
+	Options
+	// Shards is the number of shards keys are spread over. Defaults to 1.
+	Shards int
+	// ByNamespace shards keys by namespace instead of by namespace/name, so all objects of a namespace are
+	// handled by the same replica.
+	ByNamespace bool
+	// OnShardsChanged is called with the sorted list of shards the replica owns whenever it changes.
+	OnShardsChanged func(shards []int)
+}
+
+// Sharder spreads N shards over the live replicas using one Lease per shard. Every replica renews a member Lease,
+// and claims free or expired shards until it owns its share of them, releasing shards when it owns more than its
+// share because replicas were added. A key belongs to the shard its hash maps to.
+//
+// Sharder implements generic.KeyFilter, so setting it as KeyFilter of a generic.Factory makes controllers only
+// handle the keys of the shards the replica owns.
+type Sharder struct {
+	namespace string
+	name      string
+	k8s       kubernetes.Interface
+	opts      ShardOptions
+	identity  string
+
+	lock sync.RWMutex
+	// renewed is when the Lease of each owned shard was last acquired or renewed.
+	renewed   map[int]time.Time
+	callbacks []shardCallback
+	seq       int
+}
+
+type shardCallback struct {
+	id  int
+	ctx context.Context
+	f   func()
+}
+
+// NewSharder returns a Sharder using Leases named after name in namespace.
+func NewSharder(namespace, name string, k8s kubernetes.Interface, opts ShardOptions) (*Sharder, error) {
+	if namespace == "" {
+		namespace = "kube-system"
+	}
+	if opts.Shards <= 0 {
+		opts.Shards = 1
+	}
+	id, err := identity(opts.Options)
+	if err != nil {
+		return nil, err
+	}
+	config, err := computeConfig(nil, leaderelection.LeaderCallbacks{}, opts.Options)
+	if err != nil {
+		return nil, err
+	}
+	opts.LeaseDuration = config.LeaseDuration
+	opts.RenewDeadline = config.RenewDeadline
+	opts.RetryPeriod = config.RetryPeriod
+
+	return &Sharder{
+		namespace: namespace,
+		name:      name,
+		k8s:       k8s,
+		opts:      opts,
+		identity:  id,
+		renewed:   map[int]time.Time{},
+	}, nil
+}
+
+// Start claims and renews shards until ctx is done, then releases them.
+func (s *Sharder) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.opts.RetryPeriod)
+		defer ticker.Stop()
+		for {
+			s.sync(ctx)
+			select {
+			case <-ctx.Done():
+				s.release()
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Shard returns the shard of the key.
+func (s *Sharder) Shard(key string) int {
+	if s.opts.ByNamespace {
+		// cluster scoped keys have no namespace and are sharded by name
+		if namespace, _, ok := strings.Cut(key, "/"); ok {
+			key = namespace
+		}
+	}
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key))
+	return int(hash.Sum32() % uint32(s.opts.Shards))
+}
+
+// Owns returns whether the replica owns the shard.
+func (s *Sharder) Owns(shard int) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.owns(shard, time.Now())
+}
+
+// owns must be called with the lock held. A shard that was not renewed within the renew deadline is not owned
+// anymore, as another replica could acquire it soon.
+func (s *Sharder) owns(shard int, now time.Time) bool {
+	renewed, ok := s.renewed[shard]
+	return ok && now.Sub(renewed) < s.opts.RenewDeadline
+}
+
+// OwnedShards returns the sorted list of shards the replica owns.
+func (s *Sharder) OwnedShards() []int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.ownedShards(time.Now())
+}
+
+func (s *Sharder) ownedShards(now time.Time) []int {
+	var result []int
+	for shard := range s.renewed {
+		if s.owns(shard, now) {
+			result = append(result, shard)
+		}
+	}
+	sort.Ints(result)
+	return result
+}
+
+// Accepts returns whether the key belongs to a shard the replica owns.
+func (s *Sharder) Accepts(key string) bool {
+	return s.Owns(s.Shard(key))
+}
+
+// OnChange registers f to be called when the shards the replica owns change, until ctx is done.
+func (s *Sharder) OnChange(ctx context.Context, f func()) {
+	s.lock.Lock()
+	s.seq++
+	id := s.seq
+	s.callbacks = append(s.callbacks, shardCallback{id: id, ctx: ctx, f: f})
+	s.lock.Unlock()
+
+	context.AfterFunc(ctx, func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		for i, cb := range s.callbacks {
+			if cb.id == id {
+				s.callbacks = append(s.callbacks[:i:i], s.callbacks[i+1:]...)
+				break
+			}
+		}
+	})
+}
+
+func (s *Sharder) shardLeaseName(shard int) string {
+	return fmt.Sprintf("%s-shard-%d", s.name, shard)
+}
+
+func (s *Sharder) memberLeaseName() string {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(s.identity))
+	return fmt.Sprintf("%s-member-%08x", s.name, hash.Sum32())
+}
+
+// sync renews the member Lease and the shard Leases of the replica, claiming or releasing shards to get to its
+// share.
+func (s *Sharder) sync(ctx context.Context) {
+	before := s.OwnedShards()
+
+	if err := s.renewMember(ctx); err != nil {
+		logrus.Errorf("failed to renew member lease of %s: %v", s.name, err)
+	}
+	target, err := s.target(ctx)
+	if err != nil {
+		logrus.Errorf("failed to count members of %s: %v", s.name, err)
+		target = len(before)
+	}
+
+	owned := len(before)
+	for shard := 0; shard < s.opts.Shards; shard++ {
+		holding := s.Owns(shard)
+		switch {
+		case holding && owned > target:
+			s.releaseShard(ctx, shard)
+			owned--
+		case holding:
+			if !s.tryAcquireOrRenew(ctx, shard) {
+				owned--
+			}
+		case owned < target:
+			if s.tryAcquireOrRenew(ctx, shard) {
+				owned++
+			}
+		}
+	}
+
+	s.lock.Lock()
+	after := s.ownedShards(time.Now())
+	callbacks := s.callbacks
+	s.lock.Unlock()
+
+	if fmt.Sprint(before) != fmt.Sprint(after) {
+		logrus.Infof("%s owns shards %v of %s", s.identity, after, s.name)
+		if s.opts.OnShardsChanged != nil {
+			s.opts.OnShardsChanged(after)
+		}
+		for _, cb := range callbacks {
+			if cb.ctx.Err() == nil {
+				cb.f()
+			}
+		}
+	}
+}
+
+// target returns how many shards the replica should own: its share of the shards over the live members. Each member
+// gets the same number of shards and the first members, sorted by member Lease name, one more for the remainder, so
+// every member owns a shard when there are at least as many shards as members. Member Leases of replicas that
+// stopped without releasing them are deleted once stale.
+func (s *Sharder) target(ctx context.Context) (int, error) {
+	leases, err := s.k8s.CoordinationV1().Leases(s.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.Set{shardGroupLabel: s.name}.String(),
+	})
+	if err != nil {
+		return 0, err
+	}
+	self := s.memberLeaseName()
+	// the replica is a member even if its member Lease failed to be renewed
+	members := []string{self}
+	now := time.Now()
+	for i := range leases.Items {
+		lease := &leases.Items[i]
+		switch {
+		case lease.Name == self:
+		case !expired(lease, now):
+			members = append(members, lease.Name)
+		case s.stale(lease, now):
+			s.deleteMember(ctx, lease)
+		}
+	}
+	sort.Strings(members)
+
+	target := s.opts.Shards / len(members)
+	if sort.SearchStrings(members, self) < s.opts.Shards%len(members) {
+		target++
+	}
+	return target, nil
+}
+
+// stale returns whether the member Lease was not renewed for staleMemberLeaseDurations lease durations.
+func (s *Sharder) stale(lease *coordinationv1.Lease, now time.Time) bool {
+	renewed := lease.CreationTimestamp.Time
+	if lease.Spec.RenewTime != nil {
+		renewed = lease.Spec.RenewTime.Time
+	}
+	return now.Sub(renewed) > staleMemberLeaseDurations*s.opts.LeaseDuration
+}
+
+// deleteMember deletes the member Lease of a replica that is gone, unless it was renewed in the meantime.
+func (s *Sharder) deleteMember(ctx context.Context, lease *coordinationv1.Lease) {
+	err := s.k8s.CoordinationV1().Leases(s.namespace).Delete(ctx, lease.Name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{ResourceVersion: &lease.ResourceVersion},
+	})
+	if err != nil && !apierror.IsNotFound(err) {
+		logrus.Debugf("failed to delete stale member lease %s of %s: %v", lease.Name, s.name, err)
+	}
+}
+
+func (s *Sharder) renewMember(ctx context.Context) error {
+	leases := s.k8s.CoordinationV1().Leases(s.namespace)
+	lease, err := leases.Get(ctx, s.memberLeaseName(), metav1.GetOptions{})
+	if apierror.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      s.memberLeaseName(),
+				Namespace: s.namespace,
+				Labels:    map[string]string{shardGroupLabel: s.name},
+			},
+		}
+		s.hold(lease, time.Now())
+		_, err = leases.Create(ctx, lease, metav1.CreateOptions{})
+		return err
+	} else if err != nil {
+		return err
+	}
+	s.hold(lease, time.Now())
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	return err
+}
+
+// tryAcquireOrRenew returns whether the replica holds the Lease of the shard after trying to acquire or renew it.
+func (s *Sharder) tryAcquireOrRenew(ctx context.Context, shard int) bool {
+	leases := s.k8s.CoordinationV1().Leases(s.namespace)
+	now := time.Now()
+
+	lease, err := leases.Get(ctx, s.shardLeaseName(shard), metav1.GetOptions{})
+	if apierror.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      s.shardLeaseName(shard),
+				Namespace: s.namespace,
+			},
+		}
+		s.hold(lease, now)
+		_, err = leases.Create(ctx, lease, metav1.CreateOptions{})
+	} else if err == nil {
+		if holder := lease.Spec.HolderIdentity; holder != nil && *holder != "" && *holder != s.identity && !expired(lease, now) {
+			s.drop(shard)
+			return false
+		}
+		if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != s.identity {
+			acquired := metav1.NewMicroTime(now)
+			lease.Spec.AcquireTime = &acquired
+		}
+		s.hold(lease, now)
+		// the update fails on a conflict if another replica acquired the shard in the meantime
+		_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		logrus.Debugf("failed to acquire or renew shard %d of %s: %v", shard, s.name, err)
+		return s.Owns(shard)
+	}
+
+	s.lock.Lock()
+	s.renewed[shard] = now
+	s.lock.Unlock()
+	return true
+}
+
+// releaseShard gives up the shard so another replica can acquire it right away.
+func (s *Sharder) releaseShard(ctx context.Context, shard int) {
+	s.drop(shard)
+
+	leases := s.k8s.CoordinationV1().Leases(s.namespace)
+	lease, err := leases.Get(ctx, s.shardLeaseName(shard), metav1.GetOptions{})
+	if err != nil || lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != s.identity {
+		return
+	}
+	lease.Spec.HolderIdentity = nil
+	lease.Spec.RenewTime = nil
+	if _, err := leases.Update(ctx, lease, metav1.UpdateOptions{}); err != nil {
+		logrus.Debugf("failed to release shard %d of %s: %v", shard, s.name, err)
+	}
+}
+
+// release gives up all shards and the membership of the replica.
+func (s *Sharder) release() {
+	// ctx is done, give the requests a context of their own
+	ctx, cancel := context.WithTimeout(context.Background(), s.opts.RenewDeadline)
+	defer cancel()
+
+	for _, shard := range s.OwnedShards() {
+		s.releaseShard(ctx, shard)
+	}
+	err := s.k8s.CoordinationV1().Leases(s.namespace).Delete(ctx, s.memberLeaseName(), metav1.DeleteOptions{})
+	if err != nil && !apierror.IsNotFound(err) {
+		logrus.Debugf("failed to delete member lease of %s: %v", s.name, err)
+	}
+}
+
+func (s *Sharder) drop(shard int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.renewed, shard)
+}
+
+// hold sets the replica as holder of the Lease.
+func (s *Sharder) hold(lease *coordinationv1.Lease, now time.Time) {
+	renewed := metav1.NewMicroTime(now)
+	seconds := int32(s.opts.LeaseDuration / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	lease.Spec.HolderIdentity = &s.identity
+	lease.Spec.RenewTime = &renewed
+	lease.Spec.LeaseDurationSeconds = &seconds
+	if lease.Spec.AcquireTime == nil {
+		lease.Spec.AcquireTime = &renewed
+	}
+}
+
+// expired returns whether the Lease was not renewed within its duration.
+func expired(lease *coordinationv1.Lease, now time.Time) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	return now.After(lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second))
+}
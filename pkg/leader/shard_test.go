@@ -0,0 +1,187 @@
+package leader
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestSharder(t *testing.T, client kubernetes.Interface, identity string, shards int) *Sharder {
+	t.Helper()
+	s, err := NewSharder("ns", "group", client, ShardOptions{
+		Options: testOptions(t, identity),
+		Shards:  shards,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSharder_Rebalance(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	ctx := context.Background()
+	a := newTestSharder(t, client, "a", 4)
+	b := newTestSharder(t, client, "b", 4)
+
+	var changes [][]int
+	a.opts.OnShardsChanged = func(shards []int) {
+		changes = append(changes, shards)
+	}
+
+	a.sync(ctx)
+	if got := a.OwnedShards(); len(got) != 4 {
+		t.Fatalf("a owns %v, want all shards", got)
+	}
+
+	// b joins, a gives up its extra shards on its next sync and b claims them on its next
+	b.sync(ctx)
+	if got := b.OwnedShards(); len(got) != 0 {
+		t.Fatalf("b owns %v while a holds all shards", got)
+	}
+	a.sync(ctx)
+	b.sync(ctx)
+	if got := a.OwnedShards(); len(got) != 2 {
+		t.Fatalf("a owns %v, want 2 shards", got)
+	}
+	if got := b.OwnedShards(); len(got) != 2 {
+		t.Fatalf("b owns %v, want 2 shards", got)
+	}
+	for shard := 0; shard < 4; shard++ {
+		if a.Owns(shard) == b.Owns(shard) {
+			t.Fatalf("shard %d is owned by both or neither replica", shard)
+		}
+	}
+	if len(changes) != 2 {
+		t.Fatalf("OnShardsChanged called %d times, want 2", len(changes))
+	}
+
+	// a stops, b takes over all shards
+	a.release()
+	b.sync(ctx)
+	if got := b.OwnedShards(); len(got) != 4 {
+		t.Fatalf("b owns %v after a stopped, want all shards", got)
+	}
+}
+
+func TestSharder_Remainder(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	ctx := context.Background()
+	sharders := []*Sharder{
+		newTestSharder(t, client, "a", 4),
+		newTestSharder(t, client, "b", 4),
+		newTestSharder(t, client, "c", 4),
+	}
+
+	// all replicas join, then sync until the shards released by the first are claimed by the others
+	for round := 0; round < 3; round++ {
+		for _, s := range sharders {
+			s.sync(ctx)
+		}
+	}
+
+	total := 0
+	for _, s := range sharders {
+		got := s.OwnedShards()
+		if len(got) < 1 || len(got) > 2 {
+			t.Fatalf("%s owns %v, want 1 or 2 of 4 shards over 3 replicas", s.identity, got)
+		}
+		total += len(got)
+	}
+	if total != 4 {
+		t.Fatalf("replicas own %d shards, want 4", total)
+	}
+}
+
+func TestSharder_StaleMembers(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	ctx := context.Background()
+	s := newTestSharder(t, client, "a", 4)
+
+	member := func(name string, renewed time.Time) {
+		t.Helper()
+		renewTime := metav1.NewMicroTime(renewed)
+		seconds := int32(1)
+		_, err := client.CoordinationV1().Leases("ns").Create(ctx, &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "ns",
+				Labels:    map[string]string{shardGroupLabel: "group"},
+			},
+			Spec: coordinationv1.LeaseSpec{RenewTime: &renewTime, LeaseDurationSeconds: &seconds},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	member("group-member-expired", time.Now().Add(-2*time.Second))
+	member("group-member-stale", time.Now().Add(-time.Hour))
+
+	s.sync(ctx)
+	if got := s.OwnedShards(); len(got) != 4 {
+		t.Fatalf("a owns %v, want all shards with the other members expired", got)
+	}
+	if _, err := client.CoordinationV1().Leases("ns").Get(ctx, "group-member-expired", metav1.GetOptions{}); err != nil {
+		t.Fatalf("recently expired member lease was deleted: %v", err)
+	}
+	if _, err := client.CoordinationV1().Leases("ns").Get(ctx, "group-member-stale", metav1.GetOptions{}); !apierror.IsNotFound(err) {
+		t.Fatalf("stale member lease was not deleted: %v", err)
+	}
+}
+
+func TestSharder_Accepts(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	s := newTestSharder(t, client, "a", 8)
+	s.opts.ByNamespace = true
+
+	if s.Shard("ns1/a") != s.Shard("ns1/b") {
+		t.Fatal("keys of the same namespace are in different shards")
+	}
+	if s.Shard("ns1") != s.Shard("ns1/a") {
+		t.Fatal("cluster scoped key is not sharded by name")
+	}
+	if s.Accepts("ns1/a") {
+		t.Fatal("key accepted before any shard is owned")
+	}
+
+	changed := make(chan struct{}, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	s.OnChange(ctx, func() { changed <- struct{}{} })
+	s.sync(ctx)
+	if !s.Accepts("ns1/a") {
+		t.Fatal("key not accepted after all shards are owned")
+	}
+	select {
+	case <-changed:
+	default:
+		t.Fatal("OnChange callback not called")
+	}
+
+	// callbacks are not called after their context is done, and are removed
+	cancel()
+	s.release()
+	s.sync(context.Background())
+	select {
+	case <-changed:
+		t.Fatal("OnChange callback called after its context was done")
+	default:
+	}
+	for i := 0; ; i++ {
+		s.lock.RLock()
+		remaining := len(s.callbacks)
+		s.lock.RUnlock()
+		if remaining == 0 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("%d OnChange callbacks left after their context was done", remaining)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,419 @@
+package needacert
+
+import (
+	"bytes"
+	"crypto"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math"
+	"math/big"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/cert"
+)
+
+var (
+	// CASecretAnnotation sets the name of the Secret in the Service's namespace that holds the long-lived CA the
+	// certificate of the Service is issued from, instead of DefaultCASecretName. The Secret is created if it does
+	// not exist and can be shared by any number of Services. Webhooks and CRDs of the Service get the CA bundle of
+	// that Secret, which only changes on a CA rollover.
+	CASecretAnnotation = "need-a-cert.cattle.io/ca-secret-name"
+	// SelfSignedAnnotation set to "true" opts a Service out of the CA Secret, signing its certificate with a new
+	// self-signed CA on every rotation. The CA bundle of its webhooks and CRDs then changes on every rotation.
+	SelfSignedAnnotation = "need-a-cert.cattle.io/self-signed"
+	// caPendingSinceAnnotation records when the pending CA of a CA Secret was published.
+	caPendingSinceAnnotation = "need-a-cert.cattle.io/ca-pending-since"
+)
+
+const (
+	// DefaultCASecretName is the name of the Secret in the Service's namespace that holds the CA certificates are
+	// issued from when neither CASecretAnnotation nor an Issuer is set.
+	DefaultCASecretName = "need-a-cert-ca"
+
+	// CABundleKey is the key in the CA Secret and in the TLS Secret of a Service using a CA Secret that holds the
+	// PEM encoded CA certificates clients should trust.
+	CABundleKey = "ca.crt"
+
+	caPendingCertKey = "pending.crt"
+	caPendingKeyKey  = "pending.key"
+
+	caValidity = 10 * 365 * 24 * time.Hour
+	// caRenewBefore is how long before the CA expires a new CA is generated.
+	caRenewBefore = 365 * 24 * time.Hour
+	// caRolloverDelay is how long a new CA is only published in the CA bundle before certificates are issued from it,
+	// so clients trust the new CA before any server presents a certificate signed by it.
+	caRolloverDelay = 24 * time.Hour
+)
+
+// certAuthority is a CA loaded from a CA Secret.
+type certAuthority struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+	// bundle is the PEM encoded list of all CA certificates that should be trusted.
+	bundle []byte
+	// nextRollover is when the CA Secret needs to be checked again, zero if no rollover is in progress.
+	nextRollover time.Time
+}
+
+// caSecretNameOf returns the name of the CA Secret the certificate of the owner is issued from, empty if the owner
+// does not use a CA Secret.
+func (h *handler) caSecretNameOf(owner metav1.Object) string {
+	annotations := owner.GetAnnotations()
+	if name := annotations[CASecretAnnotation]; name != "" {
+		return name
+	}
+	if annotations[SelfSignedAnnotation] == "true" || h.opts.Issuer != nil || h.opts.SelfSigned {
+		return ""
+	}
+	return DefaultCASecretName
+}
+
+// certAuthorityFor returns the CA the certificate of the owner is issued from, nil if the owner does not use a CA Secret.
+func (h *handler) certAuthorityFor(owner metav1.Object) (*certAuthority, error) {
+	caSecretName := h.caSecretNameOf(owner)
+	if caSecretName == "" {
+		return nil, nil
+	}
+	return h.ensureCA(owner.GetNamespace(), caSecretName)
+}
+
+// newSelfSignedCA returns a throwaway CA that is only used to sign a single certificate, the same as
+// cert.GenerateSelfSignedCertKey. The CA is valid as long as the certificate.
+func newSelfSignedCA(name string, cfg certConfig) (*certAuthority, error) {
+	certPEM, keyPEM, err := generateCA(name, time.Now(), cfg.validity, cfg.keyAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	return parseCA(certPEM, keyPEM)
+}
+
+// ensureCA returns the CA stored in the Secret, creating the Secret or rolling over the CA if needed.
+func (h *handler) ensureCA(namespace, name string) (*certAuthority, error) {
+	lockKey := "ca:" + namespace + "/" + name
+	h.locker.Lock(lockKey)
+	defer h.locker.Unlock(lockKey)
+
+	secret, err := h.secretsCache.Get(namespace, name)
+	if apierror.IsNotFound(err) {
+		newSecret, err := newCASecret(namespace, name, h.opts.KeyAlgorithm)
+		if err != nil {
+			return nil, err
+		}
+		logrus.Infof("Creating CA secret %s/%s", namespace, name)
+		secret, err = h.secrets.Create(newSecret)
+		if apierror.IsAlreadyExists(err) {
+			secret, err = h.secrets.Get(namespace, name, metav1.GetOptions{})
+			if err != nil {
+				return nil, err
+			}
+		} else if err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	updated, err := rolloverCA(secret, time.Now(), h.opts.KeyAlgorithm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to roll over CA in secret %s/%s: %w", namespace, name, err)
+	}
+	if updated != nil {
+		logrus.Infof("Updating CA secret %s/%s", namespace, name)
+		secret, err = h.secrets.Update(updated)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return loadCA(secret)
+}
+
+// newCASecret returns a Secret holding a newly generated CA.
+func newCASecret(namespace, name string, algorithm KeyAlgorithm) (*corev1.Secret, error) {
+	caCert, caKey, err := generateCA(namespace+"-"+name, time.Now(), caValidity, algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       caCert,
+			corev1.TLSPrivateKeyKey: caKey,
+			CABundleKey:             caCert,
+		},
+		Type: corev1.SecretTypeTLS,
+	}, nil
+}
+
+// rolloverCA returns an updated copy of the CA Secret if the CA needs to be rolled over, nil otherwise.
+// A rollover happens in two steps. When the active CA is about to expire a pending CA is generated and added
+// to the CA bundle. Once the pending CA has been published for caRolloverDelay it replaces the active CA.
+// Old CAs stay in the bundle until they expire, so certificates issued by them remain trusted.
+func rolloverCA(secret *corev1.Secret, now time.Time, algorithm KeyAlgorithm) (*corev1.Secret, error) {
+	active, err := cert.ParseCertsPEM(secret.Data[corev1.TLSCertKey])
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string][]byte{}
+	for k, v := range secret.Data {
+		data[k] = v
+	}
+	annotations := map[string]string{}
+	for k, v := range secret.Annotations {
+		annotations[k] = v
+	}
+
+	pendingCert := data[caPendingCertKey]
+	if len(pendingCert) > 0 {
+		pendingSince, err := time.Parse(time.RFC3339, annotations[caPendingSinceAnnotation])
+		if err != nil || !now.Before(pendingSince.Add(caRolloverDelay)) {
+			logrus.Infof("Activating pending CA in secret %s/%s", secret.Namespace, secret.Name)
+			data[corev1.TLSCertKey] = pendingCert
+			data[corev1.TLSPrivateKeyKey] = data[caPendingKeyKey]
+			delete(data, caPendingCertKey)
+			delete(data, caPendingKeyKey)
+			delete(annotations, caPendingSinceAnnotation)
+		}
+	} else if now.Add(caRenewBefore).After(active[0].NotAfter) {
+		logrus.Infof("Generating pending CA in secret %s/%s", secret.Namespace, secret.Name)
+		caCert, caKey, err := generateCA(secret.Namespace+"-"+secret.Name, now, caValidity, algorithm)
+		if err != nil {
+			return nil, err
+		}
+		data[caPendingCertKey] = caCert
+		data[caPendingKeyKey] = caKey
+		annotations[caPendingSinceAnnotation] = now.UTC().Format(time.RFC3339)
+	}
+
+	bundle, err := caBundle(now, data[corev1.TLSCertKey], data[caPendingCertKey], data[CABundleKey])
+	if err != nil {
+		return nil, err
+	}
+	data[CABundleKey] = bundle
+
+	if secretDataEqual(secret.Data, data) && stringMapsEqual(secret.Annotations, annotations) {
+		return nil, nil
+	}
+
+	secret = secret.DeepCopy()
+	secret.Data = data
+	secret.Annotations = annotations
+	return secret, nil
+}
+
+// caBundle returns the PEM encoded certificates of the active CA, the pending CA and all previous CAs that did not expire.
+func caBundle(now time.Time, activePEM, pendingPEM, previousPEM []byte) ([]byte, error) {
+	var (
+		buf  bytes.Buffer
+		seen = map[string]bool{}
+	)
+	for _, pemData := range [][]byte{activePEM, pendingPEM, previousPEM} {
+		if len(pemData) == 0 {
+			continue
+		}
+		certs, err := cert.ParseCertsPEM(pemData)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range certs {
+			if seen[string(c.Raw)] || now.After(c.NotAfter) {
+				continue
+			}
+			seen[string(c.Raw)] = true
+			encoded, err := cert.EncodeCertificates(c)
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(encoded)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// loadCA parses the active CA of the CA Secret.
+func loadCA(secret *corev1.Secret) (*certAuthority, error) {
+	ca, err := parseCA(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CA of secret %s/%s: %w", secret.Namespace, secret.Name, err)
+	}
+	ca.bundle = secret.Data[CABundleKey]
+	if pendingSince, err := time.Parse(time.RFC3339, secret.Annotations[caPendingSinceAnnotation]); err == nil {
+		ca.nextRollover = pendingSince.Add(caRolloverDelay)
+	}
+	return ca, nil
+}
+
+// parseCA parses a PEM encoded CA certificate and key.
+func parseCA(certPEM, keyPEM []byte) (*certAuthority, error) {
+	keyPair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	caCert, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := keyPair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("CA key of type %T can not sign", keyPair.PrivateKey)
+	}
+	return &certAuthority{
+		cert:   caCert,
+		key:    signer,
+		bundle: certPEM,
+	}, nil
+}
+
+// issued returns whether the certificate was signed by the CA.
+func (c *certAuthority) issued(leaf *x509.Certificate) bool {
+	return leaf.CheckSignatureFrom(c.cert) == nil
+}
+
+// issue returns a PEM encoded certificate chain and key for the given names signed by the CA.
+// The first DNS name of the certificate is the common name, followed by dnsNames, the same as
+// cert.GenerateSelfSignedCertKey.
+func (c *certAuthority) issue(commonName string, dnsNames []string, cfg certConfig) ([]byte, []byte, error) {
+	key, err := generateKey(cfg.keyAlgorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	validFrom := time.Now().Add(-time.Hour)
+	notAfter := validFrom.Add(cfg.validity)
+	if notAfter.After(c.cert.NotAfter) {
+		notAfter = c.cert.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName: commonName,
+		},
+		NotBefore:             validFrom,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              append([]string{commonName}, dnsNames...),
+		IPAddresses:           cfg.ipAddresses,
+	}
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+
+	der, err := x509.CreateCertificate(cryptorand.Reader, template, c.cert, key.Public(), c.key)
+	if err != nil {
+		return nil, nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM, err := cert.EncodeCertificates(leaf, c.cert)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := marshalPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return certPEM, keyPEM, nil
+}
+
+// generateCA returns a PEM encoded self-signed CA certificate and key valid from now.
+func generateCA(name string, now time.Time, validity time.Duration, algorithm KeyAlgorithm) ([]byte, []byte, error) {
+	key, err := generateKey(algorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	validFrom := now.Add(-time.Hour)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName: fmt.Sprintf("%s-ca@%d", name, now.Unix()),
+		},
+		NotBefore:             validFrom,
+		NotAfter:              validFrom.Add(validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+
+	der, err := x509.CreateCertificate(cryptorand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, nil, err
+	}
+	caCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM, err := cert.EncodeCertificates(caCert)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := marshalPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return certPEM, keyPEM, nil
+}
+
+// newSerial returns a uniform random serial number in [1, MaxInt64).
+func newSerial() (*big.Int, error) {
+	serial, err := cryptorand.Int(cryptorand.Reader, new(big.Int).SetInt64(math.MaxInt64-1))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).Add(serial, big.NewInt(1)), nil
+}
+
+func secretDataEqual(a, b map[string][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if other, ok := b[k]; !ok || !bytes.Equal(v, other) {
+			return false
+		}
+	}
+	return true
+}
+
+func stringMapsEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if other, ok := b[k]; !ok || v != other {
+			return false
+		}
+	}
+	return true
+}
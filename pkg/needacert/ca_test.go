@@ -0,0 +1,184 @@
+package needacert
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rancher/wrangler/v3/pkg/generic/fake"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	apierror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/cert"
+)
+
+func TestRolloverCA(t *testing.T) {
+	secret, err := newCASecret("ns", "ca", KeyAlgorithmECDSAP256)
+	require.NoError(t, err)
+	original, err := cert.ParseCertsPEM(secret.Data[corev1.TLSCertKey])
+	require.NoError(t, err)
+
+	// nothing to do for a new CA
+	updated, err := rolloverCA(secret, time.Now(), KeyAlgorithmECDSAP256)
+	require.NoError(t, err)
+	assert.Nil(t, updated)
+
+	// close to expiry a pending CA is published next to the active CA
+	nearExpiry := original[0].NotAfter.Add(-caRenewBefore / 2)
+	updated, err = rolloverCA(secret, nearExpiry, KeyAlgorithmECDSAP256)
+	require.NoError(t, err)
+	require.NotNil(t, updated)
+	assert.Equal(t, secret.Data[corev1.TLSCertKey], updated.Data[corev1.TLSCertKey])
+	assert.NotEmpty(t, updated.Data[caPendingCertKey])
+	assert.NotEmpty(t, updated.Annotations[caPendingSinceAnnotation])
+	bundle, err := cert.ParseCertsPEM(updated.Data[CABundleKey])
+	require.NoError(t, err)
+	assert.Len(t, bundle, 2)
+
+	// the pending CA is not activated before the rollover delay
+	again, err := rolloverCA(updated, nearExpiry.Add(caRolloverDelay/2), KeyAlgorithmECDSAP256)
+	require.NoError(t, err)
+	assert.Nil(t, again)
+
+	// after the rollover delay the pending CA is activated and the old CA stays in the bundle
+	pending := updated.Data[caPendingCertKey]
+	activated, err := rolloverCA(updated, nearExpiry.Add(caRolloverDelay), KeyAlgorithmECDSAP256)
+	require.NoError(t, err)
+	require.NotNil(t, activated)
+	assert.Equal(t, pending, activated.Data[corev1.TLSCertKey])
+	assert.Empty(t, activated.Data[caPendingCertKey])
+	assert.Empty(t, activated.Annotations[caPendingSinceAnnotation])
+	bundle, err = cert.ParseCertsPEM(activated.Data[CABundleKey])
+	require.NoError(t, err)
+	assert.Len(t, bundle, 2)
+
+	// once the old CA expired it is dropped from the bundle
+	expired, err := rolloverCA(activated, original[0].NotAfter.Add(time.Hour), KeyAlgorithmECDSAP256)
+	require.NoError(t, err)
+	require.NotNil(t, expired)
+	bundle, err = cert.ParseCertsPEM(expired.Data[CABundleKey])
+	require.NoError(t, err)
+	assert.Len(t, bundle, 1)
+}
+
+func TestCertAuthorityIssue(t *testing.T) {
+	secret, err := newCASecret("ns", "ca", KeyAlgorithmECDSAP256)
+	require.NoError(t, err)
+	ca, err := loadCA(secret)
+	require.NoError(t, err)
+
+	certPEM, keyPEM, err := ca.issue("ns-mysecret", []string{"svc.ns", "svc.ns.svc"}, certConfig{validity: defaultValidity, keyAlgorithm: KeyAlgorithmECDSAP256})
+	require.NoError(t, err)
+
+	leaf, err := parseCert(&corev1.Secret{Data: map[string][]byte{
+		corev1.TLSCertKey:       certPEM,
+		corev1.TLSPrivateKeyKey: keyPEM,
+	}})
+	require.NoError(t, err)
+	assert.True(t, ca.issued(leaf))
+	assert.Equal(t, []string{"ns-mysecret", "svc.ns", "svc.ns.svc"}, leaf.DNSNames)
+
+	other, err := newCASecret("ns", "other", KeyAlgorithmECDSAP256)
+	require.NoError(t, err)
+	otherCA, err := loadCA(other)
+	require.NoError(t, err)
+	assert.False(t, otherCA.issued(leaf))
+}
+
+func TestHandler_GenerateSecret_CASecret(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockServices := fake.NewMockControllerInterface[*corev1.Service, *corev1.ServiceList](ctrl)
+	mockSecretsCache := fake.NewMockCacheInterface[*corev1.Secret](ctrl)
+	mockSecrets := fake.NewMockControllerInterface[*corev1.Secret, *corev1.SecretList](ctrl)
+
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "svc",
+			Namespace: "ns",
+			Annotations: map[string]string{
+				SecretAnnotation:   "mysecret",
+				CASecretAnnotation: "myca",
+			},
+		},
+	}
+
+	var caSecret *corev1.Secret
+	mockSecretsCache.EXPECT().
+		Get("ns", "myca").
+		DoAndReturn(func(namespace, name string) (*corev1.Secret, error) {
+			if caSecret == nil {
+				return nil, apierror.NewNotFound(corev1.Resource("secrets"), name)
+			}
+			return caSecret, nil
+		}).AnyTimes()
+	mockSecretsCache.EXPECT().
+		Get("ns", "mysecret").
+		Return(nil, apierror.NewNotFound(corev1.Resource("secrets"), "mysecret"))
+	mockSecrets.EXPECT().
+		Create(gomock.Any()).
+		DoAndReturn(func(secret *corev1.Secret) (*corev1.Secret, error) {
+			if secret.Name == "myca" {
+				caSecret = secret
+			}
+			return secret, nil
+		}).Times(2)
+	mockServices.EXPECT().
+		EnqueueAfter("ns", "svc", gomock.Any())
+
+	h := &handler{
+		services:     mockServices,
+		secretsCache: mockSecretsCache,
+		secrets:      mockSecrets,
+	}
+
+	secret, err := h.generateSecret(service)
+	require.NoError(t, err)
+	require.NotNil(t, caSecret)
+
+	assert.Equal(t, caSecret.Data[CABundleKey], secret.Data[CABundleKey])
+	caBundle, err := caBundleFor(service, secret)
+	require.NoError(t, err)
+	assert.Equal(t, caSecret.Data[CABundleKey], caBundle)
+
+	ca, err := loadCA(caSecret)
+	require.NoError(t, err)
+	leaf, err := parseCert(secret)
+	require.NoError(t, err)
+	assert.True(t, ca.issued(leaf))
+}
+
+func TestServiceSecret_CASecret(t *testing.T) {
+	h := &handler{}
+	keys, err := h.serviceSecret(&corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "svc",
+			Namespace: "ns",
+			Annotations: map[string]string{
+				SecretAnnotation:   "mysecret",
+				CASecretAnnotation: "myca",
+			},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ns/mysecret", "ns/myca"}, keys)
+
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "svc",
+			Namespace:   "ns",
+			Annotations: map[string]string{SecretAnnotation: "mysecret"},
+		},
+	}
+	keys, err = h.serviceSecret(service)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ns/mysecret", "ns/" + DefaultCASecretName}, keys)
+
+	service.Annotations[SelfSignedAnnotation] = "true"
+	keys, err = h.serviceSecret(service)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ns/mysecret"}, keys)
+}
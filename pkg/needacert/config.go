@@ -0,0 +1,234 @@
+package needacert
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	apiregcontrollers "github.com/rancher/wrangler/v3/pkg/generated/controllers/apiregistration.k8s.io/v1"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/keyutil"
+)
+
+var (
+	// ValidityAnnotation sets how long the certificate of a Service is valid, as a Go duration such as "2160h".
+	ValidityAnnotation = "need-a-cert.cattle.io/validity"
+	// RenewBeforeAnnotation sets how long before it expires the certificate of a Service is renewed, as a Go duration.
+	RenewBeforeAnnotation = "need-a-cert.cattle.io/renew-before"
+	// KeyAlgorithmAnnotation sets the key algorithm of the certificate of a Service, one of the KeyAlgorithm values.
+	KeyAlgorithmAnnotation = "need-a-cert.cattle.io/key-algorithm"
+	// IPAnnotation adds an IP SAN to the certificate of a Service. Like DNSAnnotation it is a prefix, so several
+	// addresses can be added with annotations such as need-a-cert.cattle.io/ip-address-1.
+	IPAnnotation = "need-a-cert.cattle.io/ip-address"
+)
+
+// KeyAlgorithm is the type of private key generated for a certificate.
+type KeyAlgorithm string
+
+const (
+	KeyAlgorithmRSA2048   KeyAlgorithm = "rsa-2048"
+	KeyAlgorithmRSA3072   KeyAlgorithm = "rsa-3072"
+	KeyAlgorithmRSA4096   KeyAlgorithm = "rsa-4096"
+	KeyAlgorithmECDSAP256 KeyAlgorithm = "ecdsa-p256"
+	KeyAlgorithmECDSAP384 KeyAlgorithm = "ecdsa-p384"
+	KeyAlgorithmEd25519   KeyAlgorithm = "ed25519"
+)
+
+const (
+	defaultValidity     = 365 * 24 * time.Hour
+	defaultRenewBefore  = 60 * 24 * time.Hour
+	defaultKeyAlgorithm = KeyAlgorithmRSA2048
+)
+
+// Options are the defaults for all certificates managed by needacert. Each certificate value can be overridden per
+// Service with the matching annotation.
+type Options struct {
+	// APIServices, if set, makes needacert inject CA bundles into APIServices backed by a Service, the same way it
+	// does for webhook configurations and CRD conversion webhooks.
+	APIServices apiregcontrollers.APIServiceController
+	// Recorder, if set, is used to record Events on Services when their certificate is issued, rotated or fails
+	// to be issued, and on webhook configurations, CRDs and APIServices when their CA bundle is updated. The
+	// scheme of the recorder must include the types of those objects.
+	Recorder record.EventRecorder
+	// Issuer issues the certificates of Services that don't use a CA Secret through CASecretAnnotation. Defaults
+	// to issuing them from the CA in the DefaultCASecretName Secret of the namespace of each Service.
+	Issuer Issuer
+	// SelfSigned, if set and Issuer is not, makes needacert sign the certificates of Services that don't use a CA
+	// Secret through CASecretAnnotation with a new self-signed CA each time they are issued, as with
+	// SelfSignedAnnotation.
+	SelfSigned bool
+	// Validity is how long certificates are valid. Defaults to 365 days.
+	Validity time.Duration
+	// RenewBefore is how long before they expire certificates are renewed. Defaults to 60 days.
+	RenewBefore time.Duration
+	// KeyAlgorithm is the key algorithm of certificates. Defaults to KeyAlgorithmRSA2048.
+	KeyAlgorithm KeyAlgorithm
+	// IPAddresses are added as IP SANs to every certificate.
+	IPAddresses []net.IP
+}
+
+// certConfig is the configuration of the certificate of a single Service.
+type certConfig struct {
+	validity     time.Duration
+	renewBefore  time.Duration
+	keyAlgorithm KeyAlgorithm
+	ipAddresses  []net.IP
+}
+
+// certConfigFor returns the certificate configuration for the owner, combining the annotations of the owner with
+// the Options needacert was registered with.
+func (h *handler) certConfigFor(owner metav1.Object) (certConfig, error) {
+	cfg := certConfig{
+		validity:     h.opts.Validity,
+		renewBefore:  h.opts.RenewBefore,
+		keyAlgorithm: h.opts.KeyAlgorithm,
+		ipAddresses:  h.opts.IPAddresses,
+	}
+
+	annotations := owner.GetAnnotations()
+	if value := annotations[ValidityAnnotation]; value != "" {
+		validity, err := time.ParseDuration(value)
+		if err != nil || validity <= 0 {
+			return cfg, fmt.Errorf("invalid %s annotation %q on %s/%s", ValidityAnnotation, value, owner.GetNamespace(), owner.GetName())
+		}
+		cfg.validity = validity
+	}
+	if value := annotations[RenewBeforeAnnotation]; value != "" {
+		renewBefore, err := time.ParseDuration(value)
+		if err != nil || renewBefore <= 0 {
+			return cfg, fmt.Errorf("invalid %s annotation %q on %s/%s", RenewBeforeAnnotation, value, owner.GetNamespace(), owner.GetName())
+		}
+		cfg.renewBefore = renewBefore
+	}
+	if value := annotations[KeyAlgorithmAnnotation]; value != "" {
+		if !validKeyAlgorithm(KeyAlgorithm(value)) {
+			return cfg, fmt.Errorf("invalid %s annotation %q on %s/%s", KeyAlgorithmAnnotation, value, owner.GetNamespace(), owner.GetName())
+		}
+		cfg.keyAlgorithm = KeyAlgorithm(value)
+	}
+
+	var extraIPs []string
+	for k, v := range annotations {
+		if strings.HasPrefix(k, IPAnnotation) {
+			extraIPs = append(extraIPs, v)
+		}
+	}
+	// annotations are unordered, sort to get a stable list of SANs
+	sort.Strings(extraIPs)
+	for _, value := range extraIPs {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return cfg, fmt.Errorf("invalid %s annotation %q on %s/%s", IPAnnotation, value, owner.GetNamespace(), owner.GetName())
+		}
+		cfg.ipAddresses = append(cfg.ipAddresses, ip)
+	}
+
+	if cfg.validity == 0 {
+		cfg.validity = defaultValidity
+	}
+	if cfg.renewBefore == 0 {
+		cfg.renewBefore = defaultRenewBefore
+	}
+	if cfg.keyAlgorithm == "" {
+		cfg.keyAlgorithm = defaultKeyAlgorithm
+	}
+	if cfg.renewBefore >= cfg.validity {
+		logrus.Warnf("renew before %s is not shorter than validity %s for %s/%s, renewing after two thirds of the validity",
+			cfg.renewBefore, cfg.validity, owner.GetNamespace(), owner.GetName())
+		cfg.renewBefore = cfg.validity / 3
+	}
+
+	return cfg, nil
+}
+
+// ipsEqual returns whether the certificate has exactly the configured IP SANs.
+func (c certConfig) ipsEqual(ips []net.IP) bool {
+	if len(ips) != len(c.ipAddresses) {
+		return false
+	}
+	for i := range ips {
+		if !ips[i].Equal(c.ipAddresses[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func validKeyAlgorithm(algorithm KeyAlgorithm) bool {
+	switch algorithm {
+	case KeyAlgorithmRSA2048, KeyAlgorithmRSA3072, KeyAlgorithmRSA4096,
+		KeyAlgorithmECDSAP256, KeyAlgorithmECDSAP384, KeyAlgorithmEd25519:
+		return true
+	}
+	return false
+}
+
+// generateKey returns a new private key of the given algorithm.
+func generateKey(algorithm KeyAlgorithm) (crypto.Signer, error) {
+	switch algorithm {
+	case KeyAlgorithmRSA2048, "":
+		return rsa.GenerateKey(cryptorand.Reader, 2048)
+	case KeyAlgorithmRSA3072:
+		return rsa.GenerateKey(cryptorand.Reader, 3072)
+	case KeyAlgorithmRSA4096:
+		return rsa.GenerateKey(cryptorand.Reader, 4096)
+	case KeyAlgorithmECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	case KeyAlgorithmECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), cryptorand.Reader)
+	case KeyAlgorithmEd25519:
+		_, key, err := ed25519.GenerateKey(cryptorand.Reader)
+		return key, err
+	}
+	return nil, fmt.Errorf("unsupported key algorithm %q", algorithm)
+}
+
+// keyAlgorithmOf returns the KeyAlgorithm of the public key, empty if it is not one needacert generates.
+func keyAlgorithmOf(publicKey crypto.PublicKey) KeyAlgorithm {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		switch key.N.BitLen() {
+		case 2048:
+			return KeyAlgorithmRSA2048
+		case 3072:
+			return KeyAlgorithmRSA3072
+		case 4096:
+			return KeyAlgorithmRSA4096
+		}
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return KeyAlgorithmECDSAP256
+		case elliptic.P384():
+			return KeyAlgorithmECDSAP384
+		}
+	case ed25519.PublicKey:
+		return KeyAlgorithmEd25519
+	}
+	return ""
+}
+
+// marshalPrivateKey returns the PEM encoded private key. RSA and ECDSA keys use the same encoding as client-go,
+// Ed25519 keys are encoded as PKCS #8.
+func marshalPrivateKey(key crypto.Signer) ([]byte, error) {
+	if key, ok := key.(ed25519.PrivateKey); ok {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	}
+	return keyutil.MarshalPrivateKeyToPEM(key)
+}
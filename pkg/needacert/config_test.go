@@ -0,0 +1,147 @@
+package needacert
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCertConfigFor(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        Options
+		annotations map[string]string
+		want        certConfig
+		wantErr     bool
+	}{
+		{
+			name: "defaults",
+			want: certConfig{
+				validity:     defaultValidity,
+				renewBefore:  defaultRenewBefore,
+				keyAlgorithm: KeyAlgorithmRSA2048,
+			},
+		},
+		{
+			name: "options",
+			opts: Options{
+				Validity:     90 * 24 * time.Hour,
+				RenewBefore:  30 * 24 * time.Hour,
+				KeyAlgorithm: KeyAlgorithmECDSAP256,
+				IPAddresses:  []net.IP{net.ParseIP("10.0.0.1")},
+			},
+			want: certConfig{
+				validity:     90 * 24 * time.Hour,
+				renewBefore:  30 * 24 * time.Hour,
+				keyAlgorithm: KeyAlgorithmECDSAP256,
+				ipAddresses:  []net.IP{net.ParseIP("10.0.0.1")},
+			},
+		},
+		{
+			name: "annotations override options",
+			opts: Options{
+				Validity:     90 * 24 * time.Hour,
+				KeyAlgorithm: KeyAlgorithmECDSAP256,
+				IPAddresses:  []net.IP{net.ParseIP("10.0.0.1")},
+			},
+			annotations: map[string]string{
+				ValidityAnnotation:       "720h",
+				RenewBeforeAnnotation:    "240h",
+				KeyAlgorithmAnnotation:   "ed25519",
+				IPAnnotation:             "10.0.0.3",
+				IPAnnotation + "-second": "10.0.0.2",
+			},
+			want: certConfig{
+				validity:     720 * time.Hour,
+				renewBefore:  240 * time.Hour,
+				keyAlgorithm: KeyAlgorithmEd25519,
+				ipAddresses:  []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.3")},
+			},
+		},
+		{
+			name:        "renew before longer than validity",
+			annotations: map[string]string{ValidityAnnotation: "24h", RenewBeforeAnnotation: "48h"},
+			want: certConfig{
+				validity:     24 * time.Hour,
+				renewBefore:  8 * time.Hour,
+				keyAlgorithm: KeyAlgorithmRSA2048,
+			},
+		},
+		{
+			name:        "invalid validity",
+			annotations: map[string]string{ValidityAnnotation: "90 days"},
+			wantErr:     true,
+		},
+		{
+			name:        "invalid key algorithm",
+			annotations: map[string]string{KeyAlgorithmAnnotation: "dsa"},
+			wantErr:     true,
+		},
+		{
+			name:        "invalid ip",
+			annotations: map[string]string{IPAnnotation: "not-an-ip"},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &handler{opts: tt.opts}
+			got, err := h.certConfigFor(&corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "ns", Annotations: tt.annotations},
+			})
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCreateSecret_KeyAlgorithms(t *testing.T) {
+	for _, algorithm := range []KeyAlgorithm{KeyAlgorithmRSA2048, KeyAlgorithmECDSAP256, KeyAlgorithmECDSAP384, KeyAlgorithmEd25519} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			h := &handler{opts: Options{SelfSigned: true}}
+			service := &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "svc",
+					Namespace: "ns",
+					Annotations: map[string]string{
+						ValidityAnnotation:     "2160h",
+						KeyAlgorithmAnnotation: string(algorithm),
+						IPAnnotation:           "10.0.0.1",
+					},
+				},
+			}
+			dnsNames := []string{"svc.ns", "svc.ns.svc"}
+			secret, err := h.createSecret(service, "ns", "mysecret", dnsNames)
+			require.NoError(t, err)
+
+			leaf, err := parseCert(secret)
+			require.NoError(t, err)
+			assert.Equal(t, algorithm, keyAlgorithmOf(leaf.PublicKey))
+			assert.WithinDuration(t, time.Now().Add(2160*time.Hour), leaf.NotAfter, 2*time.Hour)
+			assert.True(t, leaf.IPAddresses[0].Equal(net.ParseIP("10.0.0.1")))
+
+			// the certificate matches its configuration so it is kept
+			updated, err := h.updateSecret(service, secret, dnsNames, leaf)
+			require.NoError(t, err)
+			assert.Nil(t, updated)
+
+			// changing the key algorithm regenerates the certificate
+			service.Annotations[KeyAlgorithmAnnotation] = string(KeyAlgorithmRSA3072)
+			updated, err = h.updateSecret(service, secret, dnsNames, leaf)
+			require.NoError(t, err)
+			require.NotNil(t, updated)
+			newLeaf, err := parseCert(updated)
+			require.NoError(t, err)
+			assert.Equal(t, KeyAlgorithmRSA3072, keyAlgorithmOf(newLeaf.PublicKey))
+		})
+	}
+}
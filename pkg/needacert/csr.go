@@ -0,0 +1,261 @@
+package needacert
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"sync"
+
+	certcontrollers "github.com/rancher/wrangler/v3/pkg/generated/controllers/certificates.k8s.io/v1"
+	corecontrollers "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
+	"github.com/rancher/wrangler/v3/pkg/kv"
+	"github.com/rancher/wrangler/v3/pkg/name"
+	"github.com/rancher/wrangler/v3/pkg/relatedresource"
+	"github.com/sirupsen/logrus"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// csrOwnerAnnotation is set on the CertificateSigningRequests created by needacert to the namespace/name of the
+// Service the certificate is for.
+var csrOwnerAnnotation = "need-a-cert.cattle.io/owner"
+
+// minCSRExpirationSeconds is the minimum duration the certificates API accepts for a certificate.
+const minCSRExpirationSeconds = 600
+
+// CSRIssuerOptions configure the Issuer returned by NewCSRIssuer.
+type CSRIssuerOptions struct {
+	// SignerName is the signer the CertificateSigningRequests are addressed to. Required.
+	SignerName string
+	// CABundle is the PEM encoded list of CA certificates of the signer, used as CA bundle of webhooks, CRDs and
+	// APIServices. If empty the certificate chain returned by the signer is used.
+	CABundle []byte
+}
+
+// NewCSRIssuer returns an Issuer that submits certificates.k8s.io/v1 CertificateSigningRequests to the signer
+// and waits for them to be approved and signed. The private keys of pending requests are only kept in memory, so
+// requests that are pending when the process restarts are submitted again. Signed requests are deleted once
+// their certificate is stored in the Secret of the Service.
+func NewCSRIssuer(csrs certcontrollers.CertificateSigningRequestController, opts CSRIssuerOptions) Issuer {
+	return &csrIssuer{
+		csrs:   csrs,
+		opts:   opts,
+		keys:   map[string][]byte{},
+		issued: map[string]string{},
+	}
+}
+
+type csrIssuer struct {
+	csrs certcontrollers.CertificateSigningRequestController
+	opts CSRIssuerOptions
+
+	lock sync.Mutex
+	// keys are the PEM encoded private keys of the pending requests by request name.
+	keys map[string][]byte
+	// issued are the names of the signed requests whose certificate is not stored yet by owner namespace/name.
+	issued map[string]string
+}
+
+// watch enqueues the Service of a CertificateSigningRequest when it changes.
+func (c *csrIssuer) watch(ctx context.Context, services corecontrollers.ServiceController) {
+	relatedresource.Watch(ctx, "resolve-service-from-csr", resolveServiceFromCSR, services, c.csrs)
+}
+
+func resolveServiceFromCSR(_, _ string, obj runtime.Object) ([]relatedresource.Key, error) {
+	csr, ok := obj.(*certificatesv1.CertificateSigningRequest)
+	if !ok {
+		return nil, nil
+	}
+	owner := csr.Annotations[csrOwnerAnnotation]
+	if owner == "" {
+		return nil, nil
+	}
+	namespace, name := kv.Split(owner, "/")
+	return []relatedresource.Key{relatedresource.NewKey(namespace, name)}, nil
+}
+
+func (c *csrIssuer) Issue(req *CertificateRequest) (*Certificate, error) {
+	if c.opts.SignerName == "" {
+		return nil, fmt.Errorf("no signer name set for CertificateSigningRequests")
+	}
+
+	csrName := csrNameFor(req)
+	csr, err := c.csrs.Cache().Get(csrName)
+	if apierror.IsNotFound(err) {
+		return nil, c.create(csrName, req)
+	} else if err != nil {
+		return nil, err
+	}
+
+	c.lock.Lock()
+	keyPEM, ok := c.keys[csrName]
+	c.lock.Unlock()
+	if !ok {
+		logrus.Infof("Recreating CertificateSigningRequest %s, its private key was lost", csrName)
+		return nil, c.delete(csrName, ErrPending)
+	}
+
+	for _, cond := range csr.Status.Conditions {
+		if cond.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch cond.Type {
+		case certificatesv1.CertificateDenied, certificatesv1.CertificateFailed:
+			return nil, c.delete(csrName, fmt.Errorf("CertificateSigningRequest %s was %s: %s", csrName,
+				strings.ToLower(string(cond.Type)), cond.Message))
+		}
+	}
+	if len(csr.Status.Certificate) == 0 {
+		return nil, fmt.Errorf("CertificateSigningRequest %s is waiting to be signed: %w", csrName, ErrPending)
+	}
+
+	if _, err := tls.X509KeyPair(csr.Status.Certificate, keyPEM); err != nil {
+		return nil, c.delete(csrName, fmt.Errorf("CertificateSigningRequest %s was signed with an invalid certificate: %w", csrName, err))
+	}
+	// the request is kept until the certificate is stored, so it can be issued again if that fails
+	c.lock.Lock()
+	c.issued[ownerKey(req.Owner)] = csrName
+	c.lock.Unlock()
+	return &Certificate{
+		CertPEM:  csr.Status.Certificate,
+		KeyPEM:   keyPEM,
+		CABundle: c.opts.CABundle,
+	}, nil
+}
+
+// create submits a CertificateSigningRequest for the request and returns ErrPending. Requests that were already
+// submitted but are not in the cache yet are left alone.
+func (c *csrIssuer) create(csrName string, req *CertificateRequest) error {
+	if c.hasKey(csrName) {
+		return fmt.Errorf("CertificateSigningRequest %s is not cached yet: %w", csrName, ErrPending)
+	}
+
+	key, err := generateKey(req.KeyAlgorithm)
+	if err != nil {
+		return err
+	}
+	keyPEM, err := marshalPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	request, err := x509.CreateCertificateRequest(nil, &x509.CertificateRequest{
+		Subject:     pkix.Name{CommonName: req.CommonName},
+		DNSNames:    append([]string{req.CommonName}, req.DNSNames...),
+		IPAddresses: req.IPAddresses,
+	}, key)
+	if err != nil {
+		return err
+	}
+
+	usages := []certificatesv1.KeyUsage{certificatesv1.UsageDigitalSignature, certificatesv1.UsageServerAuth}
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		usages = append(usages, certificatesv1.UsageKeyEncipherment)
+	}
+	expirationSeconds := int32(req.Validity.Seconds())
+	if expirationSeconds < minCSRExpirationSeconds {
+		expirationSeconds = minCSRExpirationSeconds
+	}
+
+	logrus.Infof("Creating CertificateSigningRequest %s for %s/%s", csrName, req.Owner.GetNamespace(), req.Owner.GetName())
+	_, err = c.csrs.Create(&certificatesv1.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: csrName,
+			Annotations: map[string]string{
+				csrOwnerAnnotation: ownerKey(req.Owner),
+			},
+		},
+		Spec: certificatesv1.CertificateSigningRequestSpec{
+			Request:           pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: request}),
+			SignerName:        c.opts.SignerName,
+			ExpirationSeconds: &expirationSeconds,
+			Usages:            usages,
+		},
+	})
+	if apierror.IsAlreadyExists(err) {
+		if c.hasKey(csrName) {
+			return fmt.Errorf("CertificateSigningRequest %s is not cached yet: %w", csrName, ErrPending)
+		}
+		// another replica created it, its key is not ours so start over
+		return c.delete(csrName, ErrPending)
+	} else if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	c.keys[csrName] = keyPEM
+	c.lock.Unlock()
+	return fmt.Errorf("created CertificateSigningRequest %s: %w", csrName, ErrPending)
+}
+
+// delete deletes the CertificateSigningRequest and its key and returns result if that succeeded.
+func (c *csrIssuer) delete(csrName string, result error) error {
+	c.forget(csrName)
+	if err := c.csrs.Delete(csrName, &metav1.DeleteOptions{}); err != nil && !apierror.IsNotFound(err) {
+		return err
+	}
+	return result
+}
+
+// commit deletes the signed request of the owner and its key, now that its certificate is stored.
+func (c *csrIssuer) commit(owner metav1.Object) error {
+	c.lock.Lock()
+	csrName, ok := c.issued[ownerKey(owner)]
+	delete(c.issued, ownerKey(owner))
+	c.lock.Unlock()
+	if !ok {
+		return nil
+	}
+	return c.delete(csrName, nil)
+}
+
+func ownerKey(owner metav1.Object) string {
+	return owner.GetNamespace() + "/" + owner.GetName()
+}
+
+func (c *csrIssuer) hasKey(csrName string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_, ok := c.keys[csrName]
+	return ok
+}
+
+func (c *csrIssuer) forget(csrName string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.keys, csrName)
+}
+
+// Issued always returns true, the signer of a certificate can't be verified without knowing its whole chain.
+func (c *csrIssuer) Issued(metav1.Object, *x509.Certificate) (bool, error) {
+	return true, nil
+}
+
+func (c *csrIssuer) CABundle(metav1.Object) ([]byte, error) {
+	if len(c.opts.CABundle) == 0 {
+		return nil, nil
+	}
+	return c.opts.CABundle, nil
+}
+
+// csrNameFor returns the name of the CertificateSigningRequest for the request. It changes with the request, so a
+// new request is submitted when the configuration of the certificate changes. Requests left behind are garbage
+// collected by Kubernetes.
+func csrNameFor(req *CertificateRequest) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n%s\n%s\n%s\n", req.CommonName, strings.Join(req.DNSNames, ","), req.Validity, req.KeyAlgorithm)
+	for _, ip := range req.IPAddresses {
+		fmt.Fprintf(hash, "%s,", ip)
+	}
+	return name.SafeConcatName("need-a-cert", req.Owner.GetNamespace(), req.Owner.GetName(),
+		hex.EncodeToString(hash.Sum(nil))[:8])
+}
@@ -0,0 +1,241 @@
+package needacert
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	corecontrollers "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/cert"
+)
+
+// ErrPending is returned by an Issuer that can not issue a certificate yet, for example because it waits for an
+// approval. needacert keeps the current certificate, if any, and asks again later.
+var ErrPending = errors.New("certificate is pending")
+
+// CertificateRequest is a certificate needacert needs for a Service.
+type CertificateRequest struct {
+	// Owner is the Service the certificate is for.
+	Owner metav1.Object
+	// CommonName is the common name of the certificate. It must also be the first DNS name of the certificate.
+	CommonName string
+	// DNSNames are the DNS names of the certificate after the common name.
+	DNSNames []string
+	// IPAddresses are the IP SANs of the certificate.
+	IPAddresses []net.IP
+	// Validity is how long the certificate should be valid.
+	Validity time.Duration
+	// KeyAlgorithm is the algorithm of the private key of the certificate.
+	KeyAlgorithm KeyAlgorithm
+}
+
+// Certificate is a certificate issued by an Issuer.
+type Certificate struct {
+	// CertPEM is the PEM encoded certificate chain, starting with the certificate.
+	CertPEM []byte
+	// KeyPEM is the PEM encoded private key of the certificate.
+	KeyPEM []byte
+	// CABundle is the PEM encoded list of CA certificates clients should trust. If set it is stored in the
+	// ca.crt key of the TLS Secret and used as caBundle of webhooks, CRDs and APIServices, otherwise the
+	// certificate chain is used.
+	CABundle []byte
+}
+
+// Issuer issues the certificates of Services. The Issuer of a Service is the one set in Options, unless the
+// Service uses a CA Secret through CASecretAnnotation or opts out through SelfSignedAnnotation. Without either
+// certificates are issued from the CA in the DefaultCASecretName Secret of the namespace of the Service, unless
+// Options.SelfSigned is set.
+type Issuer interface {
+	// Issue issues a new certificate for the request. It returns an error wrapping ErrPending if the certificate
+	// can not be issued yet.
+	Issue(req *CertificateRequest) (*Certificate, error)
+	// Issued returns whether cert was issued by a CA the Issuer still issues certificates from. Certificates that
+	// were not are issued again.
+	Issued(owner metav1.Object, cert *x509.Certificate) (bool, error)
+	// CABundle returns the PEM encoded list of CA certificates clients should currently trust for the certificate
+	// of the owner, nil if the Issuer does not know them.
+	CABundle(owner metav1.Object) ([]byte, error)
+}
+
+// rolloverIssuer is implemented by issuers that need the certificate of a Service to be checked before it is up
+// for renewal, such as during a CA rollover.
+type rolloverIssuer interface {
+	nextCheck(owner metav1.Object) (time.Time, error)
+}
+
+// watchingIssuer is implemented by issuers that need to enqueue Services when the objects they issue
+// certificates through change.
+type watchingIssuer interface {
+	watch(ctx context.Context, services corecontrollers.ServiceController)
+}
+
+// committingIssuer is implemented by issuers that keep what they need to issue a certificate again, such as a
+// signed request, until the certificate is persisted.
+type committingIssuer interface {
+	// commit is called once the last certificate issued for the owner is stored in its Secret.
+	commit(owner metav1.Object) error
+}
+
+// issuerFor returns the Issuer of the certificate of the owner.
+func (h *handler) issuerFor(owner metav1.Object) Issuer {
+	if h.caSecretNameOf(owner) != "" {
+		return &managedCAIssuer{h: h}
+	}
+	if owner.GetAnnotations()[SelfSignedAnnotation] != "true" && h.opts.Issuer != nil {
+		return h.opts.Issuer
+	}
+	return selfSignedIssuer{}
+}
+
+// certConfigOf returns the configuration of a certificate issued by a certAuthority for the request.
+func certConfigOf(req *CertificateRequest) certConfig {
+	return certConfig{
+		validity:     req.Validity,
+		keyAlgorithm: req.KeyAlgorithm,
+		ipAddresses:  req.IPAddresses,
+	}
+}
+
+// selfSignedIssuer signs every certificate with a new self-signed CA, the same as cert.GenerateSelfSignedCertKey.
+type selfSignedIssuer struct{}
+
+func (selfSignedIssuer) Issue(req *CertificateRequest) (*Certificate, error) {
+	cfg := certConfigOf(req)
+	ca, err := newSelfSignedCA(req.CommonName, cfg)
+	if err != nil {
+		return nil, err
+	}
+	certPEM, keyPEM, err := ca.issue(req.CommonName, req.DNSNames, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Certificate{CertPEM: certPEM, KeyPEM: keyPEM}, nil
+}
+
+func (selfSignedIssuer) Issued(metav1.Object, *x509.Certificate) (bool, error) {
+	return true, nil
+}
+
+func (selfSignedIssuer) CABundle(metav1.Object) ([]byte, error) {
+	return nil, nil
+}
+
+// managedCAIssuer issues certificates from the CA Secret of the owner, named by its CASecretAnnotation or
+// DefaultCASecretName, which needacert creates and rolls over.
+type managedCAIssuer struct {
+	h *handler
+}
+
+func (m *managedCAIssuer) Issue(req *CertificateRequest) (*Certificate, error) {
+	ca, err := m.h.certAuthorityFor(req.Owner)
+	if err != nil {
+		return nil, err
+	}
+	certPEM, keyPEM, err := ca.issue(req.CommonName, req.DNSNames, certConfigOf(req))
+	if err != nil {
+		return nil, err
+	}
+	return &Certificate{CertPEM: certPEM, KeyPEM: keyPEM, CABundle: ca.bundle}, nil
+}
+
+func (m *managedCAIssuer) Issued(owner metav1.Object, cert *x509.Certificate) (bool, error) {
+	ca, err := m.h.certAuthorityFor(owner)
+	if err != nil {
+		return false, err
+	}
+	return ca.issued(cert), nil
+}
+
+func (m *managedCAIssuer) CABundle(owner metav1.Object) ([]byte, error) {
+	ca, err := m.h.certAuthorityFor(owner)
+	if err != nil {
+		return nil, err
+	}
+	return ca.bundle, nil
+}
+
+func (m *managedCAIssuer) nextCheck(owner metav1.Object) (time.Time, error) {
+	ca, err := m.h.certAuthorityFor(owner)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return ca.nextRollover, nil
+}
+
+// NewCASecretIssuer returns an Issuer that signs certificates with the CA in the tls.crt and tls.key keys of a
+// Secret managed outside of needacert, such as an intermediate CA of a corporate PKI. The tls.crt key can hold
+// the chain of the CA, which is added to the issued certificates. Clients are given the ca.crt key of the Secret
+// as CA bundle, or the CA itself if there is none. Changes to the Secret are picked up on the next certificate
+// check of each Service.
+func NewCASecretIssuer(secrets corecontrollers.SecretCache, namespace, name string) Issuer {
+	return &caSecretIssuer{
+		secrets:   secrets,
+		namespace: namespace,
+		name:      name,
+	}
+}
+
+type caSecretIssuer struct {
+	secrets   corecontrollers.SecretCache
+	namespace string
+	name      string
+}
+
+func (c *caSecretIssuer) load() (*certAuthority, []*x509.Certificate, error) {
+	secret, err := c.secrets.Get(c.namespace, c.name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get CA secret %s/%s: %w", c.namespace, c.name, err)
+	}
+	ca, err := parseCA(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load CA of secret %s/%s: %w", c.namespace, c.name, err)
+	}
+	chain, err := cert.ParseCertsPEM(secret.Data[corev1.TLSCertKey])
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load CA of secret %s/%s: %w", c.namespace, c.name, err)
+	}
+	if bundle := secret.Data[CABundleKey]; len(bundle) > 0 {
+		ca.bundle = bundle
+	}
+	return ca, chain[1:], nil
+}
+
+func (c *caSecretIssuer) Issue(req *CertificateRequest) (*Certificate, error) {
+	ca, chain, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	certPEM, keyPEM, err := ca.issue(req.CommonName, req.DNSNames, certConfigOf(req))
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) > 0 {
+		chainPEM, err := cert.EncodeCertificates(chain...)
+		if err != nil {
+			return nil, err
+		}
+		certPEM = append(certPEM, chainPEM...)
+	}
+	return &Certificate{CertPEM: certPEM, KeyPEM: keyPEM, CABundle: ca.bundle}, nil
+}
+
+func (c *caSecretIssuer) Issued(_ metav1.Object, cert *x509.Certificate) (bool, error) {
+	ca, _, err := c.load()
+	if err != nil {
+		return false, err
+	}
+	return ca.issued(cert), nil
+}
+
+func (c *caSecretIssuer) CABundle(metav1.Object) ([]byte, error) {
+	ca, _, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	return ca.bundle, nil
+}
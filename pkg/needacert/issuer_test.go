@@ -0,0 +1,428 @@
+package needacert
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rancher/wrangler/v3/pkg/generic/fake"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func testRequest(owner metav1.Object) *CertificateRequest {
+	return &CertificateRequest{
+		Owner:        owner,
+		CommonName:   "ns-mysecret",
+		DNSNames:     []string{"svc.ns", "svc.ns.svc"},
+		Validity:     defaultValidity,
+		KeyAlgorithm: KeyAlgorithmECDSAP256,
+	}
+}
+
+func TestCASecretIssuer(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	caSecret, err := newCASecret("corp", "ca", KeyAlgorithmECDSAP256)
+	require.NoError(t, err)
+	delete(caSecret.Data, CABundleKey)
+
+	mockSecretsCache := fake.NewMockCacheInterface[*corev1.Secret](ctrl)
+	mockSecretsCache.EXPECT().Get("corp", "ca").Return(caSecret, nil).AnyTimes()
+
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "ns"}}
+	issuer := NewCASecretIssuer(mockSecretsCache, "corp", "ca")
+
+	issued, err := issuer.Issue(testRequest(service))
+	require.NoError(t, err)
+	assert.Equal(t, caSecret.Data[corev1.TLSCertKey], issued.CABundle, "without ca.crt the CA is the bundle")
+
+	leaf, err := parseCert(&corev1.Secret{Data: map[string][]byte{
+		corev1.TLSCertKey:       issued.CertPEM,
+		corev1.TLSPrivateKeyKey: issued.KeyPEM,
+	}})
+	require.NoError(t, err)
+	ok, err := issuer.Issued(service, leaf)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	bundle, err := issuer.CABundle(service)
+	require.NoError(t, err)
+	assert.Equal(t, caSecret.Data[corev1.TLSCertKey], bundle)
+
+	// certificates signed by another CA are issued again
+	other, err := selfSignedIssuer{}.Issue(testRequest(service))
+	require.NoError(t, err)
+	otherLeaf, err := parseCert(&corev1.Secret{Data: map[string][]byte{
+		corev1.TLSCertKey:       other.CertPEM,
+		corev1.TLSPrivateKeyKey: other.KeyPEM,
+	}})
+	require.NoError(t, err)
+	ok, err = issuer.Issued(service, otherLeaf)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestCASecretIssuer_MissingSecret(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockSecretsCache := fake.NewMockCacheInterface[*corev1.Secret](ctrl)
+	mockSecretsCache.EXPECT().Get("corp", "ca").Return(nil, apierror.NewNotFound(corev1.Resource("secrets"), "ca"))
+
+	_, err := NewCASecretIssuer(mockSecretsCache, "corp", "ca").Issue(testRequest(&corev1.Service{}))
+	assert.Error(t, err)
+}
+
+// signCSR signs the request of the CertificateSigningRequest with the CA, like a signer would.
+func signCSR(t *testing.T, ca *certAuthority, csr *certificatesv1.CertificateSigningRequest) []byte {
+	t.Helper()
+	block, _ := pem.Decode(csr.Spec.Request)
+	require.NotNil(t, block)
+	request, err := x509.ParseCertificateRequest(block.Bytes)
+	require.NoError(t, err)
+	require.NoError(t, request.CheckSignature())
+
+	serial, err := newSerial()
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      request.Subject,
+		DNSNames:     request.DNSNames,
+		IPAddresses:  request.IPAddresses,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Duration(*csr.Spec.ExpirationSeconds) * time.Second),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(nil, template, ca.cert, request.PublicKey, ca.key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestCSRIssuer(t *testing.T) {
+	caSecret, err := newCASecret("corp", "ca", KeyAlgorithmECDSAP256)
+	require.NoError(t, err)
+	ca, err := loadCA(caSecret)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		sign    func(csr *certificatesv1.CertificateSigningRequest)
+		wantErr bool
+	}{
+		{
+			name: "approved",
+			sign: func(csr *certificatesv1.CertificateSigningRequest) {
+				csr.Status.Conditions = append(csr.Status.Conditions, certificatesv1.CertificateSigningRequestCondition{
+					Type:   certificatesv1.CertificateApproved,
+					Status: corev1.ConditionTrue,
+				})
+				csr.Status.Certificate = signCSR(t, ca, csr)
+			},
+		},
+		{
+			name: "denied",
+			sign: func(csr *certificatesv1.CertificateSigningRequest) {
+				csr.Status.Conditions = append(csr.Status.Conditions, certificatesv1.CertificateSigningRequestCondition{
+					Type:    certificatesv1.CertificateDenied,
+					Status:  corev1.ConditionTrue,
+					Message: "not today",
+				})
+			},
+			wantErr: true,
+		},
+		{
+			name: "signed with another key",
+			sign: func(csr *certificatesv1.CertificateSigningRequest) {
+				other, err := selfSignedIssuer{}.Issue(testRequest(&corev1.Service{}))
+				require.NoError(t, err)
+				csr.Status.Certificate = other.CertPEM
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockCSRs := fake.NewMockNonNamespacedControllerInterface[*certificatesv1.CertificateSigningRequest, *certificatesv1.CertificateSigningRequestList](ctrl)
+			mockCSRCache := fake.NewMockNonNamespacedCacheInterface[*certificatesv1.CertificateSigningRequest](ctrl)
+			mockCSRs.EXPECT().Cache().Return(mockCSRCache).AnyTimes()
+
+			var stored *certificatesv1.CertificateSigningRequest
+			mockCSRCache.EXPECT().Get(gomock.Any()).DoAndReturn(func(name string) (*certificatesv1.CertificateSigningRequest, error) {
+				if stored == nil || stored.Name != name {
+					return nil, apierror.NewNotFound(schema.GroupResource{Group: "certificates.k8s.io", Resource: "certificatesigningrequests"}, name)
+				}
+				return stored, nil
+			}).AnyTimes()
+			mockCSRs.EXPECT().Create(gomock.Any()).DoAndReturn(func(csr *certificatesv1.CertificateSigningRequest) (*certificatesv1.CertificateSigningRequest, error) {
+				stored = csr
+				return csr, nil
+			})
+			mockCSRs.EXPECT().Delete(gomock.Any(), gomock.Any()).DoAndReturn(func(name string, _ *metav1.DeleteOptions) error {
+				stored = nil
+				return nil
+			})
+
+			service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "ns"}}
+			issuer := NewCSRIssuer(mockCSRs, CSRIssuerOptions{
+				SignerName: "example.com/corp",
+				CABundle:   caSecret.Data[corev1.TLSCertKey],
+			})
+
+			_, err := issuer.Issue(testRequest(service))
+			assert.True(t, errors.Is(err, ErrPending))
+			require.NotNil(t, stored)
+			assert.Equal(t, "example.com/corp", stored.Spec.SignerName)
+			assert.Equal(t, "ns/svc", stored.Annotations[csrOwnerAnnotation])
+
+			// nothing happens until the request is signed
+			_, err = issuer.Issue(testRequest(service))
+			assert.True(t, errors.Is(err, ErrPending))
+
+			tt.sign(stored)
+			issued, err := issuer.Issue(testRequest(service))
+			if tt.wantErr {
+				assert.Nil(t, stored, "the request is deleted once it failed")
+				assert.Error(t, err)
+				assert.False(t, errors.Is(err, ErrPending))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, caSecret.Data[corev1.TLSCertKey], issued.CABundle)
+
+			// the certificate is issued again until it is stored
+			require.NotNil(t, stored, "the request is kept until the certificate is stored")
+			again, err := issuer.Issue(testRequest(service))
+			require.NoError(t, err)
+			assert.Equal(t, issued, again)
+			require.NoError(t, issuer.(committingIssuer).commit(service))
+			assert.Nil(t, stored, "the request is deleted once the certificate is stored")
+
+			leaf, err := parseCert(&corev1.Secret{Data: map[string][]byte{
+				corev1.TLSCertKey:       issued.CertPEM,
+				corev1.TLSPrivateKeyKey: issued.KeyPEM,
+			}})
+			require.NoError(t, err)
+			assert.True(t, ca.issued(leaf))
+			assert.Equal(t, []string{"ns-mysecret", "svc.ns", "svc.ns.svc"}, leaf.DNSNames)
+		})
+	}
+}
+
+func TestCSRIssuer_StaleCache(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCSRs := fake.NewMockNonNamespacedControllerInterface[*certificatesv1.CertificateSigningRequest, *certificatesv1.CertificateSigningRequestList](ctrl)
+	mockCSRCache := fake.NewMockNonNamespacedCacheInterface[*certificatesv1.CertificateSigningRequest](ctrl)
+	mockCSRs.EXPECT().Cache().Return(mockCSRCache).AnyTimes()
+	mockCSRCache.EXPECT().Get(gomock.Any()).DoAndReturn(func(name string) (*certificatesv1.CertificateSigningRequest, error) {
+		return nil, apierror.NewNotFound(schema.GroupResource{Group: "certificates.k8s.io", Resource: "certificatesigningrequests"}, name)
+	}).AnyTimes()
+
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "ns"}}
+	issuer := NewCSRIssuer(mockCSRs, CSRIssuerOptions{SignerName: "example.com/corp"})
+
+	// a request created by another replica is deleted, its key is not ours
+	mockCSRs.EXPECT().Create(gomock.Any()).Return(nil, apierror.NewAlreadyExists(schema.GroupResource{}, "csr"))
+	mockCSRs.EXPECT().Delete(gomock.Any(), gomock.Any()).Return(nil)
+	_, err := issuer.Issue(testRequest(service))
+	assert.True(t, errors.Is(err, ErrPending))
+
+	mockCSRs.EXPECT().Create(gomock.Any()).DoAndReturn(func(csr *certificatesv1.CertificateSigningRequest) (*certificatesv1.CertificateSigningRequest, error) {
+		return csr, nil
+	})
+	_, err = issuer.Issue(testRequest(service))
+	assert.True(t, errors.Is(err, ErrPending))
+
+	// our own request is left alone until the cache catches up
+	_, err = issuer.Issue(testRequest(service))
+	assert.True(t, errors.Is(err, ErrPending))
+}
+
+func TestResolveServiceFromCSR(t *testing.T) {
+	keys, err := resolveServiceFromCSR("", "csr", &certificatesv1.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{csrOwnerAnnotation: "ns/svc"},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "ns", keys[0].Namespace)
+	assert.Equal(t, "svc", keys[0].Name)
+
+	keys, err = resolveServiceFromCSR("", "csr", &certificatesv1.CertificateSigningRequest{})
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+type pendingIssuer struct {
+	selfSignedIssuer
+	pending bool
+	bundle  []byte
+}
+
+func (p *pendingIssuer) Issue(req *CertificateRequest) (*Certificate, error) {
+	if p.pending {
+		return nil, fmt.Errorf("waiting: %w", ErrPending)
+	}
+	issued, err := p.selfSignedIssuer.Issue(req)
+	if err != nil {
+		return nil, err
+	}
+	issued.CABundle = p.bundle
+	return issued, nil
+}
+
+func (p *pendingIssuer) CABundle(metav1.Object) ([]byte, error) {
+	return p.bundle, nil
+}
+
+func TestHandler_GenerateSecret_Issuer(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockServices := fake.NewMockControllerInterface[*corev1.Service, *corev1.ServiceList](ctrl)
+	mockSecretsCache := fake.NewMockCacheInterface[*corev1.Secret](ctrl)
+	mockSecrets := fake.NewMockControllerInterface[*corev1.Secret, *corev1.SecretList](ctrl)
+
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "svc",
+			Namespace:   "ns",
+			Annotations: map[string]string{SecretAnnotation: "mysecret"},
+		},
+	}
+
+	var stored *corev1.Secret
+	mockSecretsCache.EXPECT().
+		Get("ns", "mysecret").
+		DoAndReturn(func(namespace, name string) (*corev1.Secret, error) {
+			if stored == nil {
+				return nil, apierror.NewNotFound(corev1.Resource("secrets"), name)
+			}
+			return stored, nil
+		}).AnyTimes()
+	mockSecrets.EXPECT().
+		Create(gomock.Any()).
+		DoAndReturn(func(secret *corev1.Secret) (*corev1.Secret, error) {
+			stored = secret
+			return secret, nil
+		})
+	mockSecrets.EXPECT().
+		Update(gomock.Any()).
+		DoAndReturn(func(secret *corev1.Secret) (*corev1.Secret, error) {
+			stored = secret
+			return secret, nil
+		})
+	mockServices.EXPECT().
+		EnqueueAfter("ns", "svc", gomock.Any()).
+		AnyTimes()
+
+	issuer := &pendingIssuer{pending: true, bundle: []byte("bundle-1")}
+	h := &handler{
+		opts:         Options{Issuer: issuer},
+		services:     mockServices,
+		secretsCache: mockSecretsCache,
+		secrets:      mockSecrets,
+	}
+
+	// no secret while the certificate is pending
+	secret, err := h.generateSecret(service)
+	require.NoError(t, err)
+	assert.Nil(t, secret)
+
+	issuer.pending = false
+	secret, err = h.generateSecret(service)
+	require.NoError(t, err)
+	require.NotNil(t, secret)
+	assert.Equal(t, []byte("bundle-1"), secret.Data[CABundleKey])
+	caBundle, err := caBundleFor(service, secret)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("bundle-1"), caBundle)
+
+	// a new CA bundle of the issuer is synced into the secret
+	issuer.bundle = []byte("bundle-2")
+	secret, err = h.generateSecret(service)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("bundle-2"), secret.Data[CABundleKey])
+
+	// while a renewal is pending the current certificate is kept
+	issuer.pending = true
+	service.Annotations[DNSAnnotation] = "svc.example.com"
+	kept, err := h.generateSecret(service)
+	require.NoError(t, err)
+	assert.Equal(t, secret, kept)
+}
+
+func TestHandler_IssuerFor(t *testing.T) {
+	external := &pendingIssuer{}
+	tests := []struct {
+		name         string
+		opts         Options
+		annotations  map[string]string
+		caSecretName string
+		selfSigned   bool
+	}{
+		{
+			name:         "default CA secret",
+			caSecretName: DefaultCASecretName,
+		},
+		{
+			name:         "CA secret annotation",
+			annotations:  map[string]string{CASecretAnnotation: "myca"},
+			caSecretName: "myca",
+		},
+		{
+			name:         "CA secret annotation over issuer",
+			opts:         Options{Issuer: external},
+			annotations:  map[string]string{CASecretAnnotation: "myca"},
+			caSecretName: "myca",
+		},
+		{
+			name: "issuer",
+			opts: Options{Issuer: external},
+		},
+		{
+			name:        "self-signed annotation",
+			opts:        Options{Issuer: external},
+			annotations: map[string]string{SelfSignedAnnotation: "true"},
+			selfSigned:  true,
+		},
+		{
+			name:       "self-signed option",
+			opts:       Options{SelfSigned: true},
+			selfSigned: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &handler{opts: tt.opts}
+			service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "ns", Annotations: tt.annotations}}
+
+			assert.Equal(t, tt.caSecretName, h.caSecretNameOf(service))
+			issuer := h.issuerFor(service)
+			switch {
+			case tt.caSecretName != "":
+				assert.IsType(t, &managedCAIssuer{}, issuer)
+			case tt.selfSigned:
+				assert.Equal(t, selfSignedIssuer{}, issuer)
+			default:
+				assert.Equal(t, external, issuer)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package needacert
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricsEnv         = "CATTLE_PROMETHEUS_METRICS"
+	needacertSubsystem = "wrangler_needacert"
+
+	namespaceLabel = "namespace"
+	secretLabel    = "secret"
+)
+
+var (
+	prometheusMetrics = false
+
+	certificateExpiryDays = prometheus.NewDesc(
+		prometheus.BuildFQName("", needacertSubsystem, "certificate_expiry_days"),
+		"Days until the certificate of a TLS Secret managed by needacert expires",
+		[]string{namespaceLabel, secretLabel}, nil,
+	)
+
+	certificateNotAfter = prometheus.NewDesc(
+		prometheus.BuildFQName("", needacertSubsystem, "certificate_not_after_timestamp_seconds"),
+		"Unix time at which the certificate of a TLS Secret managed by needacert expires",
+		[]string{namespaceLabel, secretLabel}, nil,
+	)
+
+	certificates = &certificateCollector{
+		secrets: map[string]certificateExpiry{},
+	}
+)
+
+func init() {
+	if os.Getenv(metricsEnv) == "true" {
+		MustRegisterMetrics(prometheus.DefaultRegisterer)
+	}
+}
+
+// MustRegisterMetrics registers the needacert metrics with the provided registerer.
+func MustRegisterMetrics(registerer prometheus.Registerer) {
+	prometheusMetrics = true
+	registerer.MustRegister(certificates)
+}
+
+type certificateExpiry struct {
+	namespace string
+	secret    string
+	notAfter  time.Time
+}
+
+// certificateCollector computes the time until expiry when scraped, so the gauges stay accurate between cert
+// checks, which can be months apart.
+type certificateCollector struct {
+	lock sync.RWMutex
+	// secrets is keyed by the namespace/name of the Service owning the Secret.
+	secrets map[string]certificateExpiry
+}
+
+func (c *certificateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- certificateExpiryDays
+	ch <- certificateNotAfter
+}
+
+func (c *certificateCollector) Collect(ch chan<- prometheus.Metric) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	// several Services can share a Secret, only report it once
+	seen := map[certificateExpiry]bool{}
+	for _, expiry := range c.secrets {
+		if seen[expiry] {
+			continue
+		}
+		seen[expiry] = true
+		ch <- prometheus.MustNewConstMetric(certificateExpiryDays, prometheus.GaugeValue,
+			time.Until(expiry.notAfter).Hours()/24, expiry.namespace, expiry.secret)
+		ch <- prometheus.MustNewConstMetric(certificateNotAfter, prometheus.GaugeValue,
+			float64(expiry.notAfter.Unix()), expiry.namespace, expiry.secret)
+	}
+}
+
+func observeCertificate(serviceKey, namespace, secret string, notAfter time.Time) {
+	if !prometheusMetrics {
+		return
+	}
+	certificates.lock.Lock()
+	defer certificates.lock.Unlock()
+	certificates.secrets[serviceKey] = certificateExpiry{
+		namespace: namespace,
+		secret:    secret,
+		notAfter:  notAfter.UTC(),
+	}
+}
+
+func forgetCertificate(serviceKey string) {
+	if !prometheusMetrics {
+		return
+	}
+	certificates.lock.Lock()
+	defer certificates.lock.Unlock()
+	delete(certificates.secrets, serviceKey)
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -13,6 +14,7 @@ import (
 	"github.com/moby/locker"
 	admissionregcontrollers "github.com/rancher/wrangler/v3/pkg/generated/controllers/admissionregistration.k8s.io/v1"
 	apiextcontrollers "github.com/rancher/wrangler/v3/pkg/generated/controllers/apiextensions.k8s.io/v1"
+	apiregcontrollers "github.com/rancher/wrangler/v3/pkg/generated/controllers/apiregistration.k8s.io/v1"
 	corecontrollers "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
 	"github.com/rancher/wrangler/v3/pkg/gvk"
 	"github.com/rancher/wrangler/v3/pkg/relatedresource"
@@ -26,7 +28,9 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/cert"
+	apiregv1 "k8s.io/kube-aggregator/pkg/apis/apiregistration/v1"
 )
 
 var (
@@ -55,6 +59,9 @@ const (
 const (
 	byServiceIndex = "byService"
 	bySecretIndex  = "bySecret"
+
+	// pendingRecheck is how long to wait before asking an Issuer again for a pending certificate.
+	pendingRecheck = time.Minute
 )
 
 func Register(ctx context.Context,
@@ -63,7 +70,19 @@ func Register(ctx context.Context,
 	mutatingController admissionregcontrollers.MutatingWebhookConfigurationController,
 	validatingController admissionregcontrollers.ValidatingWebhookConfigurationController,
 	crdController apiextcontrollers.CustomResourceDefinitionController) {
+	RegisterWithOptions(ctx, secrets, service, mutatingController, validatingController, crdController, Options{})
+}
+
+// RegisterWithOptions is Register with Options for the certificates needacert issues.
+func RegisterWithOptions(ctx context.Context,
+	secrets corecontrollers.SecretController,
+	service corecontrollers.ServiceController,
+	mutatingController admissionregcontrollers.MutatingWebhookConfigurationController,
+	validatingController admissionregcontrollers.ValidatingWebhookConfigurationController,
+	crdController apiextcontrollers.CustomResourceDefinitionController,
+	opts Options) {
 	h := handler{
+		opts:               opts,
 		secretsCache:       secrets.Cache(),
 		secrets:            secrets,
 		services:           service,
@@ -71,17 +90,28 @@ func Register(ctx context.Context,
 		mutatingWebHooks:   mutatingController,
 		validatingWebHooks: validatingController,
 		crds:               crdController,
+		apiServices:        opts.APIServices,
+		recorder:           opts.Recorder,
 	}
 
 	mutatingController.Cache().AddIndexer(byServiceIndex, mutatingWebhookServices)
 	validatingController.Cache().AddIndexer(byServiceIndex, validatingWebhookServices)
 	crdController.Cache().AddIndexer(byServiceIndex, crdWebhookServices)
+	if opts.APIServices != nil {
+		opts.APIServices.Cache().AddIndexer(byServiceIndex, apiServiceServices)
+	}
 
-	service.Cache().AddIndexer(bySecretIndex, serviceSecret)
+	service.Cache().AddIndexer(bySecretIndex, h.serviceSecret)
 
 	mutatingController.OnChange(ctx, "need-a-cert", h.OnMutationWebhookChange)
 	validatingController.OnChange(ctx, "need-a-cert", h.OnValidatingWebhookChange)
 	crdController.OnChange(ctx, "need-a-cert", h.OnCRDChange)
+	if opts.APIServices != nil {
+		opts.APIServices.OnChange(ctx, "need-a-cert", h.OnAPIServiceChange)
+	}
+	if issuer, ok := opts.Issuer.(watchingIssuer); ok {
+		issuer.watch(ctx, service)
+	}
 	service.OnChange(ctx, "need-a-cert", h.OnService)
 
 	relatedresource.Watch(ctx, "resolve-service-from-secret", h.resolveServiceFromSecret, service, secrets)
@@ -105,17 +135,22 @@ func (h *handler) resolveServiceFromSecret(namespace, name string, obj runtime.O
 	return result, nil
 }
 
-func serviceSecret(obj *corev1.Service) ([]string, error) {
+func (h *handler) serviceSecret(obj *corev1.Service) ([]string, error) {
 	secretName := obj.Annotations[SecretAnnotation]
 	if secretName == "" {
 		return nil, nil
 	}
-	return []string{
+	result := []string{
 		obj.Namespace + "/" + secretName,
-	}, nil
+	}
+	if caSecretName := h.caSecretNameOf(obj); caSecretName != "" {
+		result = append(result, obj.Namespace+"/"+caSecretName)
+	}
+	return result, nil
 }
 
 type handler struct {
+	opts               Options
 	locker             locker.Locker
 	secretsCache       corecontrollers.SecretCache
 	secrets            corecontrollers.SecretClient
@@ -124,15 +159,21 @@ type handler struct {
 	mutatingWebHooks   admissionregcontrollers.MutatingWebhookConfigurationController
 	validatingWebHooks admissionregcontrollers.ValidatingWebhookConfigurationController
 	crds               apiextcontrollers.CustomResourceDefinitionController
+	apiServices        apiregcontrollers.APIServiceController
+	recorder           record.EventRecorder
 }
 
 // caBundleFor returns the bytes that should populate a webhook/CRD ClientConfig's
-// CABundle field for the given service/secret pair. Only a service's
+// CABundle field for the given service/secret pair. A secret holding the CA bundle
+// of its Issuer always gives that bundle. Otherwise only a service's
 // CABundleModeAnnotation set to CABundleModeCAOnly changes the result; anything
 // else (including a nil service) keeps the default full-chain behavior, so one
 // consumer can opt in without affecting any other consumer sharing the same
 // needacert handler.
 func caBundleFor(service *corev1.Service, secret *corev1.Secret) ([]byte, error) {
+	if len(secret.Data[CABundleKey]) > 0 {
+		return secret.Data[CABundleKey], nil
+	}
 	fullChain := secret.Data[corev1.TLSCertKey]
 	if service == nil || service.Annotations[CABundleModeAnnotation] != CABundleModeCAOnly {
 		return fullChain, nil
@@ -189,6 +230,13 @@ func crdWebhookServices(obj *apiextv1.CustomResourceDefinition) (result []string
 	return nil, nil
 }
 
+func apiServiceServices(obj *apiregv1.APIService) (result []string, _ error) {
+	if obj.Spec.Service != nil {
+		return []string{obj.Spec.Service.Namespace + "/" + obj.Spec.Service.Name}, nil
+	}
+	return nil, nil
+}
+
 func mutatingWebhookServices(obj *adminregv1.MutatingWebhookConfiguration) (result []string, _ error) {
 	for _, webhook := range obj.Webhooks {
 		if webhook.ClientConfig.Service != nil {
@@ -219,6 +267,8 @@ func (h *handler) OnMutationWebhookChange(key string, webhook *adminregv1.Mutati
 
 		secret, err := h.generateSecret(service)
 		if err != nil {
+			h.event(webhook, corev1.EventTypeWarning, ReasonCABundleFailed,
+				"Failed to get certificate of service %s/%s: %v", service.Namespace, service.Name, err)
 			return nil, err
 		} else if secret == nil {
 			continue
@@ -226,6 +276,8 @@ func (h *handler) OnMutationWebhookChange(key string, webhook *adminregv1.Mutati
 
 		caBundle, err := caBundleFor(service, secret)
 		if err != nil {
+			h.event(webhook, corev1.EventTypeWarning, ReasonCABundleFailed,
+				"Failed to get CA bundle of service %s/%s: %v", service.Namespace, service.Name, err)
 			return nil, err
 		}
 		if !bytes.Equal(webhookConfig.ClientConfig.CABundle, caBundle) {
@@ -241,6 +293,7 @@ func (h *handler) OnMutationWebhookChange(key string, webhook *adminregv1.Mutati
 		if err != nil {
 			return webhook, err
 		}
+		h.event(webhook, corev1.EventTypeNormal, ReasonCABundleUpdated, "Updated CA bundle of webhooks")
 	}
 
 	return webhook, nil
@@ -267,6 +320,8 @@ func (h *handler) OnValidatingWebhookChange(key string, webhook *adminregv1.Vali
 
 		secret, err := h.generateSecret(service)
 		if err != nil {
+			h.event(webhook, corev1.EventTypeWarning, ReasonCABundleFailed,
+				"Failed to get certificate of service %s/%s: %v", service.Namespace, service.Name, err)
 			return nil, err
 		} else if secret == nil {
 			continue
@@ -274,6 +329,8 @@ func (h *handler) OnValidatingWebhookChange(key string, webhook *adminregv1.Vali
 
 		caBundle, err := caBundleFor(service, secret)
 		if err != nil {
+			h.event(webhook, corev1.EventTypeWarning, ReasonCABundleFailed,
+				"Failed to get CA bundle of service %s/%s: %v", service.Namespace, service.Name, err)
 			return nil, err
 		}
 		if !bytes.Equal(webhookConfig.ClientConfig.CABundle, caBundle) {
@@ -289,6 +346,7 @@ func (h *handler) OnValidatingWebhookChange(key string, webhook *adminregv1.Vali
 		if err != nil {
 			return webhook, err
 		}
+		h.event(webhook, corev1.EventTypeNormal, ReasonCABundleUpdated, "Updated CA bundle of webhooks")
 	}
 
 	return webhook, nil
@@ -296,6 +354,7 @@ func (h *handler) OnValidatingWebhookChange(key string, webhook *adminregv1.Vali
 
 func (h *handler) OnService(key string, service *corev1.Service) (*corev1.Service, error) {
 	if service == nil {
+		forgetCertificate(key)
 		return service, nil
 	}
 
@@ -329,6 +388,16 @@ func (h *handler) OnService(key string, service *corev1.Service) (*corev1.Servic
 		h.crds.Enqueue(crd.Name)
 	}
 
+	if h.apiServices != nil {
+		apiServices, err := h.apiServices.Cache().GetByIndex(byServiceIndex, indexKey)
+		if err != nil {
+			return nil, err
+		}
+		for _, apiService := range apiServices {
+			h.apiServices.Enqueue(apiService.Name)
+		}
+	}
+
 	return nil, err
 }
 
@@ -352,26 +421,94 @@ func (h *handler) OnCRDChange(key string, crd *apiextv1.CustomResourceDefinition
 	}
 
 	secret, err := h.generateSecret(service)
-	if err != nil || secret == nil {
+	if err != nil {
+		h.event(crd, corev1.EventTypeWarning, ReasonCABundleFailed,
+			"Failed to get certificate of service %s/%s: %v", service.Namespace, service.Name, err)
+		return crd, nil
+	} else if secret == nil {
 		return crd, nil
 	}
 
 	caBundle, err := caBundleFor(service, secret)
 	if err != nil {
+		h.event(crd, corev1.EventTypeWarning, ReasonCABundleFailed,
+			"Failed to get CA bundle of service %s/%s: %v", service.Namespace, service.Name, err)
 		return nil, err
 	}
 	if !bytes.Equal(crd.Spec.Conversion.Webhook.ClientConfig.CABundle, caBundle) {
 		crd := crd.DeepCopy()
 		crd.Spec.Conversion.Webhook.ClientConfig.CABundle = caBundle
-		return h.crds.Update(crd)
+		crd, err := h.crds.Update(crd)
+		if err != nil {
+			return crd, err
+		}
+		h.event(crd, corev1.EventTypeNormal, ReasonCABundleUpdated, "Updated CA bundle of conversion webhook")
+		return crd, nil
 	}
 
 	return crd, nil
 }
 
+func (h *handler) OnAPIServiceChange(key string, apiService *apiregv1.APIService) (*apiregv1.APIService, error) {
+	// an APIService skipping TLS verification can't have a CA bundle
+	if apiService == nil || apiService.Spec.Service == nil || apiService.Spec.Service.Name == "" ||
+		apiService.Spec.InsecureSkipTLSVerify {
+		return apiService, nil
+	}
+
+	service, err := h.serviceCache.Get(apiService.Spec.Service.Namespace, apiService.Spec.Service.Name)
+	if apierror.IsNotFound(err) {
+		// OnService will be called when the service is created, which will eventually update the APIService, so no
+		// need to enqueue anything if we don't find the service
+		return apiService, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	secret, err := h.generateSecret(service)
+	if err != nil {
+		h.event(apiService, corev1.EventTypeWarning, ReasonCABundleFailed,
+			"Failed to get certificate of service %s/%s: %v", service.Namespace, service.Name, err)
+		return nil, err
+	} else if secret == nil {
+		return apiService, nil
+	}
+
+	caBundle, err := caBundleFor(service, secret)
+	if err != nil {
+		h.event(apiService, corev1.EventTypeWarning, ReasonCABundleFailed,
+			"Failed to get CA bundle of service %s/%s: %v", service.Namespace, service.Name, err)
+		return nil, err
+	}
+	if !bytes.Equal(apiService.Spec.CABundle, caBundle) {
+		logrus.Debugf("Updating APIService %s", apiService.Name)
+		apiService = apiService.DeepCopy()
+		apiService.Spec.CABundle = caBundle
+		apiService, err := h.apiServices.Update(apiService)
+		if err != nil {
+			return apiService, err
+		}
+		h.event(apiService, corev1.EventTypeNormal, ReasonCABundleUpdated, "Updated CA bundle")
+		return apiService, nil
+	}
+
+	return apiService, nil
+}
+
 func (h *handler) generateSecret(service *corev1.Service) (*corev1.Secret, error) {
+	secret, err := h.ensureSecret(service)
+	if err != nil {
+		h.event(service, corev1.EventTypeWarning, ReasonCertificateFailed,
+			"Failed to issue certificate in secret %s: %v", service.Annotations[SecretAnnotation], err)
+	}
+	return secret, err
+}
+
+func (h *handler) ensureSecret(service *corev1.Service) (*corev1.Secret, error) {
 	secretName := service.Annotations[SecretAnnotation]
 	if secretName == "" {
+		// the Service may have dropped the annotation, its certificate is not managed anymore
+		forgetCertificate(service.Namespace + "/" + service.Name)
 		return nil, nil
 	}
 
@@ -394,7 +531,11 @@ func (h *handler) generateSecret(service *corev1.Service) (*corev1.Secret, error
 	secret, err := h.secretsCache.Get(service.Namespace, secretName)
 	if apierror.IsNotFound(err) {
 		newSecret, err := h.createSecret(service, service.Namespace, secretName, dnsNames)
-		if err != nil {
+		if errors.Is(err, ErrPending) {
+			logrus.Debugf("Certificate for %s/%s is pending: %v", service.Namespace, service.Name, err)
+			h.services.EnqueueAfter(service.Namespace, service.Name, pendingRecheck)
+			return nil, nil
+		} else if err != nil {
 			return nil, err
 		}
 		secret, err = h.secrets.Create(newSecret)
@@ -405,6 +546,10 @@ func (h *handler) generateSecret(service *corev1.Service) (*corev1.Secret, error
 			}
 		} else if err != nil {
 			return nil, err
+		} else {
+			h.commitIssued(service)
+			h.event(service, corev1.EventTypeNormal, ReasonCertificateIssued,
+				"Issued certificate in secret %s valid until %s", secretName, secret.Annotations[NotAfterAnnotation])
 		}
 	} else if err != nil {
 		return nil, err
@@ -416,15 +561,32 @@ func (h *handler) generateSecret(service *corev1.Service) (*corev1.Secret, error
 	}
 
 	updated, updateErr := h.updateSecret(service, secret, dnsNames, cert)
-	if updateErr != nil {
+	if errors.Is(updateErr, ErrPending) {
+		// keep using the current certificate until the new one is issued
+		logrus.Debugf("Certificate for %s/%s is pending: %v", service.Namespace, service.Name, updateErr)
+		observeCertificate(service.Namespace+"/"+service.Name, secret.Namespace, secret.Name, cert.NotAfter)
+		h.services.EnqueueAfter(service.Namespace, service.Name, pendingRecheck)
+		return secret, nil
+	} else if updateErr != nil {
 		return nil, updateErr
 	} else if updated != nil {
+		rotated := !bytes.Equal(updated.Data[corev1.TLSCertKey], secret.Data[corev1.TLSCertKey])
 		secret, err = h.secrets.Update(updated)
 		if err != nil {
 			return nil, err
 		}
+		if rotated {
+			h.commitIssued(service)
+			h.event(service, corev1.EventTypeNormal, ReasonCertificateRotated,
+				"Rotated certificate in secret %s, valid until %s", secretName, secret.Annotations[NotAfterAnnotation])
+			if cert, err = parseCert(secret); err != nil {
+				return nil, err
+			}
+		}
 	}
 
+	observeCertificate(service.Namespace+"/"+service.Name, secret.Namespace, secret.Name, cert.NotAfter)
+
 	if err := h.scheduleNextCertCheck(service, secret); err != nil {
 		return nil, fmt.Errorf("failed to schedule next cert check: %w", err)
 	}
@@ -432,11 +594,39 @@ func (h *handler) generateSecret(service *corev1.Service) (*corev1.Secret, error
 	return secret, nil
 }
 
+// commitIssued tells the issuer of the certificate of the owner that it is stored in its Secret.
+func (h *handler) commitIssued(owner metav1.Object) {
+	issuer, ok := h.issuerFor(owner).(committingIssuer)
+	if !ok {
+		return
+	}
+	if err := issuer.commit(owner); err != nil {
+		logrus.Warnf("Failed to clean up the issued certificate of %s/%s: %v", owner.GetNamespace(), owner.GetName(), err)
+	}
+}
+
 func (h *handler) updateSecret(owner runtime.Object, secret *corev1.Secret, dnsNames []string, cert *x509.Certificate) (*corev1.Secret, error) {
+	ownerMeta, err := meta.Accessor(owner)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := h.certConfigFor(ownerMeta)
+	if err != nil {
+		return nil, err
+	}
+	issuer := h.issuerFor(ownerMeta)
+	issued, err := issuer.Issued(ownerMeta, cert)
+	if err != nil {
+		return nil, err
+	}
+
 	logrus.Debugf("checking cert %s for %s/%s", cert.Subject.CommonName, secret.Namespace, secret.Name)
-	if time.Now().Add(24*60*time.Hour).After(cert.NotAfter) ||
+	if time.Now().Add(cfg.renewBefore).After(cert.NotAfter) ||
 		len(cert.DNSNames) == 0 ||
-		!slice.StringsEqual(cert.DNSNames[1:], dnsNames) {
+		!slice.StringsEqual(cert.DNSNames[1:], dnsNames) ||
+		!cfg.ipsEqual(cert.IPAddresses) ||
+		keyAlgorithmOf(cert.PublicKey) != cfg.keyAlgorithm ||
+		!issued {
 		logrus.Debugf("regenerating cert %s for %s/%s", cert.Subject.CommonName, secret.Namespace, secret.Name)
 		newSecret, err := h.createSecret(owner, secret.Namespace, secret.Name, dnsNames)
 		if err != nil {
@@ -444,11 +634,41 @@ func (h *handler) updateSecret(owner runtime.Object, secret *corev1.Secret, dnsN
 		}
 		secret = secret.DeepCopy()
 		secret.Data = newSecret.Data
+		if secret.Annotations == nil {
+			secret.Annotations = map[string]string{}
+		}
+		for k, v := range newSecret.Annotations {
+			secret.Annotations[k] = v
+		}
 		return secret, nil
 	}
 	logrus.Debugf("cert %s for %s/%s is valid until %s and covers %v", cert.Subject.CommonName, secret.Namespace, secret.Name, cert.NotAfter, cert.DNSNames)
 
-	return nil, nil
+	bundle, err := issuer.CABundle(ownerMeta)
+	if err != nil {
+		return nil, err
+	}
+
+	var updated *corev1.Secret
+	if bundle != nil && !bytes.Equal(secret.Data[CABundleKey], bundle) {
+		logrus.Debugf("updating CA bundle of %s/%s", secret.Namespace, secret.Name)
+		updated = secret.DeepCopy()
+		updated.Data[CABundleKey] = bundle
+	}
+	if needsStatusAnnotations(secret.Annotations, cert) {
+		// secrets created by older versions of needacert have no status annotations
+		if updated == nil {
+			updated = secret.DeepCopy()
+		}
+		if updated.Annotations == nil {
+			updated.Annotations = map[string]string{}
+		}
+		for k, v := range certStatusAnnotations(cert) {
+			updated.Annotations[k] = v
+		}
+	}
+
+	return updated, nil
 }
 
 func (h *handler) scheduleNextCertCheck(obj metav1.Object, secret *corev1.Secret) error {
@@ -457,8 +677,21 @@ func (h *handler) scheduleNextCertCheck(obj metav1.Object, secret *corev1.Secret
 		return fmt.Errorf("cannot parse certificate: %w", err)
 	}
 
-	renewBefore := 24 * 60 * time.Hour
-	nextCheck := time.Until(cert.NotAfter.Add(-renewBefore))
+	cfg, err := h.certConfigFor(obj)
+	if err != nil {
+		return err
+	}
+	nextCheck := time.Until(cert.NotAfter.Add(-cfg.renewBefore))
+
+	if issuer, ok := h.issuerFor(obj).(rolloverIssuer); ok {
+		nextRollover, err := issuer.nextCheck(obj)
+		if err != nil {
+			return err
+		}
+		if !nextRollover.IsZero() && time.Until(nextRollover) < nextCheck {
+			nextCheck = time.Until(nextRollover)
+		}
+	}
 
 	if nextCheck < time.Minute {
 		nextCheck = time.Minute
@@ -472,16 +705,42 @@ func (h *handler) scheduleNextCertCheck(obj metav1.Object, secret *corev1.Secret
 }
 
 func (h *handler) createSecret(owner runtime.Object, ns, name string, dnsNames []string) (*corev1.Secret, error) {
-	cert, key, err := cert.GenerateSelfSignedCertKey(ns+"-"+name, nil, dnsNames)
+	meta, err := meta.Accessor(owner)
 	if err != nil {
 		return nil, err
 	}
 
-	meta, err := meta.Accessor(owner)
+	cfg, err := h.certConfigFor(meta)
+	if err != nil {
+		return nil, err
+	}
+	issued, err := h.issuerFor(meta).Issue(&CertificateRequest{
+		Owner:        meta,
+		CommonName:   ns + "-" + name,
+		DNSNames:     dnsNames,
+		IPAddresses:  cfg.ipAddresses,
+		Validity:     cfg.validity,
+		KeyAlgorithm: cfg.keyAlgorithm,
+	})
 	if err != nil {
 		return nil, err
 	}
 
+	data := map[string][]byte{
+		corev1.TLSCertKey:       issued.CertPEM,
+		corev1.TLSPrivateKeyKey: issued.KeyPEM,
+	}
+	if len(issued.CABundle) > 0 {
+		data[CABundleKey] = issued.CABundle
+	}
+
+	certs, err := cert.ParseCertsPEM(issued.CertPEM)
+	if err != nil {
+		return nil, err
+	}
+	annotations := certStatusAnnotations(certs[0])
+	annotations[LastRotationAnnotation] = time.Now().UTC().Format(time.RFC3339)
+
 	gvk, err := gvk.Get(owner)
 	if err != nil {
 		return nil, err
@@ -498,12 +757,10 @@ func (h *handler) createSecret(owner runtime.Object, ns, name string, dnsNames [
 		ObjectMeta: metav1.ObjectMeta{
 			Name:            name,
 			Namespace:       ns,
+			Annotations:     annotations,
 			OwnerReferences: []metav1.OwnerReference{ref},
 		},
-		Data: map[string][]byte{
-			corev1.TLSCertKey:       cert,
-			corev1.TLSPrivateKeyKey: key,
-		},
+		Data: data,
 		Type: corev1.SecretTypeTLS,
 	}, nil
 }
@@ -18,10 +18,11 @@ import (
 	apierror "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/util/cert"
+	apiregv1 "k8s.io/kube-aggregator/pkg/apis/apiregistration/v1"
 )
 
 func TestCreateSecret(t *testing.T) {
-	h := &handler{}
+	h := &handler{opts: Options{SelfSigned: true}}
 	service := &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "svc",
@@ -43,7 +44,7 @@ func TestUpdateSecret_ExpiredCert_ManyParallel(t *testing.T) {
 	for i := 0; i < runs; i++ {
 		t.Run(fmt.Sprintf("run-%d", i), func(t *testing.T) {
 			t.Parallel()
-			h := &handler{}
+			h := &handler{opts: Options{SelfSigned: true}}
 			service := &corev1.Service{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      "svc",
@@ -155,6 +156,7 @@ func TestHandler_OnMutationWebhookChange(t *testing.T) {
 		}).Times(1)
 
 	h := &handler{
+		opts:             Options{SelfSigned: true},
 		services:         mockServices,
 		serviceCache:     mockServiceCache,
 		secretsCache:     mockSecretsCache,
@@ -263,6 +265,7 @@ func TestHandler_OnValidatingWebhookChange_Parallel(t *testing.T) {
 				}).Times(1)
 
 			h := &handler{
+				opts:               Options{SelfSigned: true},
 				services:           mockServices,
 				serviceCache:       mockServiceCache,
 				secretsCache:       mockSecretsCache,
@@ -370,6 +373,7 @@ func TestHandler_OnMutationWebhookChange_Parallel(t *testing.T) {
 				})
 
 			h := &handler{
+				opts:             Options{SelfSigned: true},
 				services:         mockServices,
 				serviceCache:     mockServiceCache,
 				secretsCache:     mockSecretsCache,
@@ -473,6 +477,7 @@ func TestHandler_OnService_Parallel(t *testing.T) {
 				}).AnyTimes()
 
 			h := &handler{
+				opts:               Options{SelfSigned: true},
 				services:           mockServices,
 				serviceCache:       mockServiceCache,
 				secretsCache:       mockSecretsCache,
@@ -556,6 +561,7 @@ func TestHandler_OnCRDChange_Parallel(t *testing.T) {
 				}).AnyTimes()
 
 			h := &handler{
+				opts:         Options{SelfSigned: true},
 				services:     mockServices,
 				serviceCache: mockServiceCache,
 				secretsCache: mockSecretsCache,
@@ -636,6 +642,7 @@ func TestHandler_GenerateSecret_Race(t *testing.T) {
 		}).AnyTimes()
 
 	h := &handler{
+		opts:         Options{SelfSigned: true},
 		services:     mockServices,
 		secretsCache: mockSecretsCache,
 		secrets:      mockSecrets,
@@ -705,6 +712,7 @@ func TestHandler_GenerateSecret_Race_MultiService(t *testing.T) {
 
 		go func(svc *corev1.Service) {
 			h := &handler{
+				opts:         Options{SelfSigned: true},
 				services:     mockServices,
 				secretsCache: mockSecretsCache,
 				secrets:      mockSecrets,
@@ -759,6 +767,7 @@ func TestHandler_Race_Stress(t *testing.T) {
 	}).AnyTimes()
 
 	h := &handler{
+		opts:               Options{SelfSigned: true},
 		services:           mockServices,
 		serviceCache:       mockServiceCache,
 		secretsCache:       mockSecretsCache,
@@ -999,6 +1008,7 @@ func TestHandler_OnMutationWebhookChange_CAOnlyAnnotation(t *testing.T) {
 		})
 
 	h := &handler{
+		opts:             Options{SelfSigned: true},
 		services:         mockServices,
 		serviceCache:     mockServiceCache,
 		secretsCache:     mockSecretsCache,
@@ -1106,6 +1116,7 @@ func TestHandler_OnMutationWebhookChange_PerServiceAnnotationScoping(t *testing.
 	// Neither service opts in via anything but its own annotation - only
 	// optedInService should get a CA-only bundle.
 	h := &handler{
+		opts:             Options{SelfSigned: true},
 		services:         mockServices,
 		serviceCache:     mockServiceCache,
 		secretsCache:     mockSecretsCache,
@@ -1217,6 +1228,7 @@ func TestHandler_GenerateSecret_Race_SharedSecret(t *testing.T) {
 		}).AnyTimes()
 
 	h := &handler{
+		opts:         Options{SelfSigned: true},
 		services:     mockServices,
 		secretsCache: mockSecretsCache,
 		secrets:      mockSecrets,
@@ -1301,6 +1313,7 @@ func TestHandler_GenerateSecret_StaleCacheAlreadyExists(t *testing.T) {
 		AnyTimes()
 
 	h := &handler{
+		opts:         Options{SelfSigned: true},
 		services:     mockServices,
 		secretsCache: mockSecretsCache,
 		secrets:      mockSecrets,
@@ -1330,7 +1343,7 @@ func TestHandler_scheduleNextCertCheck(t *testing.T) {
 		}).
 		Times(2)
 
-	h := &handler{services: mockServices}
+	h := &handler{opts: Options{SelfSigned: true}, services: mockServices}
 
 	tests := []struct {
 		name      string
@@ -1391,3 +1404,157 @@ func TestHandler_scheduleNextCertCheck(t *testing.T) {
 		})
 	}
 }
+
+func TestHandler_OnAPIServiceChange(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockServices := fake.NewMockControllerInterface[*corev1.Service, *corev1.ServiceList](ctrl)
+	mockServiceCache := fake.NewMockCacheInterface[*corev1.Service](ctrl)
+	mockSecretsCache := fake.NewMockCacheInterface[*corev1.Secret](ctrl)
+	mockSecrets := fake.NewMockControllerInterface[*corev1.Secret, *corev1.SecretList](ctrl)
+	mockAPIServices := fake.NewMockNonNamespacedControllerInterface[*apiregv1.APIService, *apiregv1.APIServiceList](ctrl)
+
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "svc",
+			Namespace: "ns",
+			Annotations: map[string]string{
+				SecretAnnotation: "mysecret",
+			},
+		},
+	}
+
+	certPEM, keyPEM, _ := cert.GenerateSelfSignedCertKey("ns-mysecret", nil, []string{"svc.ns", "svc.ns.svc", "svc.ns.svc.cluster.local"})
+	mockSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "mysecret",
+			Namespace: "ns",
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       certPEM,
+			corev1.TLSPrivateKeyKey: keyPEM,
+		},
+	}
+
+	mockServices.EXPECT().
+		EnqueueAfter("ns", "svc", gomock.Any()).
+		AnyTimes()
+	mockServiceCache.EXPECT().
+		Get("ns", "svc").
+		Return(service, nil).AnyTimes()
+	mockSecretsCache.EXPECT().
+		Get("ns", "mysecret").
+		Return(mockSecret, nil).AnyTimes()
+	mockSecrets.EXPECT().
+		Update(gomock.Any()).
+		DoAndReturn(func(secret *corev1.Secret) (*corev1.Secret, error) {
+			mockSecret = secret
+			return secret, nil
+		}).AnyTimes()
+	mockAPIServices.EXPECT().
+		Update(gomock.Any()).
+		DoAndReturn(func(apiService *apiregv1.APIService) (*apiregv1.APIService, error) {
+			return apiService, nil
+		}).Times(1)
+
+	h := &handler{
+		opts:         Options{SelfSigned: true},
+		services:     mockServices,
+		serviceCache: mockServiceCache,
+		secretsCache: mockSecretsCache,
+		secrets:      mockSecrets,
+		apiServices:  mockAPIServices,
+	}
+
+	apiService := &apiregv1.APIService{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "v1.example.io",
+		},
+		Spec: apiregv1.APIServiceSpec{
+			Group:   "example.io",
+			Version: "v1",
+			Service: &apiregv1.ServiceReference{
+				Namespace: "ns",
+				Name:      "svc",
+			},
+		},
+	}
+
+	updated, err := h.OnAPIServiceChange("v1.example.io", apiService)
+	assert.NoError(t, err)
+	assert.Equal(t, mockSecret.Data[corev1.TLSCertKey], updated.Spec.CABundle)
+	assert.Empty(t, apiService.Spec.CABundle, "cached object must not be modified")
+
+	// an APIService with an up to date CA bundle is not updated again
+	unchanged, err := h.OnAPIServiceChange("v1.example.io", updated)
+	assert.NoError(t, err)
+	assert.Equal(t, updated, unchanged)
+
+	// an APIService skipping TLS verification can't have a CA bundle
+	insecure := apiService.DeepCopy()
+	insecure.Spec.InsecureSkipTLSVerify = true
+	unchanged, err = h.OnAPIServiceChange("v1.example.io", insecure)
+	assert.NoError(t, err)
+	assert.Empty(t, unchanged.Spec.CABundle)
+
+	// a local APIService has no Service
+	local := &apiregv1.APIService{ObjectMeta: metav1.ObjectMeta{Name: "v1."}}
+	unchanged, err = h.OnAPIServiceChange("v1.", local)
+	assert.NoError(t, err)
+	assert.Equal(t, local, unchanged)
+}
+
+func TestHandler_OnService_EnqueuesAPIServices(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockMutatingWebHooks := fake.NewMockNonNamespacedControllerInterface[*adminregv1.MutatingWebhookConfiguration, *adminregv1.MutatingWebhookConfigurationList](ctrl)
+	mockValidatingWebHooks := fake.NewMockNonNamespacedControllerInterface[*adminregv1.ValidatingWebhookConfiguration, *adminregv1.ValidatingWebhookConfigurationList](ctrl)
+	mockCRDs := fake.NewMockNonNamespacedControllerInterface[*apiextv1.CustomResourceDefinition, *apiextv1.CustomResourceDefinitionList](ctrl)
+	mockAPIServices := fake.NewMockNonNamespacedControllerInterface[*apiregv1.APIService, *apiregv1.APIServiceList](ctrl)
+
+	mockMutatingCache := fake.NewMockNonNamespacedCacheInterface[*adminregv1.MutatingWebhookConfiguration](ctrl)
+	mockValidatingCache := fake.NewMockNonNamespacedCacheInterface[*adminregv1.ValidatingWebhookConfiguration](ctrl)
+	mockCRDsCache := fake.NewMockNonNamespacedCacheInterface[*apiextv1.CustomResourceDefinition](ctrl)
+	mockAPIServicesCache := fake.NewMockNonNamespacedCacheInterface[*apiregv1.APIService](ctrl)
+
+	mockMutatingWebHooks.EXPECT().Cache().Return(mockMutatingCache).AnyTimes()
+	mockValidatingWebHooks.EXPECT().Cache().Return(mockValidatingCache).AnyTimes()
+	mockCRDs.EXPECT().Cache().Return(mockCRDsCache).AnyTimes()
+	mockAPIServices.EXPECT().Cache().Return(mockAPIServicesCache).AnyTimes()
+
+	mockMutatingCache.EXPECT().GetByIndex(byServiceIndex, "ns/svc").Return(nil, nil)
+	mockValidatingCache.EXPECT().GetByIndex(byServiceIndex, "ns/svc").Return(nil, nil)
+	mockCRDsCache.EXPECT().GetByIndex(byServiceIndex, "ns/svc").Return(nil, nil)
+	mockAPIServicesCache.EXPECT().GetByIndex(byServiceIndex, "ns/svc").Return([]*apiregv1.APIService{
+		{ObjectMeta: metav1.ObjectMeta{Name: "v1.example.io"}},
+	}, nil)
+	mockAPIServices.EXPECT().Enqueue("v1.example.io")
+
+	h := &handler{
+		mutatingWebHooks:   mockMutatingWebHooks,
+		validatingWebHooks: mockValidatingWebHooks,
+		crds:               mockCRDs,
+		apiServices:        mockAPIServices,
+	}
+
+	// without a secret annotation no secret is generated, but related objects are still enqueued
+	_, err := h.OnService("ns/svc", &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "ns"}})
+	assert.NoError(t, err)
+}
+
+func TestAPIServiceServices(t *testing.T) {
+	keys, err := apiServiceServices(&apiregv1.APIService{
+		Spec: apiregv1.APIServiceSpec{
+			Service: &apiregv1.ServiceReference{Namespace: "ns", Name: "svc"},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ns/svc"}, keys)
+
+	keys, err = apiServiceServices(&apiregv1.APIService{})
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+}
@@ -0,0 +1,53 @@
+package needacert
+
+import (
+	"crypto/x509"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+var (
+	// NotAfterAnnotation is set by needacert on the TLS Secret to the time the certificate expires, in RFC 3339.
+	NotAfterAnnotation = "need-a-cert.cattle.io/not-after"
+	// IssuerAnnotation is set by needacert on the TLS Secret to the common name of the issuer of the certificate.
+	IssuerAnnotation = "need-a-cert.cattle.io/issuer"
+	// LastRotationAnnotation is set by needacert on the TLS Secret to the time the certificate was last issued,
+	// in RFC 3339.
+	LastRotationAnnotation = "need-a-cert.cattle.io/last-rotation"
+)
+
+// Reasons of the Events recorded by needacert.
+const (
+	ReasonCertificateIssued  = "CertificateIssued"
+	ReasonCertificateRotated = "CertificateRotated"
+	ReasonCertificateFailed  = "CertificateFailed"
+	ReasonCABundleUpdated    = "CABundleUpdated"
+	ReasonCABundleFailed     = "CABundleFailed"
+)
+
+// certStatusAnnotations returns the annotations describing cert, as set on the TLS Secret holding it.
+func certStatusAnnotations(cert *x509.Certificate) map[string]string {
+	return map[string]string{
+		NotAfterAnnotation: cert.NotAfter.UTC().Format(time.RFC3339),
+		IssuerAnnotation:   cert.Issuer.CommonName,
+	}
+}
+
+// needsStatusAnnotations returns whether the annotations are missing or out of date for cert.
+func needsStatusAnnotations(annotations map[string]string, cert *x509.Certificate) bool {
+	for k, v := range certStatusAnnotations(cert) {
+		if annotations[k] != v {
+			return true
+		}
+	}
+	return false
+}
+
+// event records an Event on obj if needacert was registered with an EventRecorder.
+func (h *handler) event(obj runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	if h.recorder == nil {
+		return
+	}
+	h.recorder.Eventf(obj, eventType, reason, messageFmt, args...)
+}
@@ -0,0 +1,180 @@
+package needacert
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rancher/wrangler/v3/pkg/generic/fake"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	apierror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/cert"
+)
+
+func TestHandler_GenerateSecret_StatusAndEvents(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockServices := fake.NewMockControllerInterface[*corev1.Service, *corev1.ServiceList](ctrl)
+	mockSecretsCache := fake.NewMockCacheInterface[*corev1.Secret](ctrl)
+	mockSecrets := fake.NewMockControllerInterface[*corev1.Secret, *corev1.SecretList](ctrl)
+
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "svc",
+			Namespace: "ns",
+			Annotations: map[string]string{
+				SecretAnnotation:       "mysecret",
+				KeyAlgorithmAnnotation: string(KeyAlgorithmECDSAP256),
+			},
+		},
+	}
+
+	var stored *corev1.Secret
+	mockSecretsCache.EXPECT().
+		Get("ns", "mysecret").
+		DoAndReturn(func(namespace, name string) (*corev1.Secret, error) {
+			if stored == nil {
+				return nil, apierror.NewNotFound(corev1.Resource("secrets"), name)
+			}
+			return stored, nil
+		}).AnyTimes()
+	mockSecrets.EXPECT().
+		Create(gomock.Any()).
+		DoAndReturn(func(secret *corev1.Secret) (*corev1.Secret, error) {
+			stored = secret
+			return secret, nil
+		})
+	mockSecrets.EXPECT().
+		Update(gomock.Any()).
+		DoAndReturn(func(secret *corev1.Secret) (*corev1.Secret, error) {
+			stored = secret
+			return secret, nil
+		})
+	mockServices.EXPECT().
+		EnqueueAfter("ns", "svc", gomock.Any()).
+		AnyTimes()
+
+	recorder := record.NewFakeRecorder(10)
+	h := &handler{
+		opts:         Options{SelfSigned: true},
+		services:     mockServices,
+		secretsCache: mockSecretsCache,
+		secrets:      mockSecrets,
+		recorder:     recorder,
+	}
+
+	secret, err := h.generateSecret(service)
+	require.NoError(t, err)
+	leaf, err := parseCert(secret)
+	require.NoError(t, err)
+	assert.Equal(t, leaf.NotAfter.UTC().Format(time.RFC3339), secret.Annotations[NotAfterAnnotation])
+	assert.Equal(t, leaf.Issuer.CommonName, secret.Annotations[IssuerAnnotation])
+	assert.NotEmpty(t, secret.Annotations[LastRotationAnnotation])
+	assert.True(t, strings.HasPrefix(<-recorder.Events, "Normal "+ReasonCertificateIssued))
+
+	// changing the configuration of the certificate rotates it
+	service.Annotations[KeyAlgorithmAnnotation] = string(KeyAlgorithmEd25519)
+	rotated, err := h.generateSecret(service)
+	require.NoError(t, err)
+	assert.NotEqual(t, secret.Data[corev1.TLSCertKey], rotated.Data[corev1.TLSCertKey])
+	assert.True(t, strings.HasPrefix(<-recorder.Events, "Normal "+ReasonCertificateRotated))
+
+	// failing to issue a certificate is reported on the service
+	service.Annotations[KeyAlgorithmAnnotation] = "dsa"
+	_, err = h.generateSecret(service)
+	assert.Error(t, err)
+	assert.True(t, strings.HasPrefix(<-recorder.Events, "Warning "+ReasonCertificateFailed))
+}
+
+func TestUpdateSecret_AddsStatusAnnotations(t *testing.T) {
+	certPEM, keyPEM, err := cert.GenerateSelfSignedCertKey("ns-mysecret", nil, []string{"svc.ns", "svc.ns.svc", "svc.ns.svc.cluster.local"})
+	require.NoError(t, err)
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysecret", Namespace: "ns"},
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       certPEM,
+			corev1.TLSPrivateKeyKey: keyPEM,
+		},
+	}
+	leaf, err := parseCert(secret)
+	require.NoError(t, err)
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "ns"}}
+
+	h := &handler{opts: Options{SelfSigned: true}}
+	updated, err := h.updateSecret(service, secret, []string{"svc.ns", "svc.ns.svc", "svc.ns.svc.cluster.local"}, leaf)
+	require.NoError(t, err)
+	require.NotNil(t, updated)
+	assert.Equal(t, secret.Data, updated.Data)
+	assert.Equal(t, leaf.Issuer.CommonName, updated.Annotations[IssuerAnnotation])
+	assert.Equal(t, leaf.NotAfter.UTC().Format(time.RFC3339), updated.Annotations[NotAfterAnnotation])
+	assert.Empty(t, updated.Annotations[LastRotationAnnotation])
+	assert.Nil(t, secret.Annotations)
+
+	// once annotated nothing is left to update
+	updated, err = h.updateSecret(service, updated, []string{"svc.ns", "svc.ns.svc", "svc.ns.svc.cluster.local"}, leaf)
+	require.NoError(t, err)
+	assert.Nil(t, updated)
+}
+
+func TestCertificateCollector(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	MustRegisterMetrics(registry)
+	defer func() {
+		prometheusMetrics = false
+	}()
+
+	notAfter := time.Now().Add(10 * 24 * time.Hour)
+	observeCertificate("ns/svc", "ns", "mysecret", notAfter)
+	observeCertificate("ns/other", "ns", "mysecret", notAfter)
+	defer forgetCertificate("ns/svc")
+	defer forgetCertificate("ns/other")
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 2)
+	for _, family := range families {
+		require.Len(t, family.Metric, 1, "a secret shared by services is reported once")
+		switch family.GetName() {
+		case "wrangler_needacert_certificate_expiry_days":
+			assert.InDelta(t, 10, family.Metric[0].GetGauge().GetValue(), 0.01)
+		case "wrangler_needacert_certificate_not_after_timestamp_seconds":
+			assert.Equal(t, float64(notAfter.Unix()), family.Metric[0].GetGauge().GetValue())
+		default:
+			t.Errorf("unexpected metric %s", family.GetName())
+		}
+	}
+
+	forgetCertificate("ns/svc")
+	forgetCertificate("ns/other")
+	families, err = registry.Gather()
+	require.NoError(t, err)
+	assert.Empty(t, families)
+}
+
+func TestHandler_EnsureSecret_ForgetsCertificate(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	MustRegisterMetrics(registry)
+	defer func() {
+		prometheusMetrics = false
+	}()
+
+	observeCertificate("ns/svc", "ns", "mysecret", time.Now().Add(24*time.Hour))
+	defer forgetCertificate("ns/svc")
+
+	// the Service dropped the annotation
+	h := &handler{}
+	secret, err := h.ensureSecret(&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "ns"}})
+	require.NoError(t, err)
+	assert.Nil(t, secret)
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	assert.Empty(t, families, "the certificate of a Service without the annotation should not be exported")
+}
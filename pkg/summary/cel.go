@@ -0,0 +1,160 @@
+package summary
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/rancher/wrangler/v3/pkg/data"
+	"github.com/sirupsen/logrus"
+)
+
+// celCostLimit bounds the cost of evaluating an expression, as summarizing runs for every object of a cache.
+const celCostLimit = 1000000
+
+var stringSliceType = reflect.TypeOf([]string{})
+
+// celEnv returns the CEL environment shared by all CEL rules.
+var celEnv = sync.OnceValues(func() (*cel.Env, error) {
+	return cel.NewEnv(cel.Variable("object", cel.DynType))
+})
+
+// CELRule summarizes objects with CEL expressions over the object, available as the object variable. Unset
+// expressions leave the summary as is, and expressions that fail to evaluate, for example because a field is
+// missing, are ignored, so guard optional fields with has(). For example:
+//
+//	state: "has(object.status.health) ? object.status.health : ''"
+//	error: "has(object.status.health) && object.status.health == 'Broken'"
+//	transitioning: "has(object.status.pendingChanges) && object.status.pendingChanges > 0"
+//	message: "has(object.status.errors) ? object.status.errors : []"
+type CELRule struct {
+	// State returns the state as a string, an empty string leaving it as is.
+	State string `json:"state,omitempty"`
+	// Error returns whether the object is in error as a bool.
+	Error string `json:"error,omitempty"`
+	// Transitioning returns whether the object is transitioning as a bool.
+	Transitioning string `json:"transitioning,omitempty"`
+	// Message returns a message or a list of messages added to the summary.
+	Message string `json:"message,omitempty"`
+
+	// compiled are the programs of the last compile, so a rule validated before it is set is compiled once.
+	compiled atomic.Pointer[celPrograms]
+}
+
+// celPrograms are the compiled expressions of a CELRule, nil when not set.
+type celPrograms struct {
+	// expressions are the state, error, transitioning and message expressions the programs were compiled from.
+	expressions   [4]string
+	state         cel.Program
+	err           cel.Program
+	transitioning cel.Program
+	message       cel.Program
+}
+
+// compile returns the programs of the expressions of the rule, compiling them if they changed since the last call.
+func (c *CELRule) compile() (*celPrograms, error) {
+	expressions := [4]string{c.State, c.Error, c.Transitioning, c.Message}
+	if programs := c.compiled.Load(); programs != nil && programs.expressions == expressions {
+		return programs, nil
+	}
+
+	env, err := celEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	programs := &celPrograms{expressions: expressions}
+	for _, expr := range []struct {
+		name       string
+		expression string
+		program    *cel.Program
+		types      []*cel.Type
+	}{
+		{"state", c.State, &programs.state, []*cel.Type{cel.StringType}},
+		{"error", c.Error, &programs.err, []*cel.Type{cel.BoolType}},
+		{"transitioning", c.Transitioning, &programs.transitioning, []*cel.Type{cel.BoolType}},
+		{"message", c.Message, &programs.message, []*cel.Type{cel.StringType, cel.ListType(cel.StringType)}},
+	} {
+		if expr.expression == "" {
+			continue
+		}
+		ast, issues := env.Compile(expr.expression)
+		if issues != nil && issues.Err() != nil {
+			return nil, fmt.Errorf("invalid %s expression: %w", expr.name, issues.Err())
+		}
+		if !hasOutputType(ast.OutputType(), expr.types) {
+			return nil, fmt.Errorf("%s expression must return %v, got %s", expr.name, expr.types, ast.OutputType())
+		}
+		*expr.program, err = env.Program(ast, cel.CostLimit(celCostLimit))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s expression: %w", expr.name, err)
+		}
+	}
+	c.compiled.Store(programs)
+	return programs, nil
+}
+
+func hasOutputType(output *cel.Type, allowed []*cel.Type) bool {
+	if output.IsExactType(cel.DynType) {
+		return true
+	}
+	for _, t := range allowed {
+		if output.IsExactType(t) || (t.Kind() == types.ListKind && output.Kind() == types.ListKind) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *celPrograms) apply(obj data.Object, summary Summary) Summary {
+	vars := map[string]interface{}{"object": map[string]interface{}(obj)}
+
+	if state, ok := evalCEL[string](p.state, vars); ok && state != "" {
+		summary.State = state
+	}
+	if isError, ok := evalCEL[bool](p.err, vars); ok && isError {
+		summary.Error = true
+	}
+	if transitioning, ok := evalCEL[bool](p.transitioning, vars); ok && transitioning {
+		summary.Transitioning = true
+	}
+	if p.message != nil {
+		val, _, err := p.message.Eval(vars)
+		if err != nil {
+			logrus.Debugf("failed to evaluate summary message expression: %v", err)
+			return summary
+		}
+		switch message := val.Value().(type) {
+		case string:
+			summary.Message = append(summary.Message, message)
+		default:
+			messages, err := val.ConvertToNative(stringSliceType)
+			if err != nil {
+				logrus.Debugf("summary message expression returned %T, expected a string or a list of strings", message)
+				return summary
+			}
+			summary.Message = append(summary.Message, messages.([]string)...)
+		}
+	}
+	return summary
+}
+
+func evalCEL[T any](program cel.Program, vars map[string]interface{}) (T, bool) {
+	var zero T
+	if program == nil {
+		return zero, false
+	}
+	val, _, err := program.Eval(vars)
+	if err != nil {
+		logrus.Debugf("failed to evaluate summary expression: %v", err)
+		return zero, false
+	}
+	result, ok := val.Value().(T)
+	if !ok {
+		logrus.Debugf("summary expression returned %T, expected %T", val.Value(), zero)
+	}
+	return result, ok
+}
@@ -0,0 +1,216 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/rancher/wrangler/v3/pkg/summary"
+	"github.com/rancher/wrangler/v3/pkg/summary/lister"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// defaultPageSize is the size of the pages fetched from the API server by ListSummaries when the list options have
+// no limit.
+const defaultPageSize = 500
+
+// ListOptions filter, sort and paginate summarized objects. Objects are ordered by namespace and name, or by state,
+// namespace and name with SortByState.
+type ListOptions struct {
+	// States keeps the objects in one of the states, all objects if empty.
+	States []string
+	// Error keeps the objects whose error flag is the given value, all objects if nil.
+	Error *bool
+	// Transitioning keeps the objects whose transitioning flag is the given value, all objects if nil.
+	Transitioning *bool
+	// SortByState orders the objects by state first.
+	SortByState bool
+	// Limit is the maximum number of objects returned, all objects if zero.
+	Limit int64
+	// Continue is the continue token of the previous page, returned in its list metadata. The other options must be
+	// the same as for the previous page.
+	Continue string
+}
+
+// continueToken is the position of the last object of a page.
+type continueToken struct {
+	SortByState bool   `json:"sortByState,omitempty"`
+	State       string `json:"state,omitempty"`
+	Namespace   string `json:"namespace,omitempty"`
+	Name        string `json:"name"`
+}
+
+// Matches returns whether the object passes the filters of the options.
+func (o *ListOptions) Matches(obj *summary.SummarizedObject) bool {
+	if len(o.States) > 0 && !contains(o.States, obj.State) {
+		return false
+	}
+	if o.Error != nil && *o.Error != obj.Error {
+		return false
+	}
+	if o.Transitioning != nil && *o.Transitioning != obj.Transitioning {
+		return false
+	}
+	return true
+}
+
+// Apply filters, sorts and paginates the objects, returning a list with the continue token and remaining item
+// count of the next page, if any.
+func (o *ListOptions) Apply(objs []*summary.SummarizedObject) (*summary.SummarizedObjectList, error) {
+	var after *continueToken
+	if o.Continue != "" {
+		token, err := o.decodeContinue()
+		if err != nil {
+			return nil, err
+		}
+		after = token
+	}
+
+	var matching []continueToken
+	byToken := map[continueToken]*summary.SummarizedObject{}
+	for _, obj := range objs {
+		if !o.Matches(obj) {
+			continue
+		}
+		token := o.token(obj)
+		if after != nil && !o.less(*after, token) {
+			continue
+		}
+		matching = append(matching, token)
+		byToken[token] = obj
+	}
+	sort.Slice(matching, func(i, j int) bool {
+		return o.less(matching[i], matching[j])
+	})
+
+	list := &summary.SummarizedObjectList{}
+	page := matching
+	if o.Limit > 0 && int64(len(matching)) > o.Limit {
+		page = matching[:o.Limit]
+		remaining := int64(len(matching)) - o.Limit
+		list.RemainingItemCount = &remaining
+		next, err := json.Marshal(page[len(page)-1])
+		if err != nil {
+			return nil, err
+		}
+		list.Continue = base64.RawURLEncoding.EncodeToString(next)
+	}
+	list.Items = make([]summary.SummarizedObject, 0, len(page))
+	for _, token := range page {
+		list.Items = append(list.Items, *byToken[token])
+	}
+	return list, nil
+}
+
+func (o *ListOptions) decodeContinue() (*continueToken, error) {
+	data, err := base64.RawURLEncoding.DecodeString(o.Continue)
+	if err != nil {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("invalid continue token: %v", err))
+	}
+	token := &continueToken{}
+	if err := json.Unmarshal(data, token); err != nil {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("invalid continue token: %v", err))
+	}
+	if token.SortByState != o.SortByState {
+		return nil, apierrors.NewBadRequest("continue token was issued for a different sort order")
+	}
+	return token, nil
+}
+
+func (o *ListOptions) token(obj *summary.SummarizedObject) continueToken {
+	token := continueToken{
+		SortByState: o.SortByState,
+		Namespace:   obj.Namespace,
+		Name:        obj.Name,
+	}
+	if o.SortByState {
+		token.State = obj.State
+	}
+	return token
+}
+
+func (o *ListOptions) less(a, b continueToken) bool {
+	if a.State != b.State {
+		return a.State < b.State
+	}
+	if a.Namespace != b.Namespace {
+		return a.Namespace < b.Namespace
+	}
+	return a.Name < b.Name
+}
+
+// ListSummaries lists the summarized objects of the client matching the list options, filtered, sorted and
+// paginated with the summary list options. The objects are fetched from the API server in pages of opts.Limit
+// objects, as all of them have to be summarized to be filtered, and opts.Continue is ignored in favor of the
+// continue token of the summary list options.
+func ListSummaries(ctx context.Context, client ResourceInterface, opts metav1.ListOptions, summaryOpts *ListOptions) (*summary.SummarizedObjectList, error) {
+	if opts.Limit <= 0 {
+		opts.Limit = defaultPageSize
+	}
+	opts.Continue = ""
+
+	var (
+		objs     []*summary.SummarizedObject
+		typeMeta metav1.TypeMeta
+		rv       string
+	)
+	for {
+		page, err := client.List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		typeMeta = page.TypeMeta
+		if rv == "" {
+			rv = page.ResourceVersion
+		}
+		for i := range page.Items {
+			objs = append(objs, &page.Items[i])
+		}
+		if page.Continue == "" {
+			break
+		}
+		opts.Continue = page.Continue
+	}
+
+	list, err := apply(summaryOpts, objs)
+	if err != nil {
+		return nil, err
+	}
+	list.TypeMeta = typeMeta
+	list.ResourceVersion = rv
+	return list, nil
+}
+
+// ListCachedSummaries lists the summarized objects of the lister, usually backed by the cache of a summary informer,
+// matching the selector, filtered, sorted and paginated with the summary list options. Unlike ListSummaries, it
+// does not call the API server, which makes it cheap to list a few objects out of many.
+func ListCachedSummaries(l lister.NamespaceLister, selector labels.Selector, summaryOpts *ListOptions) (*summary.SummarizedObjectList, error) {
+	if selector == nil {
+		selector = labels.Everything()
+	}
+	objs, err := l.List(selector)
+	if err != nil {
+		return nil, err
+	}
+	return apply(summaryOpts, objs)
+}
+
+func apply(opts *ListOptions, objs []*summary.SummarizedObject) (*summary.SummarizedObjectList, error) {
+	if opts == nil {
+		opts = &ListOptions{}
+	}
+	return opts.Apply(objs)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
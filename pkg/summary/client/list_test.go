@@ -0,0 +1,125 @@
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rancher/wrangler/v3/pkg/summary"
+	"github.com/rancher/wrangler/v3/pkg/summary/lister"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+func summarized(namespace, name string, s summary.Summary) summary.SummarizedObject {
+	obj := summary.SummarizedObject{Summary: s}
+	obj.Namespace = namespace
+	obj.Name = name
+	return obj
+}
+
+var testObjects = []summary.SummarizedObject{
+	summarized("b", "web", summary.Summary{State: "active"}),
+	summarized("a", "db", summary.Summary{State: "error", Error: true}),
+	summarized("a", "web", summary.Summary{State: "updating", Transitioning: true}),
+	summarized("c", "api", summary.Summary{State: "error", Error: true}),
+	summarized("a", "api", summary.Summary{State: "active"}),
+}
+
+// pagedClient lists testObjects in pages of the requested limit.
+type pagedClient struct {
+	calls int
+}
+
+func (p *pagedClient) List(_ context.Context, opts metav1.ListOptions) (*summary.SummarizedObjectList, error) {
+	p.calls++
+	start := 0
+	if opts.Continue != "" {
+		start = int(opts.Continue[0] - '0')
+	}
+	end := start + int(opts.Limit)
+	list := &summary.SummarizedObjectList{ListMeta: metav1.ListMeta{ResourceVersion: "10"}}
+	if end < len(testObjects) {
+		list.Continue = string(rune('0' + end))
+	} else {
+		end = len(testObjects)
+	}
+	list.Items = testObjects[start:end]
+	return list, nil
+}
+
+func (p *pagedClient) Watch(context.Context, metav1.ListOptions) (watch.Interface, error) {
+	return watch.NewFake(), nil
+}
+
+func names(list *summary.SummarizedObjectList) []string {
+	var result []string
+	for _, obj := range list.Items {
+		result = append(result, obj.Namespace+"/"+obj.Name)
+	}
+	return result
+}
+
+func TestListSummaries(t *testing.T) {
+	yes := true
+	client := &pagedClient{}
+	list, err := ListSummaries(context.Background(), client, metav1.ListOptions{Limit: 2}, &ListOptions{Error: &yes})
+	require.NoError(t, err)
+	assert.Equal(t, 3, client.calls, "all pages must be fetched")
+	assert.Equal(t, "10", list.ResourceVersion)
+	assert.Equal(t, []string{"a/db", "c/api"}, names(list))
+	assert.Empty(t, list.Continue)
+
+	list, err = ListSummaries(context.Background(), &pagedClient{}, metav1.ListOptions{}, &ListOptions{States: []string{"active", "updating"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a/api", "a/web", "b/web"}, names(list))
+}
+
+func TestListOptions_SortAndPaginate(t *testing.T) {
+	var objs []*summary.SummarizedObject
+	for i := range testObjects {
+		objs = append(objs, &testObjects[i])
+	}
+
+	opts := &ListOptions{SortByState: true, Limit: 2}
+	var pages [][]string
+	for {
+		list, err := opts.Apply(objs)
+		require.NoError(t, err)
+		pages = append(pages, names(list))
+		if list.Continue == "" {
+			assert.Nil(t, list.RemainingItemCount)
+			break
+		}
+		require.NotNil(t, list.RemainingItemCount)
+		opts.Continue = list.Continue
+	}
+	assert.Equal(t, [][]string{{"a/api", "b/web"}, {"a/db", "c/api"}, {"a/web"}}, pages)
+
+	_, err := (&ListOptions{Continue: opts.Continue}).Apply(objs)
+	assert.True(t, apierrors.IsBadRequest(err), "a token of another sort order must be rejected")
+	_, err = (&ListOptions{Continue: "!"}).Apply(objs)
+	assert.True(t, apierrors.IsBadRequest(err))
+}
+
+func TestListCachedSummaries(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for i := range testObjects {
+		require.NoError(t, indexer.Add(&testObjects[i]))
+	}
+	l := lister.New(indexer, schema.GroupVersionResource{Version: "v1", Resource: "widgets"})
+
+	yes := true
+	list, err := ListCachedSummaries(l, nil, &ListOptions{Error: &yes, Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a/db"}, names(list))
+	assert.Equal(t, int64(1), *list.RemainingItemCount)
+
+	list, err = ListCachedSummaries(l.Namespace("a"), nil, &ListOptions{SortByState: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a/api", "a/db", "a/web"}, names(list))
+}
@@ -5,6 +5,7 @@ import (
 
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 
 	"github.com/rancher/wrangler/v3/pkg/data"
 )
@@ -93,7 +94,15 @@ func (c Condition) Equals(other Condition) bool {
 		c.Message() == other.Message()
 }
 
+// NormalizeConditions sets the error and transitioning fields of the conditions of the object with the condition
+// summarizers of the DefaultRegistry.
 func NormalizeConditions(runtimeObj runtime.Object) {
+	defaultRegistry.NormalizeConditions(runtimeObj)
+}
+
+// normalizeConditionsWith normalizes the conditions of the object with the condition summarizers returned for its
+// GVK.
+func normalizeConditionsWith(runtimeObj runtime.Object, summarizersFor func(gvk schema.GroupVersionKind) []Summarizer) {
 	if runtimeObj == nil {
 		return
 	}
@@ -106,15 +115,15 @@ func NormalizeConditions(runtimeObj runtime.Object) {
 	obj := data.Object(unstr.Object)
 
 	if conditions := obj.Slice("status", "conditions"); len(conditions) > 0 {
-		normalizeAndSetConditions(obj, conditions, "status", "conditions")
+		normalizeAndSetConditions(obj, conditions, summarizersFor(unstr.GroupVersionKind()), "status", "conditions")
 	}
 }
 
-func normalizeAndSetConditions(obj data.Object, conditions []data.Object, path ...string) {
+func normalizeAndSetConditions(obj data.Object, conditions []data.Object, summarizers []Summarizer, path ...string) {
 	var newConditions []interface{}
 	for _, condition := range conditions {
 		var summary Summary
-		for _, summarizer := range ConditionSummarizers {
+		for _, summarizer := range summarizers {
 			summary = summarizer(obj, []Condition{{Object: condition}}, summary)
 		}
 		condition.Set("error", summary.Error)
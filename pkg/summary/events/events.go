@@ -0,0 +1,159 @@
+// Package events summarizes objects with the Warning Events about them, so that an object whose own status looks
+// healthy or pending, such as a Pod stuck on FailedScheduling, shows why.
+package events
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/rancher/wrangler/v3/pkg/data"
+	corecontrollers "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
+	"github.com/rancher/wrangler/v3/pkg/summary"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// InvolvedObjectIndex indexes Events by the UID of their involved object.
+	InvolvedObjectIndex = "summary.cattle.io/involved-object"
+
+	defaultWindow = 15 * time.Minute
+)
+
+// DefaultFatalReasons are the reasons of the Warning Events flagging objects as errors by default.
+var DefaultFatalReasons = []string{
+	"FailedScheduling",
+	"FailedMount",
+	"FailedAttachVolume",
+	"FailedCreate",
+	"FailedCreatePodSandBox",
+	"InvalidImageName",
+	"ErrImageNeverPull",
+}
+
+// Options configure a Summarizer.
+type Options struct {
+	// Window is how far back Events are considered, from the last time they occurred. Defaults to 15 minutes.
+	Window time.Duration
+	// Reasons are the reasons of the Warning Events added to the summary, all of them if empty.
+	Reasons []string
+	// FatalReasons are the reasons of the Warning Events flagging the object as an error. Defaults to
+	// DefaultFatalReasons.
+	FatalReasons []string
+}
+
+// Summarizer adds the recent Warning Events about an object to its summary, one message per reason in the format
+// "<reason>: <message>", most recent first, and flags the object as an error for fatal reasons. It is a
+// summary.Summarizer to be registered after the built-in ones:
+//
+//	registry.RegisterSummarizer(schema.GroupVersionKind{}, events.NewSummarizer(cache, nil).Summarize, summary.PriorityEvents)
+type Summarizer struct {
+	events  corecontrollers.EventCache
+	window  time.Duration
+	reasons map[string]bool
+	fatal   map[string]bool
+	now     func() time.Time
+}
+
+// NewSummarizer returns a Summarizer looking up Events in the cache, adding the InvolvedObjectIndex to it. It must
+// be called before the cache is started.
+func NewSummarizer(events corecontrollers.EventCache, opts *Options) *Summarizer {
+	if opts == nil {
+		opts = &Options{}
+	}
+	s := &Summarizer{
+		events: events,
+		window: opts.Window,
+		fatal:  toSet(opts.FatalReasons),
+		now:    time.Now,
+	}
+	if s.window <= 0 {
+		s.window = defaultWindow
+	}
+	if len(opts.Reasons) > 0 {
+		s.reasons = toSet(opts.Reasons)
+	}
+	if opts.FatalReasons == nil {
+		s.fatal = toSet(DefaultFatalReasons)
+	}
+	events.AddIndexer(InvolvedObjectIndex, involvedObject)
+	return s
+}
+
+func involvedObject(event *corev1.Event) ([]string, error) {
+	if event.InvolvedObject.UID == "" {
+		return nil, nil
+	}
+	return []string{string(event.InvolvedObject.UID)}, nil
+}
+
+// Summarize adds the recent Warning Events about the object to the summary.
+func (s *Summarizer) Summarize(obj data.Object, _ []summary.Condition, result summary.Summary) summary.Summary {
+	uid := obj.String("metadata", "uid")
+	if uid == "" {
+		return result
+	}
+	events, err := s.events.GetByIndex(InvolvedObjectIndex, uid)
+	if err != nil {
+		logrus.Debugf("failed to get the events of %s: %v", uid, err)
+		return result
+	}
+
+	since := s.now().Add(-s.window)
+	latest := map[string]*corev1.Event{}
+	for _, event := range events {
+		if event.Type != corev1.EventTypeWarning || (s.reasons != nil && !s.reasons[event.Reason]) {
+			continue
+		}
+		if lastSeen(event).Before(since) {
+			continue
+		}
+		if current, ok := latest[event.Reason]; !ok || lastSeen(event).After(lastSeen(current)) {
+			latest[event.Reason] = event
+		}
+	}
+
+	recent := make([]*corev1.Event, 0, len(latest))
+	for _, event := range latest {
+		recent = append(recent, event)
+	}
+	sort.Slice(recent, func(i, j int) bool {
+		if !lastSeen(recent[i]).Equal(lastSeen(recent[j])) {
+			return lastSeen(recent[i]).After(lastSeen(recent[j]))
+		}
+		return recent[i].Reason < recent[j].Reason
+	})
+
+	for _, event := range recent {
+		result.Message = append(result.Message, fmt.Sprintf("%s: %s", event.Reason, event.Message))
+		if s.fatal[event.Reason] {
+			result.Error = true
+			if result.State == "active" || result.State == "" {
+				result.State = "error"
+			}
+		}
+	}
+	return result
+}
+
+// lastSeen returns the last time the event occurred.
+func lastSeen(event *corev1.Event) time.Time {
+	switch {
+	case event.Series != nil && !event.Series.LastObservedTime.IsZero():
+		return event.Series.LastObservedTime.Time
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	case !event.EventTime.IsZero():
+		return event.EventTime.Time
+	}
+	return event.CreationTimestamp.Time
+}
+
+func toSet(values []string) map[string]bool {
+	result := make(map[string]bool, len(values))
+	for _, value := range values {
+		result[value] = true
+	}
+	return result
+}
@@ -0,0 +1,113 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rancher/wrangler/v3/pkg/generic"
+	"github.com/rancher/wrangler/v3/pkg/generic/fake"
+	"github.com/rancher/wrangler/v3/pkg/summary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var now = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func event(eventType, reason, message string, ago time.Duration) *corev1.Event {
+	return &corev1.Event{
+		InvolvedObject: corev1.ObjectReference{Kind: "Pod", Namespace: "ns", Name: "web", UID: "uid"},
+		Type:           eventType,
+		Reason:         reason,
+		Message:        message,
+		LastTimestamp:  metav1.NewTime(now.Add(-ago)),
+	}
+}
+
+func pendingPod() *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata":   map[string]interface{}{"name": "web", "namespace": "ns", "uid": "uid"},
+		"status":     map[string]interface{}{"phase": "Pending"},
+	}}
+}
+
+func newSummarizer(t *testing.T, opts *Options, events ...*corev1.Event) *Summarizer {
+	ctrl := gomock.NewController(t)
+	cache := fake.NewMockCacheInterface[*corev1.Event](ctrl)
+
+	var index generic.Indexer[*corev1.Event]
+	cache.EXPECT().AddIndexer(InvolvedObjectIndex, gomock.Any()).Do(func(_ string, indexer generic.Indexer[*corev1.Event]) {
+		index = indexer
+	})
+	cache.EXPECT().GetByIndex(InvolvedObjectIndex, gomock.Any()).DoAndReturn(func(_, key string) ([]*corev1.Event, error) {
+		var result []*corev1.Event
+		for _, event := range events {
+			keys, err := index(event)
+			require.NoError(t, err)
+			if len(keys) == 1 && keys[0] == key {
+				result = append(result, event)
+			}
+		}
+		return result, nil
+	}).AnyTimes()
+
+	s := NewSummarizer(cache, opts)
+	s.now = func() time.Time { return now }
+	return s
+}
+
+func summarize(s *Summarizer) summary.Summary {
+	registry := summary.NewRegistry()
+	registry.RegisterSummarizer(schema.GroupVersionKind{}, s.Summarize, summary.PriorityEvents)
+	return registry.Summarize(pendingPod())
+}
+
+func TestSummarizer(t *testing.T) {
+	other := event(corev1.EventTypeWarning, "FailedMount", "other pod", time.Minute)
+	other.InvolvedObject.UID = types.UID("other")
+
+	s := newSummarizer(t, nil,
+		event(corev1.EventTypeWarning, "FailedScheduling", "0/3 nodes are available", 5*time.Minute),
+		event(corev1.EventTypeWarning, "FailedScheduling", "0/2 nodes are available", time.Minute),
+		event(corev1.EventTypeWarning, "Unhealthy", "Readiness probe failed", 2*time.Minute),
+		event(corev1.EventTypeWarning, "BackOff", "too old", time.Hour),
+		event(corev1.EventTypeNormal, "Scheduled", "assigned", time.Minute),
+		other,
+	)
+	result := summarize(s)
+
+	assert.True(t, result.Error, "FailedScheduling is fatal")
+	assert.Equal(t, "pending", result.State)
+	assert.Equal(t, []string{
+		"FailedScheduling: 0/2 nodes are available",
+		"Unhealthy: Readiness probe failed",
+	}, result.Message[len(result.Message)-2:])
+	for _, message := range result.Message {
+		assert.NotContains(t, message, "too old")
+		assert.NotContains(t, message, "other pod")
+		assert.NotContains(t, message, "assigned")
+	}
+}
+
+func TestSummarizer_Options(t *testing.T) {
+	events := []*corev1.Event{
+		event(corev1.EventTypeWarning, "FailedScheduling", "0/2 nodes are available", time.Minute),
+		event(corev1.EventTypeWarning, "Unhealthy", "Readiness probe failed", 2*time.Hour),
+	}
+
+	result := summarize(newSummarizer(t, &Options{Reasons: []string{"Unhealthy"}, Window: 3 * time.Hour}, events...))
+	assert.False(t, result.Error)
+	assert.Contains(t, result.Message, "Unhealthy: Readiness probe failed")
+	assert.NotContains(t, result.Message, "FailedScheduling: 0/2 nodes are available")
+
+	result = summarize(newSummarizer(t, &Options{FatalReasons: []string{}}, events...))
+	assert.False(t, result.Error, "no reason is fatal")
+	assert.Contains(t, result.Message, "FailedScheduling: 0/2 nodes are available")
+}
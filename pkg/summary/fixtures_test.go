@@ -0,0 +1,18 @@
+package summary_test
+
+import (
+	"testing"
+
+	"github.com/rancher/wrangler/v3/pkg/summary"
+	"github.com/rancher/wrangler/v3/pkg/summary/summarytest"
+)
+
+// TestFixtures checks the summaries of the objects of testdata/fixtures. Run
+// go run ./summarytest/record testdata/fixtures to record the summaries after changing a summarizer.
+func TestFixtures(t *testing.T) {
+	summarytest.Run(t, "testdata/fixtures", nil)
+}
+
+func TestFixtures_Registry(t *testing.T) {
+	summarytest.Run(t, "testdata/fixtures", summary.NewRegistry().Summarize)
+}
@@ -0,0 +1,270 @@
+package summary
+
+import (
+	"fmt"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// defaultMaxDepth is the default depth of the trees built by BuildTree, enough for a Deployment, its Pods and the
+// objects they use.
+const defaultMaxDepth = 5
+
+// ObjectGetter looks up the objects related to each other, usually from caches. See informer.NewObjectGetter for
+// one backed by summary informers.
+type ObjectGetter interface {
+	// Get returns the object, or an error for which apierrors.IsNotFound is true if it does not exist.
+	Get(gvk schema.GroupVersionKind, namespace, name string) (runtime.Object, error)
+	// List returns the objects of a namespace matching the selector.
+	List(gvk schema.GroupVersionKind, namespace string, selector labels.Selector) ([]runtime.Object, error)
+}
+
+// TreeOptions configure BuildTree.
+type TreeOptions struct {
+	// MaxDepth is how many relationships are followed from the root. Defaults to 5.
+	MaxDepth int
+	// Summarize summarizes the objects of the tree. Defaults to SummarizeWithOptions, use Registry.Summarize to
+	// summarize with a Registry.
+	Summarize func(runtime.Object) Summary
+}
+
+// Node is an object of a tree built from the relationships of its summary.
+type Node struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+	// Relationship is the type of the relationship of the parent to the object, empty for the root.
+	Relationship string `json:"relationship,omitempty"`
+	// Missing is set if the object is referenced by its parent but does not exist.
+	Missing bool `json:"missing,omitempty"`
+	// Error is set if the object, or the objects selected by its parent, could not be looked up, for example
+	// because its kind is unknown or access to it is forbidden.
+	Error string `json:"error,omitempty"`
+	// Summary is the summary of the object alone.
+	Summary Summary `json:"summary"`
+	// Aggregated is the summary of the object rolled up with the summaries of its children: an object whose
+	// children are in error or transitioning is too, with the messages of the objects causing it.
+	Aggregated Summary `json:"aggregated"`
+	Children   []*Node `json:"children,omitempty"`
+}
+
+// String returns the kind, namespace and name of the object.
+func (n *Node) String() string {
+	if n.Namespace == "" {
+		return n.Kind + " " + n.Name
+	}
+	return n.Kind + " " + n.Namespace + "/" + n.Name
+}
+
+// RootCauses returns the paths from the node to the objects that are not ready while all their children are, which
+// are why the node is not ready.
+func (n *Node) RootCauses() [][]*Node {
+	var result [][]*Node
+	for _, child := range n.Children {
+		if child.Aggregated.IsReady() {
+			continue
+		}
+		for _, path := range child.RootCauses() {
+			result = append(result, append([]*Node{n}, path...))
+		}
+	}
+	if len(result) == 0 && !n.Summary.IsReady() {
+		result = append(result, []*Node{n})
+	}
+	return result
+}
+
+// BuildTree builds the tree of the objects the object uses, creates or selects through the relationships of their
+// summaries, and rolls up their health. Objects owning the object are not part of the tree. Referenced objects
+// that do not exist are added as missing, in error, and objects that could not be looked up are added in error
+// with the lookup error.
+func BuildTree(getter ObjectGetter, obj runtime.Object, opts *TreeOptions) (*Node, error) {
+	var options TreeOptions
+	if opts != nil {
+		options = *opts
+	}
+	if options.MaxDepth <= 0 {
+		options.MaxDepth = defaultMaxDepth
+	}
+	if options.Summarize == nil {
+		options.Summarize = func(obj runtime.Object) Summary {
+			return SummarizeWithOptions(obj, nil)
+		}
+	}
+
+	b := &treeBuilder{
+		getter: getter,
+		opts:   options,
+	}
+	return b.build(obj, "", 0, map[string]bool{})
+}
+
+type treeBuilder struct {
+	getter ObjectGetter
+	opts   TreeOptions
+}
+
+func (b *treeBuilder) build(obj runtime.Object, relationship string, depth int, visiting map[string]bool) (*Node, error) {
+	objMeta, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+	node := &Node{
+		Namespace:    objMeta.GetNamespace(),
+		Name:         objMeta.GetName(),
+		Relationship: relationship,
+		Summary:      b.opts.Summarize(obj),
+	}
+	node.APIVersion, node.Kind = obj.GetObjectKind().GroupVersionKind().ToAPIVersionAndKind()
+
+	key := node.APIVersion + "/" + node.String()
+	if depth < b.opts.MaxDepth && !visiting[key] {
+		// only objects on the path to the root are skipped, an object used by several children shows up in each
+		visiting[key] = true
+		defer delete(visiting, key)
+
+		for _, rel := range node.Summary.Relationships {
+			children, err := b.children(node, rel, depth, visiting)
+			if err != nil {
+				return nil, err
+			}
+			node.Children = append(node.Children, children...)
+		}
+	}
+
+	node.Aggregated = aggregate(node)
+	return node, nil
+}
+
+func (b *treeBuilder) children(parent *Node, rel Relationship, depth int, visiting map[string]bool) ([]*Node, error) {
+	if rel.Inbound {
+		return nil, nil
+	}
+	gvk := schema.FromAPIVersionAndKind(rel.APIVersion, rel.Kind)
+	namespace := rel.Namespace
+	if namespace == "" {
+		namespace = parent.Namespace
+	}
+
+	if rel.Selector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(rel.Selector)
+		if err != nil {
+			return nil, err
+		}
+		objs, err := b.getter.List(gvk, namespace, selector)
+		if err != nil {
+			rel.Name = selector.String()
+			return []*Node{lookupErrorNode(rel, namespace, fmt.Errorf("failed to list %s: %w", rel.Kind, err))}, nil
+		}
+		var result []*Node
+		for _, obj := range objs {
+			child, err := b.build(obj, rel.Type, depth+1, visiting)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, child)
+		}
+		return result, nil
+	}
+
+	if rel.Name == "" {
+		return nil, nil
+	}
+	obj, err := b.getter.Get(gvk, namespace, rel.Name)
+	if apierrors.IsNotFound(err) {
+		return []*Node{missingNode(rel, namespace)}, nil
+	} else if err != nil {
+		return []*Node{lookupErrorNode(rel, namespace, fmt.Errorf("failed to get %s: %w", rel.Kind, err))}, nil
+	}
+	child, err := b.build(obj, rel.Type, depth+1, visiting)
+	if err != nil {
+		return nil, err
+	}
+	return []*Node{child}, nil
+}
+
+func missingNode(rel Relationship, namespace string) *Node {
+	node := &Node{
+		APIVersion:   rel.APIVersion,
+		Kind:         rel.Kind,
+		Namespace:    namespace,
+		Name:         rel.Name,
+		Relationship: rel.Type,
+		Missing:      true,
+	}
+	node.Summary = Summary{
+		State:   "missing",
+		Error:   true,
+		Message: []string{"not found"},
+	}
+	node.Aggregated = node.Summary
+	return node
+}
+
+// lookupErrorNode returns the node of an object that could not be looked up, named after the selector for
+// selected objects.
+func lookupErrorNode(rel Relationship, namespace string, err error) *Node {
+	node := &Node{
+		APIVersion:   rel.APIVersion,
+		Kind:         rel.Kind,
+		Namespace:    namespace,
+		Name:         rel.Name,
+		Relationship: rel.Type,
+		Error:        err.Error(),
+	}
+	node.Summary = Summary{
+		State:   "unknown",
+		Error:   true,
+		Message: []string{err.Error()},
+	}
+	node.Aggregated = node.Summary
+	return node
+}
+
+// aggregate rolls up the health of the children of the node to it.
+func aggregate(node *Node) Summary {
+	result := node.Summary
+	result.Message = append([]string{}, node.Summary.Message...)
+
+	for _, child := range node.Children {
+		if child.Aggregated.IsReady() {
+			continue
+		}
+		result.Error = result.Error || child.Aggregated.Error
+		result.Transitioning = result.Transitioning || child.Aggregated.Transitioning
+	}
+	if node.Summary.IsReady() && !result.IsReady() {
+		if result.Error {
+			result.State = "error"
+		} else {
+			result.State = "in-progress"
+		}
+	}
+
+	for _, path := range node.RootCauses() {
+		cause := path[len(path)-1]
+		if cause == node {
+			continue
+		}
+		names := make([]string, 0, len(path)-1)
+		for _, n := range path[1:] {
+			names = append(names, n.String())
+		}
+		message := strings.Join(names, " -> ")
+		if len(cause.Summary.Message) > 0 {
+			message += ": " + strings.Join(cause.Summary.Message, ", ")
+		} else {
+			message += " is " + cause.Summary.State
+		}
+		result.Message = append(result.Message, message)
+	}
+	result.Message = dedupMessage(result.Message)
+	return result
+}
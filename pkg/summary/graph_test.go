@@ -0,0 +1,215 @@
+package summary
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// fakeGetter holds objects by name, all in the ns namespace.
+type fakeGetter map[string]*unstructured.Unstructured
+
+func (f fakeGetter) Get(gvk schema.GroupVersionKind, namespace, name string) (runtime.Object, error) {
+	obj, ok := f[name]
+	if !ok || obj.GroupVersionKind() != gvk || obj.GetNamespace() != namespace {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Resource: gvk.Kind}, name)
+	}
+	return obj, nil
+}
+
+func (f fakeGetter) List(gvk schema.GroupVersionKind, namespace string, selector labels.Selector) ([]runtime.Object, error) {
+	var result []runtime.Object
+	for _, obj := range f {
+		if obj.GroupVersionKind() == gvk && obj.GetNamespace() == namespace && selector.Matches(labels.Set(obj.GetLabels())) {
+			result = append(result, obj)
+		}
+	}
+	return result, nil
+}
+
+func treeObject(kind, name string, objLabels map[string]string) *unstructured.Unstructured {
+	obj := object("v1", kind, nil)
+	obj.SetName(name)
+	obj.SetLabels(objLabels)
+	return obj
+}
+
+// treeSummaries returns a Summarize func returning the summaries by name, with the relationships given.
+func treeSummaries(summaries map[string]Summary) func(runtime.Object) Summary {
+	return func(obj runtime.Object) Summary {
+		objMeta, _ := meta.Accessor(obj)
+		return summaries[objMeta.GetName()]
+	}
+}
+
+func uses(kind, name string) Relationship {
+	return Relationship{APIVersion: "v1", Kind: kind, Name: name, Type: "uses"}
+}
+
+func TestBuildTree(t *testing.T) {
+	getter := fakeGetter{
+		"web":      treeObject("App", "web", nil),
+		"web-1":    treeObject("Pod", "web-1", map[string]string{"app": "web"}),
+		"web-2":    treeObject("Pod", "web-2", map[string]string{"app": "web"}),
+		"config":   treeObject("ConfigMap", "config", nil),
+		"other":    treeObject("Pod", "other", map[string]string{"app": "other"}),
+		"web-cert": treeObject("Secret", "web-cert", nil),
+	}
+	summaries := map[string]Summary{
+		"web": {State: "active", Relationships: []Relationship{
+			{APIVersion: "v1", Kind: "Pod", Type: "selects", Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}},
+			uses("ConfigMap", "config"),
+			{APIVersion: "v1", Kind: "Owner", Name: "parent", Type: "owner", Inbound: true},
+		}},
+		"web-1":  {State: "running", Relationships: []Relationship{uses("Secret", "web-cert")}},
+		"web-2":  {State: "running", Relationships: []Relationship{uses("Secret", "missing")}},
+		"config": {State: "active"},
+		"web-cert": {
+			State:         "pending",
+			Transitioning: true,
+			Message:       []string{"waiting for issuer"},
+		},
+	}
+
+	root, err := BuildTree(getter, getter["web"], &TreeOptions{Summarize: treeSummaries(summaries)})
+	require.NoError(t, err)
+
+	assert.Equal(t, "App ns/web", root.String())
+	require.Len(t, root.Children, 3, "the inbound relationship and unselected pod must not be followed")
+	assert.Equal(t, "active", root.Summary.State)
+	assert.True(t, root.Summary.IsReady())
+
+	assert.Equal(t, "error", root.Aggregated.State)
+	assert.True(t, root.Aggregated.Error)
+	assert.True(t, root.Aggregated.Transitioning)
+	assert.ElementsMatch(t, []string{
+		"Pod ns/web-1 -> Secret ns/web-cert: waiting for issuer",
+		"Pod ns/web-2 -> Secret ns/missing: not found",
+	}, root.Aggregated.Message)
+
+	var paths []string
+	for _, path := range root.RootCauses() {
+		var names []string
+		for _, node := range path {
+			names = append(names, node.Name)
+		}
+		paths = append(paths, strings.Join(names, "/"))
+	}
+	assert.ElementsMatch(t, []string{"web/web-1/web-cert", "web/web-2/missing"}, paths)
+
+	for _, child := range root.Children {
+		if child.Name != "web-2" {
+			continue
+		}
+		require.Len(t, child.Children, 1)
+		assert.True(t, child.Children[0].Missing)
+		assert.Equal(t, "missing", child.Children[0].Summary.State)
+		assert.Equal(t, "error", child.Aggregated.State)
+	}
+}
+
+func TestBuildTree_Ready(t *testing.T) {
+	getter := fakeGetter{
+		"web":    treeObject("App", "web", nil),
+		"config": treeObject("ConfigMap", "config", nil),
+	}
+	summaries := map[string]Summary{
+		"web":    {State: "active", Relationships: []Relationship{uses("ConfigMap", "config")}},
+		"config": {State: "active"},
+	}
+
+	root, err := BuildTree(getter, getter["web"], &TreeOptions{Summarize: treeSummaries(summaries)})
+	require.NoError(t, err)
+	assert.True(t, root.Aggregated.IsReady())
+	assert.Equal(t, "active", root.Aggregated.State)
+	assert.Empty(t, root.Aggregated.Message)
+	assert.Empty(t, root.RootCauses())
+}
+
+func TestBuildTree_CyclesAndDepth(t *testing.T) {
+	getter := fakeGetter{
+		"a": treeObject("Thing", "a", nil),
+		"b": treeObject("Thing", "b", nil),
+	}
+	summaries := map[string]Summary{
+		"a": {State: "active", Relationships: []Relationship{uses("Thing", "b")}},
+		"b": {State: "active", Relationships: []Relationship{uses("Thing", "a")}},
+	}
+
+	root, err := BuildTree(getter, getter["a"], &TreeOptions{Summarize: treeSummaries(summaries)})
+	require.NoError(t, err)
+	require.Len(t, root.Children, 1)
+	require.Len(t, root.Children[0].Children, 1)
+	assert.Empty(t, root.Children[0].Children[0].Children, "the cycle back to the root must not be followed")
+
+	root, err = BuildTree(getter, getter["a"], &TreeOptions{Summarize: treeSummaries(summaries), MaxDepth: 1})
+	require.NoError(t, err)
+	require.Len(t, root.Children, 1)
+	assert.Empty(t, root.Children[0].Children)
+}
+
+// failingGetter fails to look up the objects of a kind.
+type failingGetter struct {
+	fakeGetter
+	kind string
+	err  error
+}
+
+func (f failingGetter) Get(gvk schema.GroupVersionKind, namespace, name string) (runtime.Object, error) {
+	if gvk.Kind == f.kind {
+		return nil, f.err
+	}
+	return f.fakeGetter.Get(gvk, namespace, name)
+}
+
+func (f failingGetter) List(gvk schema.GroupVersionKind, namespace string, selector labels.Selector) ([]runtime.Object, error) {
+	if gvk.Kind == f.kind {
+		return nil, f.err
+	}
+	return f.fakeGetter.List(gvk, namespace, selector)
+}
+
+func TestBuildTree_LookupErrors(t *testing.T) {
+	getter := failingGetter{
+		fakeGetter: fakeGetter{
+			"web":    treeObject("App", "web", nil),
+			"config": treeObject("ConfigMap", "config", nil),
+		},
+		kind: "Widget",
+		err:  &meta.NoKindMatchError{GroupKind: schema.GroupKind{Kind: "Widget"}},
+	}
+	summaries := map[string]Summary{
+		"web": {State: "active", Relationships: []Relationship{
+			uses("ConfigMap", "config"),
+			uses("Widget", "gear"),
+			{APIVersion: "v1", Kind: "Widget", Type: "selects", Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}},
+		}},
+		"config": {State: "active"},
+	}
+
+	root, err := BuildTree(getter, getter.fakeGetter["web"], &TreeOptions{Summarize: treeSummaries(summaries)})
+	require.NoError(t, err, "lookup errors should not fail the whole tree")
+	require.Len(t, root.Children, 3)
+	assert.Equal(t, "config", root.Children[0].Name)
+	assert.Empty(t, root.Children[0].Error)
+
+	for _, child := range root.Children[1:] {
+		assert.False(t, child.Missing)
+		assert.Contains(t, child.Error, "no matches for kind")
+		assert.Equal(t, "unknown", child.Summary.State)
+		assert.True(t, child.Summary.Error)
+	}
+	assert.Equal(t, "gear", root.Children[1].Name)
+	assert.Equal(t, "app=web", root.Children[2].Name)
+	assert.True(t, root.Aggregated.Error)
+	assert.Len(t, root.RootCauses(), 2)
+}
@@ -0,0 +1,233 @@
+package informer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rancher/wrangler/v3/pkg/summary"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ChangeType is the type of a Change.
+type ChangeType string
+
+const (
+	// ChangeAdded is the type of the changes of objects added to the cache, with an empty old summary.
+	ChangeAdded ChangeType = "Added"
+	// ChangeUpdated is the type of the changes of the state, error or transitioning flag of an object.
+	ChangeUpdated ChangeType = "Updated"
+	// ChangeDeleted is the type of the changes of objects removed from the cache, with an empty new summary.
+	ChangeDeleted ChangeType = "Deleted"
+)
+
+// Change is a change of the summary of an object.
+type Change struct {
+	Type ChangeType
+	// Key is the namespace/name key of the object.
+	Key string
+	// Object is the object after the change, or the last known state of the object if it was deleted.
+	Object *summary.SummarizedObject
+	Old    summary.Summary
+	New    summary.Summary
+	// Initial is set for the objects added by the initial list of the informer.
+	Initial bool
+	Time    time.Time
+}
+
+// Transition is an entry of the history of the summary of an object.
+type Transition struct {
+	State         string    `json:"state,omitempty"`
+	Error         bool      `json:"error,omitempty"`
+	Transitioning bool      `json:"transitioning,omitempty"`
+	Message       []string  `json:"message,omitempty"`
+	Time          time.Time `json:"time"`
+}
+
+// ChangeWatcherOptions configure a ChangeWatcher.
+type ChangeWatcherOptions struct {
+	// HistorySize is how many transitions are kept per object, the oldest being dropped first. Zero keeps no
+	// history.
+	HistorySize int
+}
+
+// ChangeWatcher emits a Change when the state, error or transitioning flag of an object of a summary informer
+// changes, and keeps the history of these transitions. Changes of messages alone are not emitted.
+type ChangeWatcher struct {
+	historySize int
+	now         func() time.Time
+
+	lock     sync.RWMutex
+	objects  map[string]*objectHistory
+	handlers []changeHandler
+	seq      int
+}
+
+type changeHandler struct {
+	id      int
+	handler func(Change)
+}
+
+type objectHistory struct {
+	since       time.Time
+	transitions []Transition
+}
+
+// NewChangeWatcher returns a ChangeWatcher of the objects of the informer, usually the Informer() of a
+// SummarySharedInformerFactory.ForResource.
+func NewChangeWatcher(informer cache.SharedIndexInformer, opts *ChangeWatcherOptions) (*ChangeWatcher, error) {
+	if opts == nil {
+		opts = &ChangeWatcherOptions{}
+	}
+	w := &ChangeWatcher{
+		historySize: opts.HistorySize,
+		now:         time.Now,
+		objects:     map[string]*objectHistory{},
+	}
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc:    w.onAdd,
+		UpdateFunc: w.onUpdate,
+		DeleteFunc: w.onDelete,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch summary changes: %w", err)
+	}
+	return w, nil
+}
+
+// OnChange calls handler for every change until ctx is done. Handlers are called in the order of the changes, from
+// the goroutine of the informer, and must not block.
+func (w *ChangeWatcher) OnChange(ctx context.Context, handler func(Change)) {
+	w.lock.Lock()
+	w.seq++
+	id := w.seq
+	w.handlers = append(w.handlers, changeHandler{id: id, handler: handler})
+	w.lock.Unlock()
+
+	context.AfterFunc(ctx, func() {
+		w.lock.Lock()
+		defer w.lock.Unlock()
+		for i, h := range w.handlers {
+			if h.id == id {
+				w.handlers = append(w.handlers[:i:i], w.handlers[i+1:]...)
+				break
+			}
+		}
+	})
+}
+
+// History returns the transitions of an object by its namespace/name key, oldest first.
+func (w *ChangeWatcher) History(key string) []Transition {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	if h, ok := w.objects[key]; ok {
+		return append([]Transition(nil), h.transitions...)
+	}
+	return nil
+}
+
+// Since returns when an object entered its current state, or false if the object is not known. For the objects of
+// the initial list of the informer, it is when the watcher first saw them.
+func (w *ChangeWatcher) Since(key string) (time.Time, bool) {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	if h, ok := w.objects[key]; ok {
+		return h.since, true
+	}
+	return time.Time{}, false
+}
+
+// Changed returns whether the state, error or transitioning flag differ between the summaries.
+func Changed(old, new summary.Summary) bool {
+	return old.State != new.State || old.Error != new.Error || old.Transitioning != new.Transitioning
+}
+
+func (w *ChangeWatcher) onAdd(obj interface{}, isInInitialList bool) {
+	newObj, ok := obj.(*summary.SummarizedObject)
+	if !ok {
+		return
+	}
+	w.emit(Change{
+		Type:    ChangeAdded,
+		Object:  newObj,
+		New:     newObj.Summary,
+		Initial: isInInitialList,
+	})
+}
+
+func (w *ChangeWatcher) onUpdate(oldObj, obj interface{}) {
+	old, ok := oldObj.(*summary.SummarizedObject)
+	if !ok {
+		return
+	}
+	newObj, ok := obj.(*summary.SummarizedObject)
+	if !ok || !Changed(old.Summary, newObj.Summary) {
+		return
+	}
+	w.emit(Change{
+		Type:   ChangeUpdated,
+		Object: newObj,
+		Old:    old.Summary,
+		New:    newObj.Summary,
+	})
+}
+
+func (w *ChangeWatcher) onDelete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	oldObj, ok := obj.(*summary.SummarizedObject)
+	if !ok {
+		return
+	}
+	w.emit(Change{
+		Type:   ChangeDeleted,
+		Object: oldObj,
+		Old:    oldObj.Summary,
+	})
+}
+
+func (w *ChangeWatcher) emit(change Change) {
+	key, err := cache.MetaNamespaceKeyFunc(change.Object)
+	if err != nil {
+		return
+	}
+	change.Key = key
+	change.Time = w.now()
+
+	w.lock.Lock()
+	if change.Type == ChangeDeleted {
+		delete(w.objects, key)
+	} else {
+		w.record(change)
+	}
+	handlers := w.handlers
+	w.lock.Unlock()
+
+	for _, h := range handlers {
+		h.handler(change)
+	}
+}
+
+func (w *ChangeWatcher) record(change Change) {
+	h, ok := w.objects[change.Key]
+	if !ok {
+		h = &objectHistory{}
+		w.objects[change.Key] = h
+	}
+	h.since = change.Time
+	if w.historySize <= 0 {
+		return
+	}
+	h.transitions = append(h.transitions, Transition{
+		State:         change.New.State,
+		Error:         change.New.Error,
+		Transitioning: change.New.Transitioning,
+		Message:       change.New.Message,
+		Time:          change.Time,
+	})
+	if extra := len(h.transitions) - w.historySize; extra > 0 {
+		h.transitions = append([]Transition(nil), h.transitions[extra:]...)
+	}
+}
@@ -0,0 +1,118 @@
+package informer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rancher/wrangler/v3/pkg/summary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+func summarized(name, resourceVersion string, s summary.Summary) *summary.SummarizedObject {
+	obj := &summary.SummarizedObject{Summary: s}
+	obj.Namespace = "ns"
+	obj.Name = name
+	obj.ResourceVersion = resourceVersion
+	return obj
+}
+
+func TestChangeWatcher(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fakeWatch := watch.NewFake()
+	informer := cache.NewSharedIndexInformer(&listWatcherWithWatchListSemanticsWrapper{ListWatch: &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return &summary.SummarizedObjectList{
+				ListMeta: metav1.ListMeta{ResourceVersion: "1"},
+				Items:    []summary.SummarizedObject{*summarized("a", "1", summary.Summary{State: "active"})},
+			}, nil
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return fakeWatch, nil
+		},
+	}, unsupportedWatchListSemantics: true}, &summary.SummarizedObject{}, 0, cache.Indexers{})
+
+	watcher, err := NewChangeWatcher(informer, &ChangeWatcherOptions{HistorySize: 2})
+	require.NoError(t, err)
+	changes := make(chan Change, 10)
+	watcher.OnChange(ctx, func(change Change) {
+		changes <- change
+	})
+
+	go informer.Run(ctx.Done())
+	next := func() Change {
+		select {
+		case change := <-changes:
+			return change
+		case <-time.After(5 * time.Second):
+			t.Fatal("no change emitted")
+			return Change{}
+		}
+	}
+
+	change := next()
+	assert.Equal(t, ChangeAdded, change.Type)
+	assert.Equal(t, "ns/a", change.Key)
+	assert.True(t, change.Initial)
+	assert.Equal(t, "active", change.New.State)
+
+	// message only changes are not emitted
+	fakeWatch.Modify(summarized("a", "2", summary.Summary{State: "active", Message: []string{"ok"}}))
+	fakeWatch.Modify(summarized("a", "3", summary.Summary{State: "updating", Transitioning: true}))
+	change = next()
+	assert.Equal(t, ChangeUpdated, change.Type)
+	assert.Equal(t, "active", change.Old.State)
+	assert.Equal(t, "updating", change.New.State)
+	assert.True(t, change.New.Transitioning)
+	since, ok := watcher.Since("ns/a")
+	assert.True(t, ok)
+	assert.Equal(t, change.Time, since)
+
+	fakeWatch.Modify(summarized("a", "4", summary.Summary{State: "error", Error: true}))
+	change = next()
+	assert.Equal(t, "error", change.New.State)
+
+	history := watcher.History("ns/a")
+	require.Len(t, history, 2, "the history must be bounded")
+	assert.Equal(t, "updating", history[0].State)
+	assert.Equal(t, "error", history[1].State)
+	assert.True(t, history[1].Error)
+
+	fakeWatch.Delete(summarized("a", "5", summary.Summary{State: "error", Error: true}))
+	change = next()
+	assert.Equal(t, ChangeDeleted, change.Type)
+	assert.Equal(t, "error", change.Old.State)
+	assert.Empty(t, watcher.History("ns/a"))
+	_, ok = watcher.Since("ns/a")
+	assert.False(t, ok)
+}
+
+func TestChangeWatcher_OnChangeStops(t *testing.T) {
+	watcher, err := NewChangeWatcher(cache.NewSharedIndexInformer(&cache.ListWatch{}, &summary.SummarizedObject{}, 0, cache.Indexers{}), nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var called int
+	watcher.OnChange(ctx, func(Change) {
+		called++
+	})
+	watcher.onAdd(summarized("a", "1", summary.Summary{State: "active"}), false)
+	assert.Equal(t, 1, called)
+	assert.Empty(t, watcher.History("ns/a"), "no history is kept by default")
+
+	cancel()
+	require.Eventually(t, func() bool {
+		watcher.lock.RLock()
+		defer watcher.lock.RUnlock()
+		return len(watcher.handlers) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	watcher.onUpdate(summarized("a", "1", summary.Summary{State: "active"}), summarized("a", "2", summary.Summary{State: "removing"}))
+	assert.Equal(t, 1, called)
+}
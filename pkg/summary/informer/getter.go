@@ -0,0 +1,100 @@
+package informer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rancher/wrangler/v3/pkg/summary"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+)
+
+// cacheSyncTimeout is how long a lookup waits for the cache of a resource to sync. Caches of resources that can't
+// be listed, for example because it is forbidden, never sync.
+const cacheSyncTimeout = 10 * time.Second
+
+// NewObjectGetter returns a summary.ObjectGetter reading the summarized objects of the informers of the factory,
+// mapping kinds to resources with the mapper. Informers created on first use are started and synced until ctx is
+// done. Lookups of a resource whose cache did not sync in time fail right away until it does.
+func NewObjectGetter(ctx context.Context, factory SummarySharedInformerFactory, mapper meta.RESTMapper) summary.ObjectGetter {
+	return &objectGetter{
+		ctx:      ctx,
+		factory:  factory,
+		mapper:   mapper,
+		unsynced: map[schema.GroupVersionResource]bool{},
+	}
+}
+
+type objectGetter struct {
+	ctx     context.Context
+	factory SummarySharedInformerFactory
+	mapper  meta.RESTMapper
+
+	lock sync.Mutex
+	// unsynced are the resources whose cache did not sync in time.
+	unsynced map[schema.GroupVersionResource]bool
+}
+
+func (o *objectGetter) lister(gvk schema.GroupVersionKind) (cache.GenericLister, bool, error) {
+	mapping, err := o.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, false, err
+	}
+	informer := o.factory.ForResource(mapping.Resource)
+	o.factory.Start(o.ctx.Done())
+	if !o.waitForCacheSync(mapping.Resource, informer.Informer().HasSynced) {
+		return nil, false, fmt.Errorf("cache of %s is not synced", mapping.Resource)
+	}
+	return informer.Lister(), mapping.Scope.Name() == meta.RESTScopeNameNamespace, nil
+}
+
+// waitForCacheSync returns whether the cache of the resource is synced, waiting up to cacheSyncTimeout unless it
+// did not sync in time before.
+func (o *objectGetter) waitForCacheSync(gvr schema.GroupVersionResource, hasSynced cache.InformerSynced) bool {
+	if hasSynced() {
+		return true
+	}
+	o.lock.Lock()
+	unsynced := o.unsynced[gvr]
+	o.lock.Unlock()
+	if unsynced {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(o.ctx, cacheSyncTimeout)
+	defer cancel()
+	if cache.WaitForCacheSync(ctx.Done(), hasSynced) {
+		return true
+	}
+	o.lock.Lock()
+	o.unsynced[gvr] = true
+	o.lock.Unlock()
+	return false
+}
+
+func (o *objectGetter) Get(gvk schema.GroupVersionKind, namespace, name string) (runtime.Object, error) {
+	lister, namespaced, err := o.lister(gvk)
+	if err != nil {
+		return nil, err
+	}
+	if namespaced {
+		return lister.ByNamespace(namespace).Get(name)
+	}
+	return lister.Get(name)
+}
+
+func (o *objectGetter) List(gvk schema.GroupVersionKind, namespace string, selector labels.Selector) ([]runtime.Object, error) {
+	lister, namespaced, err := o.lister(gvk)
+	if err != nil {
+		return nil, err
+	}
+	if namespaced {
+		return lister.ByNamespace(namespace).List(selector)
+	}
+	return lister.List(selector)
+}
@@ -0,0 +1,42 @@
+package summary
+
+import (
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	kstatus "sigs.k8s.io/cli-utils/pkg/kstatus/status"
+)
+
+// kstatusErrorConditions are the conditions of the kstatus conventions, set by the kstatus package and GitOps
+// tools, that are errors for every kind, in addition to those of GVKConditionErrorMapping. Reconciling is a
+// transitioning condition of TransitioningTrue.
+var kstatusErrorConditions = map[string]sets.Set[metav1.ConditionStatus]{
+	"Stalled": sets.New[metav1.ConditionStatus](metav1.ConditionTrue),
+}
+
+// KStatus returns the summary in the vocabulary of kstatus, with the messages of the summary:
+//
+//   - NotFound for the missing objects of a tree built by BuildTree
+//   - Failed for errors
+//   - Terminating for objects being removed
+//   - InProgress for transitioning objects
+//   - Current otherwise
+func (s Summary) KStatus() *kstatus.Result {
+	result := &kstatus.Result{
+		Message: strings.Join(s.Message, ", "),
+	}
+	switch {
+	case s.State == "missing" && s.Error:
+		result.Status = kstatus.NotFoundStatus
+	case s.Error:
+		result.Status = kstatus.FailedStatus
+	case s.State == "removing" || s.State == "deleting":
+		result.Status = kstatus.TerminatingStatus
+	case s.Transitioning:
+		result.Status = kstatus.InProgressStatus
+	default:
+		result.Status = kstatus.CurrentStatus
+	}
+	return result
+}
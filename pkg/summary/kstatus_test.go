@@ -0,0 +1,72 @@
+package summary
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	kstatusstatus "sigs.k8s.io/cli-utils/pkg/kstatus/status"
+)
+
+func TestSummary_KStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		summary Summary
+		want    kstatusstatus.Status
+	}{
+		{"active", Summary{State: "active"}, kstatusstatus.CurrentStatus},
+		{"transitioning", Summary{State: "updating", Transitioning: true}, kstatusstatus.InProgressStatus},
+		{"error", Summary{State: "error", Error: true, Transitioning: true}, kstatusstatus.FailedStatus},
+		{"removing", Summary{State: "removing", Transitioning: true}, kstatusstatus.TerminatingStatus},
+		{"missing", Summary{State: "missing", Error: true, Message: []string{"not found"}}, kstatusstatus.NotFoundStatus},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.summary.KStatus().Status)
+		})
+	}
+
+	result := Summary{State: "error", Error: true, Message: []string{"a", "b"}}.KStatus()
+	assert.Equal(t, "a, b", result.Message)
+}
+
+func TestSummarize_KStatusOption(t *testing.T) {
+	obj := object("apps/v1", "Deployment", map[string]interface{}{"conditions": []interface{}{
+		condition("Available", "False", "MinimumReplicasUnavailable", "not enough replicas"),
+	}})
+	plain := SummarizeWithOptions(obj, nil)
+	summary := SummarizeWithOptions(obj, &SummarizeOptions{KStatus: true})
+
+	assert.Equal(t, "InProgress", summary.State)
+	assert.Equal(t, plain.Message, summary.Message)
+	assert.Equal(t, plain.Transitioning, summary.Transitioning)
+}
+
+func TestSummarize_KStatusConditions(t *testing.T) {
+	// Deployments have their own error conditions, the kstatus ones apply too
+	stalled := object("apps/v1", "Deployment", map[string]interface{}{"conditions": []interface{}{
+		condition("Reconciling", "False", "", ""),
+		condition("Stalled", "True", "Stalled", "image cannot be pulled"),
+	}})
+	summary := Summarize(stalled)
+	assert.True(t, summary.Error)
+	assert.Equal(t, "error", summary.State)
+	assert.Contains(t, summary.Message, "image cannot be pulled")
+	assert.Equal(t, kstatusstatus.FailedStatus, summary.KStatus().Status)
+
+	reconciling := object("apps/v1", "Deployment", map[string]interface{}{"conditions": []interface{}{
+		condition("Reconciling", "True", "Reconciling", "rolling out"),
+		condition("Stalled", "False", "", ""),
+	}})
+	summary = Summarize(reconciling)
+	assert.False(t, summary.Error)
+	assert.True(t, summary.Transitioning)
+	assert.Equal(t, "reconciling", summary.State)
+	assert.Equal(t, kstatusstatus.InProgressStatus, summary.KStatus().Status)
+
+	current := widget(map[string]interface{}{"conditions": []interface{}{
+		condition("Reconciling", "False", "", ""),
+		condition("Stalled", "False", "", ""),
+	}})
+	summary = SummarizeWithOptions(current, &SummarizeOptions{KStatus: true})
+	assert.Equal(t, "Current", summary.State)
+}
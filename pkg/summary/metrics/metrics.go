@@ -0,0 +1,170 @@
+// Package metrics exports the summaries of the objects of summary informers as Prometheus metrics.
+package metrics
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rancher/wrangler/v3/pkg/summary"
+	"github.com/rancher/wrangler/v3/pkg/summary/informer"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	summarySubsystem = "wrangler_summary"
+
+	groupLabel         = "group"
+	versionLabel       = "version"
+	kindLabel          = "kind"
+	namespaceLabel     = "namespace"
+	nameLabel          = "name"
+	stateLabel         = "state"
+	errorLabel         = "error"
+	transitioningLabel = "transitioning"
+)
+
+var (
+	objects = prometheus.NewDesc(
+		prometheus.BuildFQName("", summarySubsystem, "objects"),
+		"Number of objects per GVK, namespace, state, error and transitioning flag",
+		[]string{groupLabel, versionLabel, kindLabel, namespaceLabel, stateLabel, errorLabel, transitioningLabel}, nil,
+	)
+
+	objectInfo = prometheus.NewDesc(
+		prometheus.BuildFQName("", summarySubsystem, "object_info"),
+		"Summary of an object, always 1",
+		[]string{groupLabel, versionLabel, kindLabel, namespaceLabel, nameLabel, stateLabel, errorLabel, transitioningLabel}, nil,
+	)
+
+	objectInfoDropped = prometheus.NewDesc(
+		prometheus.BuildFQName("", summarySubsystem, "object_info_dropped"),
+		"Number of objects without an object_info metric because of the limit of the exporter",
+		nil, nil,
+	)
+)
+
+// Options configure an Exporter.
+type Options struct {
+	// ObjectInfoLimit is the maximum number of object_info metrics, one per object, exported per scrape. Zero
+	// disables them, as their cardinality grows with the number of objects.
+	ObjectInfoLimit int
+}
+
+// Exporter is a prometheus.Collector exporting the summaries of the objects of a list of resources, read from the
+// caches of a SummarySharedInformerFactory when scraped:
+//
+//   - wrangler_summary_objects counts the objects per GVK, namespace, state, error and transitioning flag.
+//   - wrangler_summary_object_info is 1 per object with its summary, up to Options.ObjectInfoLimit objects.
+//   - wrangler_summary_object_info_dropped counts the objects over the limit.
+//
+// The state is the state of the summary as is, empty for objects without one, and the error and transitioning
+// labels are "true" or "false". Resources whose caches are not synced are skipped.
+type Exporter struct {
+	informers []gvrInformer
+	opts      Options
+}
+
+type gvrInformer struct {
+	gvr      schema.GroupVersionResource
+	informer cache.SharedIndexInformer
+}
+
+// NewExporter returns an Exporter of the resources. It creates their informers in the factory, which must be
+// started by the caller.
+func NewExporter(factory informer.SummarySharedInformerFactory, gvrs []schema.GroupVersionResource, opts *Options) *Exporter {
+	e := &Exporter{}
+	if opts != nil {
+		e.opts = *opts
+	}
+	for _, gvr := range gvrs {
+		e.informers = append(e.informers, gvrInformer{
+			gvr:      gvr,
+			informer: factory.ForResource(gvr).Informer(),
+		})
+	}
+	return e
+}
+
+var _ prometheus.Collector = (*Exporter)(nil)
+
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- objects
+	if e.opts.ObjectInfoLimit > 0 {
+		ch <- objectInfo
+		ch <- objectInfoDropped
+	}
+}
+
+type countKey struct {
+	gvk           schema.GroupVersionKind
+	namespace     string
+	state         string
+	error         bool
+	transitioning bool
+}
+
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	infos, dropped := 0, 0
+	for _, i := range e.informers {
+		if !i.informer.HasSynced() {
+			continue
+		}
+
+		keys := i.informer.GetStore().ListKeys()
+		sort.Strings(keys)
+
+		counts := map[countKey]int{}
+		for _, key := range keys {
+			item, exists, err := i.informer.GetStore().GetByKey(key)
+			if err != nil || !exists {
+				continue
+			}
+			obj, ok := item.(*summary.SummarizedObject)
+			if !ok {
+				continue
+			}
+
+			gvk := objectGVK(i.gvr, obj)
+			counts[countKey{
+				gvk:           gvk,
+				namespace:     obj.Namespace,
+				state:         obj.State,
+				error:         obj.Error,
+				transitioning: obj.Transitioning,
+			}]++
+
+			if e.opts.ObjectInfoLimit <= 0 {
+				continue
+			}
+			if infos >= e.opts.ObjectInfoLimit {
+				dropped++
+				continue
+			}
+			infos++
+			ch <- prometheus.MustNewConstMetric(objectInfo, prometheus.GaugeValue, 1,
+				gvk.Group, gvk.Version, gvk.Kind, obj.Namespace, obj.Name, obj.State,
+				strconv.FormatBool(obj.Error), strconv.FormatBool(obj.Transitioning))
+		}
+
+		for key, count := range counts {
+			ch <- prometheus.MustNewConstMetric(objects, prometheus.GaugeValue, float64(count),
+				key.gvk.Group, key.gvk.Version, key.gvk.Kind, key.namespace, key.state,
+				strconv.FormatBool(key.error), strconv.FormatBool(key.transitioning))
+		}
+	}
+
+	if e.opts.ObjectInfoLimit > 0 {
+		ch <- prometheus.MustNewConstMetric(objectInfoDropped, prometheus.GaugeValue, float64(dropped))
+	}
+}
+
+// objectGVK returns the GVK of the object, falling back to the group and version of its resource, with the resource
+// as kind, for objects without type information.
+func objectGVK(gvr schema.GroupVersionResource, obj *summary.SummarizedObject) schema.GroupVersionKind {
+	if gvk := obj.GroupVersionKind(); gvk.Kind != "" {
+		return gvk
+	}
+	return gvr.GroupVersion().WithKind(gvr.Resource)
+}
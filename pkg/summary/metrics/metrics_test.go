@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rancher/wrangler/v3/pkg/summary"
+	"github.com/rancher/wrangler/v3/pkg/summary/client"
+	"github.com/rancher/wrangler/v3/pkg/summary/informer"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+var deployments = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+
+// fakeClient lists the same objects for every resource and namespace.
+type fakeClient struct {
+	items []summary.SummarizedObject
+}
+
+func (f *fakeClient) Resource(schema.GroupVersionResource) client.NamespaceableResourceInterface {
+	return f
+}
+
+func (f *fakeClient) Namespace(string) client.ResourceInterface {
+	return f
+}
+
+func (f *fakeClient) List(context.Context, metav1.ListOptions) (*summary.SummarizedObjectList, error) {
+	return &summary.SummarizedObjectList{
+		ListMeta: metav1.ListMeta{ResourceVersion: "1"},
+		Items:    f.items,
+	}, nil
+}
+
+func (f *fakeClient) Watch(context.Context, metav1.ListOptions) (watch.Interface, error) {
+	return watch.NewFake(), nil
+}
+
+func (f *fakeClient) IsWatchListSemanticsUnSupported() bool {
+	return true
+}
+
+func deployment(namespace, name string, s summary.Summary) summary.SummarizedObject {
+	obj := summary.SummarizedObject{Summary: s}
+	obj.APIVersion = "apps/v1"
+	obj.Kind = "Deployment"
+	obj.Namespace = namespace
+	obj.Name = name
+	return obj
+}
+
+func startExporter(t *testing.T, opts *Options) *Exporter {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	factory := informer.NewSummarySharedInformerFactory(&fakeClient{items: []summary.SummarizedObject{
+		deployment("a", "web", summary.Summary{State: "active"}),
+		deployment("a", "api", summary.Summary{State: "active"}),
+		deployment("a", "worker", summary.Summary{State: "updating", Transitioning: true}),
+		deployment("b", "db", summary.Summary{State: "error", Error: true}),
+	}}, 0)
+	exporter := NewExporter(factory, []schema.GroupVersionResource{deployments}, opts)
+	factory.Start(ctx.Done())
+	for gvr, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		require.True(t, synced, "cache of %s not synced", gvr)
+	}
+	return exporter
+}
+
+func TestExporter(t *testing.T) {
+	exporter := startExporter(t, nil)
+
+	err := testutil.CollectAndCompare(exporter, strings.NewReader(`
+# HELP wrangler_summary_objects Number of objects per GVK, namespace, state, error and transitioning flag
+# TYPE wrangler_summary_objects gauge
+wrangler_summary_objects{error="false",group="apps",kind="Deployment",namespace="a",state="active",transitioning="false",version="v1"} 2
+wrangler_summary_objects{error="false",group="apps",kind="Deployment",namespace="a",state="updating",transitioning="true",version="v1"} 1
+wrangler_summary_objects{error="true",group="apps",kind="Deployment",namespace="b",state="error",transitioning="false",version="v1"} 1
+`))
+	require.NoError(t, err)
+}
+
+func TestExporter_ObjectInfoLimit(t *testing.T) {
+	exporter := startExporter(t, &Options{ObjectInfoLimit: 3})
+
+	err := testutil.CollectAndCompare(exporter, strings.NewReader(`
+# HELP wrangler_summary_object_info Summary of an object, always 1
+# TYPE wrangler_summary_object_info gauge
+wrangler_summary_object_info{error="false",group="apps",kind="Deployment",name="api",namespace="a",state="active",transitioning="false",version="v1"} 1
+wrangler_summary_object_info{error="false",group="apps",kind="Deployment",name="web",namespace="a",state="active",transitioning="false",version="v1"} 1
+wrangler_summary_object_info{error="false",group="apps",kind="Deployment",name="worker",namespace="a",state="updating",transitioning="true",version="v1"} 1
+# HELP wrangler_summary_object_info_dropped Number of objects without an object_info metric because of the limit of the exporter
+# TYPE wrangler_summary_object_info_dropped gauge
+wrangler_summary_object_info_dropped 1
+`), "wrangler_summary_object_info", "wrangler_summary_object_info_dropped")
+	require.NoError(t, err)
+}
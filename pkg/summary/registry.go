@@ -0,0 +1,248 @@
+package summary
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/rancher/wrangler/v3/pkg/data"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Priorities of the built-in summarizers of a Registry. The built-in summarizers run at priorities 100 to 1700 in
+// steps of 100, in the order of builtinSummarizers. Summarizers registered below 100 run before all of them, and
+// above 1700 after all of them.
+const (
+	// PriorityErrors is the priority of the summarizer of error conditions.
+	PriorityErrors = 200
+	// PriorityTransitioning is the priority of the summarizer of transitioning conditions. The CAPI and operation
+	// kinds have their own summarizers at this priority, replacing the generic one.
+	PriorityTransitioning = 300
+	// PriorityRules is the priority of the summarizer applying the Rules of the Registry.
+	PriorityRules = 400
+	// PriorityEvents is a priority after all the built-in summarizers, for summarizers adding to the summary
+	// computed from the object, such as the one of the events package.
+	PriorityEvents = 1800
+)
+
+// Registry summarizes objects with the summarizers registered for their GVK. Summarizers are registered per GVK with
+// a priority, and registrations are scoped to the Registry, so libraries in the same binary can each have their own.
+// The package level Summarize uses the Registry returned by DefaultRegistry.
+//
+// For each priority, in increasing order, an object is summarized by the summarizers registered at that priority
+// for its group, version and kind. If there are none, those registered for its group and kind with an empty
+// version are used, and then the generic ones registered with an empty GVK. Summarizers registered for the same
+// GVK and priority run in registration order.
+type Registry struct {
+	lock                 sync.RWMutex
+	seq                  int
+	summarizers          map[schema.GroupVersionKind][]registration
+	conditionSummarizers map[schema.GroupVersionKind][]registration
+	rules                *ruleSources
+}
+
+type registration struct {
+	summarizer Summarizer
+	priority   int
+	seq        int
+}
+
+// defaultRegistry is the Registry of the package level Summarize and NormalizeConditions, applying the package level
+// rules.
+var defaultRegistry = newRegistry(rules)
+
+// DefaultRegistry returns the Registry used by the package level Summarize, SummarizeWithOptions and
+// NormalizeConditions. Summarizers registered with it apply to every user of those functions in the binary.
+func DefaultRegistry() *Registry {
+	return defaultRegistry
+}
+
+// NewRegistry returns a Registry with the built-in summarizers registered.
+func NewRegistry() *Registry {
+	return newRegistry(newRuleSources())
+}
+
+// builtinSummarizers returns the built-in summarizers of all objects, in the order they run, applying the given
+// rules.
+func builtinSummarizers(rules *ruleSources) []Summarizer {
+	return []Summarizer{
+		checkStatusSummary,
+		checkErrors,
+		checkGenericTransitioning,
+		rules.check,
+		checkActive,
+		checkPhase,
+		checkInitializing,
+		checkRemoving,
+		checkStandard,
+		checkLoadBalancer,
+		checkPod,
+		checkHasPodSelector,
+		checkHasPodTemplate,
+		checkOwner,
+		checkApplyOwned,
+		checkCattleTypes,
+		checkGeneration,
+	}
+}
+
+// builtinConditionSummarizers returns the built-in condition summarizers of all objects, in the order they run.
+func builtinConditionSummarizers() []Summarizer {
+	return []Summarizer{
+		checkErrors,
+		checkGenericTransitioning,
+		checkRemoving,
+		checkCattleReady,
+	}
+}
+
+func newRegistry(rules *ruleSources) *Registry {
+	r := &Registry{
+		summarizers:          map[schema.GroupVersionKind][]registration{},
+		conditionSummarizers: map[schema.GroupVersionKind][]registration{},
+		rules:                rules,
+	}
+
+	generic := schema.GroupVersionKind{}
+	for i, summarizer := range builtinSummarizers(rules) {
+		r.RegisterSummarizer(generic, summarizer, (i+1)*100)
+	}
+
+	capi := func(kind string) schema.GroupVersionKind {
+		return schema.GroupVersionKind{Group: "cluster.x-k8s.io", Kind: kind}
+	}
+	r.RegisterSummarizer(capi("Machine"), func(_ data.Object, conditions []Condition, summary Summary) Summary {
+		return checkCAPIMachineTransitioning(conditions, summary)
+	}, PriorityTransitioning)
+	r.RegisterSummarizer(capi("MachineSet"), checkCAPIMachineSetAndDeploymentTransitioning, PriorityTransitioning)
+	r.RegisterSummarizer(capi("MachineDeployment"), checkCAPIMachineSetAndDeploymentTransitioning, PriorityTransitioning)
+	r.RegisterSummarizer(capi("Cluster"), checkCAPIClusterTransitioning, PriorityTransitioning)
+	for kind := range operationKinds {
+		r.RegisterSummarizer(schema.GroupVersionKind{Group: "operation.cattle.io", Kind: kind},
+			func(_ data.Object, conditions []Condition, summary Summary) Summary {
+				return checkOperationTransitioning(conditions, summary)
+			}, PriorityTransitioning)
+	}
+
+	for i, summarizer := range builtinConditionSummarizers() {
+		r.RegisterConditionSummarizer(generic, summarizer, (i+1)*100)
+	}
+
+	return r
+}
+
+// RegisterSummarizer registers a summarizer of the objects of a GVK at a priority. An empty version registers it
+// for all versions of the group and kind, and an empty GVK for all objects.
+func (r *Registry) RegisterSummarizer(gvk schema.GroupVersionKind, summarizer Summarizer, priority int) {
+	r.register(r.summarizers, gvk, summarizer, priority)
+}
+
+// RegisterConditionSummarizer registers a summarizer used by NormalizeConditions to set the error and
+// transitioning fields of each condition of the objects of a GVK, the same way as RegisterSummarizer.
+func (r *Registry) RegisterConditionSummarizer(gvk schema.GroupVersionKind, summarizer Summarizer, priority int) {
+	r.register(r.conditionSummarizers, gvk, summarizer, priority)
+}
+
+func (r *Registry) register(registrations map[schema.GroupVersionKind][]registration, gvk schema.GroupVersionKind, summarizer Summarizer, priority int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.seq++
+	registrations[gvk] = append(registrations[gvk], registration{
+		summarizer: summarizer,
+		priority:   priority,
+		seq:        r.seq,
+	})
+}
+
+// SetRules replaces the rules of a source in the Registry, the same way as the package level SetRules.
+func (r *Registry) SetRules(source string, rules []Rule) {
+	r.rules.set(source, rules)
+}
+
+// SummarizersFor returns the summarizers of the objects of a GVK, in the order they run.
+func (r *Registry) SummarizersFor(gvk schema.GroupVersionKind) []Summarizer {
+	return r.lookup(r.summarizers, gvk)
+}
+
+// ConditionSummarizersFor returns the condition summarizers of the objects of a GVK, in the order they run.
+func (r *Registry) ConditionSummarizersFor(gvk schema.GroupVersionKind) []Summarizer {
+	return r.lookup(r.conditionSummarizers, gvk)
+}
+
+// summarizersAt returns the summarizers of the objects of a GVK at a priority, in the order they run.
+func (r *Registry) summarizersAt(gvk schema.GroupVersionKind, priority int) []Summarizer {
+	var result []Summarizer
+	for _, reg := range r.selected(r.summarizers, gvk) {
+		if reg.priority == priority {
+			result = append(result, reg.summarizer)
+		}
+	}
+	return result
+}
+
+func (r *Registry) lookup(registrations map[schema.GroupVersionKind][]registration, gvk schema.GroupVersionKind) []Summarizer {
+	selected := r.selected(registrations, gvk)
+	result := make([]Summarizer, 0, len(selected))
+	for _, reg := range selected {
+		result = append(result, reg.summarizer)
+	}
+	return result
+}
+
+// selected returns the registrations that apply to the objects of a GVK, in the order they run.
+func (r *Registry) selected(registrations map[schema.GroupVersionKind][]registration, gvk schema.GroupVersionKind) []registration {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	// from the most to the least specific
+	var levels [][]registration
+	if gvk.Kind != "" {
+		levels = append(levels, registrations[gvk])
+		if gvk.Version != "" {
+			levels = append(levels, registrations[schema.GroupVersionKind{Group: gvk.Group, Kind: gvk.Kind}])
+		}
+	}
+	levels = append(levels, registrations[schema.GroupVersionKind{}])
+
+	byPriority := map[int][]registration{}
+	for _, level := range levels {
+		found := map[int]bool{}
+		for _, reg := range level {
+			if _, ok := byPriority[reg.priority]; ok && !found[reg.priority] {
+				// a more specific level has summarizers at this priority
+				continue
+			}
+			found[reg.priority] = true
+			byPriority[reg.priority] = append(byPriority[reg.priority], reg)
+		}
+	}
+
+	var selected []registration
+	for _, regs := range byPriority {
+		selected = append(selected, regs...)
+	}
+	sort.Slice(selected, func(i, j int) bool {
+		if selected[i].priority != selected[j].priority {
+			return selected[i].priority < selected[j].priority
+		}
+		return selected[i].seq < selected[j].seq
+	})
+	return selected
+}
+
+// Summarize summarizes the object with the summarizers of the Registry.
+func (r *Registry) Summarize(runtimeObj runtime.Object) Summary {
+	return r.SummarizeWithOptions(runtimeObj, nil)
+}
+
+// SummarizeWithOptions summarizes the object with the summarizers of the Registry.
+func (r *Registry) SummarizeWithOptions(runtimeObj runtime.Object, opts *SummarizeOptions) Summary {
+	return summarizeWith(runtimeObj, opts, r.SummarizersFor)
+}
+
+// NormalizeConditions sets the error and transitioning fields of the conditions of the object with the condition
+// summarizers of the Registry.
+func (r *Registry) NormalizeConditions(runtimeObj runtime.Object) {
+	normalizeConditionsWith(runtimeObj, r.ConditionSummarizersFor)
+}
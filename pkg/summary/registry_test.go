@@ -0,0 +1,138 @@
+package summary
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/rancher/wrangler/v3/pkg/data"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func object(apiVersion, kind string, status map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       kind,
+		"metadata":   map[string]interface{}{"name": "obj", "namespace": "ns"},
+		"status":     status,
+	}}
+}
+
+func TestRegistry_BuiltinsMatchSummarize(t *testing.T) {
+	registry := NewRegistry()
+	objects := []*unstructured.Unstructured{
+		object("cluster.x-k8s.io/v1beta2", "Machine", map[string]interface{}{"conditions": []interface{}{
+			condition("Deleting", "True", "Drain", "Drain not completed yet"),
+		}}),
+		object("cluster.x-k8s.io/v1beta2", "MachineDeployment", map[string]interface{}{"conditions": []interface{}{
+			condition("ScalingUp", "True", "ScalingUp", "Scaling up from 1 to 3 replicas"),
+		}}),
+		object("cluster.x-k8s.io/v1beta2", "Cluster", map[string]interface{}{"conditions": []interface{}{
+			condition("Paused", "True", "Paused", ""),
+		}}),
+		object("operation.cattle.io/v1", "ETCDSnapshotSave", map[string]interface{}{"conditions": []interface{}{
+			condition("Failed", "True", "Error", "snapshot failed"),
+		}}),
+		object("apps/v1", "Deployment", map[string]interface{}{"conditions": []interface{}{
+			condition("Available", "False", "MinimumReplicasUnavailable", "not enough replicas"),
+		}}),
+		object("v1", "Pod", map[string]interface{}{"phase": "Pending"}),
+	}
+
+	for _, obj := range objects {
+		assert.Equal(t, Summarize(obj), registry.Summarize(obj), obj.GroupVersionKind().String())
+
+		expected, actual := obj.DeepCopy(), obj.DeepCopy()
+		NormalizeConditions(expected)
+		registry.NormalizeConditions(actual)
+		assert.Equal(t, expected, actual, obj.GroupVersionKind().String())
+	}
+}
+
+func TestRegistry_Lookup(t *testing.T) {
+	registry := &Registry{
+		summarizers:          map[schema.GroupVersionKind][]registration{},
+		conditionSummarizers: map[schema.GroupVersionKind][]registration{},
+		rules:                newRuleSources(),
+	}
+	var calls []string
+	record := func(name string) Summarizer {
+		return func(_ data.Object, _ []Condition, summary Summary) Summary {
+			calls = append(calls, name)
+			return summary
+		}
+	}
+
+	widgetV1 := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"}
+	widget := schema.GroupVersionKind{Group: "example.com", Kind: "Widget"}
+	registry.RegisterSummarizer(schema.GroupVersionKind{}, record("generic-20"), 20)
+	registry.RegisterSummarizer(schema.GroupVersionKind{}, record("generic-10"), 10)
+	registry.RegisterSummarizer(schema.GroupVersionKind{}, record("generic-30"), 30)
+	registry.RegisterSummarizer(widget, record("widget-20"), 20)
+	registry.RegisterSummarizer(widgetV1, record("widget-v1-30"), 30)
+	registry.RegisterSummarizer(widgetV1, record("widget-v1-30-second"), 30)
+
+	run := func(gvk schema.GroupVersionKind) []string {
+		calls = nil
+		for _, summarizer := range registry.SummarizersFor(gvk) {
+			summarizer(nil, nil, Summary{})
+		}
+		return calls
+	}
+
+	assert.Equal(t, []string{"generic-10", "widget-20", "widget-v1-30", "widget-v1-30-second"}, run(widgetV1))
+	assert.Equal(t, []string{"generic-10", "widget-20", "generic-30"},
+		run(schema.GroupVersionKind{Group: "example.com", Version: "v2", Kind: "Widget"}))
+	assert.Equal(t, []string{"generic-10", "generic-20", "generic-30"},
+		run(schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Gadget"}))
+}
+
+func TestRegistry_Scoped(t *testing.T) {
+	first, second := NewRegistry(), NewRegistry()
+	first.RegisterSummarizer(schema.GroupVersionKind{Group: "example.com", Kind: "Widget"},
+		func(_ data.Object, _ []Condition, summary Summary) Summary {
+			summary.State = "spinning"
+			return summary
+		}, PriorityTransitioning)
+	first.SetRules("test", []Rule{{GVK: "example.com/v1, Kind=Gadget", PhasePath: "status.stage"}})
+
+	widget := object("example.com/v1", "Widget", map[string]interface{}{})
+	gadget := object("example.com/v1", "Gadget", map[string]interface{}{"stage": "Assembling"})
+	assert.Equal(t, "spinning", first.Summarize(widget).State)
+	assert.Equal(t, "assembling", first.Summarize(gadget).State)
+	assert.Equal(t, "active", second.Summarize(widget).State)
+	assert.Equal(t, "active", second.Summarize(gadget).State)
+	assert.Equal(t, "active", Summarize(widget).State)
+}
+
+func TestRegistry_Default(t *testing.T) {
+	gizmo := object("example.com/v1", "Gizmo", map[string]interface{}{})
+	DefaultRegistry().RegisterSummarizer(gizmo.GroupVersionKind(), func(_ data.Object, _ []Condition, summary Summary) Summary {
+		summary.State = "whirring"
+		return summary
+	}, PriorityTransitioning)
+	assert.Equal(t, "whirring", Summarize(gizmo).State)
+	assert.Equal(t, "active", NewRegistry().Summarize(gizmo).State)
+}
+
+func TestRegistry_ConcurrentRegistration(t *testing.T) {
+	registry := NewRegistry()
+	obj := object("example.com/v1", "Widget", map[string]interface{}{})
+	noop := func(_ data.Object, _ []Condition, summary Summary) Summary { return summary }
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			registry.RegisterSummarizer(obj.GroupVersionKind(), noop, 50)
+		}()
+		go func() {
+			defer wg.Done()
+			registry.Summarize(obj)
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, registry.SummarizersFor(obj.GroupVersionKind()), 27)
+}
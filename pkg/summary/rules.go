@@ -0,0 +1,293 @@
+package summary
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rancher/wrangler/v3/pkg/data"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+)
+
+// Rule declares how objects of a GVK are summarized, so types outside of wrangler can be taught their states
+// without code. Rules are applied at PriorityRules: after the built-in summarizers of status.summary and of error and
+// transitioning conditions, whose state they override, and before the other built-in summarizers. Those mostly
+// only set a state the rules left empty, but some override it, such as the one of objects being removed and the one
+// of the Succeeded and Bound phases. It is expected to be something like:
+//
+//	gvk: "example.com/v1, Kind=Widget"
+//	conditions:
+//	- type: Synced           # Synced=False is an error, Synced=Unknown means the Widget is syncing
+//	  error: ["False"]
+//	  transitioning: ["Unknown"]
+//	  state: syncing
+//	- type: Degraded         # Degraded=True is an error, the state is the reason of the condition
+//	  error: ["True"]
+//	  state: "%REASON%"
+//	phasePath: status.phase
+//	errorPhases: ["Failed"]
+//	transitioningPhases: ["Pending", "Provisioning"]
+//	messagePath: status.message
+//
+// CEL expressions can compute the summary of objects whose status is not condition based, see CELRule.
+type Rule struct {
+	// GVK is the group/version, Kind=kind the rule applies to, in the same format as
+	// CATTLE_WRANGLER_CHECK_GVK_ERROR_MAPPING.
+	GVK string `json:"gvk,omitempty"`
+	// Conditions map conditions of the object to the summary.
+	Conditions []ConditionRule `json:"conditions,omitempty"`
+	// PhasePath is the dot separated path of a field holding the state of the object, used when no condition rule
+	// set a state.
+	PhasePath string `json:"phasePath,omitempty"`
+	// ErrorPhases are the values of the phase that are errors.
+	ErrorPhases []string `json:"errorPhases,omitempty"`
+	// TransitioningPhases are the values of the phase that are transitioning.
+	TransitioningPhases []string `json:"transitioningPhases,omitempty"`
+	// MessagePath is the dot separated path of a field holding a message added to the summary.
+	MessagePath string `json:"messagePath,omitempty"`
+	// CEL summarizes the object with CEL expressions, after the conditions and phase.
+	CEL *CELRule `json:"cel,omitempty"`
+}
+
+// ConditionRule maps the status of a condition to the summary.
+type ConditionRule struct {
+	// Type is the type of the condition.
+	Type string `json:"type"`
+	// Error are the statuses of the condition that are errors.
+	Error []metav1.ConditionStatus `json:"error,omitempty"`
+	// Transitioning are the statuses of the condition that are transitioning.
+	Transitioning []metav1.ConditionStatus `json:"transitioning,omitempty"`
+	// State is the state of the object while the condition is an error or transitioning. "%REASON%" uses the reason
+	// of the condition. Defaults to "error" for errors and the lower case type of the condition otherwise.
+	State string `json:"state,omitempty"`
+}
+
+// ParseRules parses a YAML or JSON list of rules.
+func ParseRules(content []byte) ([]Rule, error) {
+	var rules []Rule
+	if err := yaml.UnmarshalStrict(content, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse summary rules: %w", err)
+	}
+	for _, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
+
+// Validate returns an error if the rule has an invalid GVK, condition rule or CEL expression.
+func (r Rule) Validate() error {
+	_, err := r.compile()
+	return err
+}
+
+// compile validates the rule and compiles its CEL expressions.
+func (r Rule) compile() (compiledRule, error) {
+	gvk, err := r.groupVersionKind()
+	if err != nil {
+		return compiledRule{}, err
+	}
+	for _, condition := range r.Conditions {
+		if condition.Type == "" {
+			return compiledRule{}, fmt.Errorf("summary rule for %s has a condition without type", r.GVK)
+		}
+		for _, status := range append(append([]metav1.ConditionStatus{}, condition.Error...), condition.Transitioning...) {
+			switch status {
+			case metav1.ConditionTrue, metav1.ConditionFalse, metav1.ConditionUnknown:
+			default:
+				return compiledRule{}, fmt.Errorf("summary rule for %s has invalid status %q for condition %s", r.GVK, status, condition.Type)
+			}
+		}
+	}
+
+	compiled := compiledRule{Rule: r, gvk: gvk}
+	if r.CEL != nil {
+		programs, err := r.CEL.compile()
+		if err != nil {
+			return compiledRule{}, fmt.Errorf("summary rule for %s: %w", r.GVK, err)
+		}
+		compiled.cel = programs
+	}
+	return compiled, nil
+}
+
+// compiledRule is a Rule with its CEL expressions compiled.
+type compiledRule struct {
+	Rule
+	gvk schema.GroupVersionKind
+	cel *celPrograms
+}
+
+func (r Rule) groupVersionKind() (schema.GroupVersionKind, error) {
+	mx := gvkRegExp.FindStringSubmatch(r.GVK)
+	if len(mx) == 0 {
+		return schema.GroupVersionKind{}, fmt.Errorf("gvk parsing failed: wrong GVK format: <%s>", r.GVK)
+	}
+	return schema.GroupVersionKind{
+		Group:   mx[1],
+		Version: mx[2],
+		Kind:    mx[3],
+	}, nil
+}
+
+// ruleSources holds the valid rules of each source, such as a file or a ConfigMap, and the rules in effect.
+type ruleSources struct {
+	lock    sync.RWMutex
+	sources map[string][]compiledRule
+	byGVK   map[schema.GroupVersionKind]compiledRule
+}
+
+func newRuleSources() *ruleSources {
+	return &ruleSources{
+		sources: map[string][]compiledRule{},
+		byGVK:   map[schema.GroupVersionKind]compiledRule{},
+	}
+}
+
+// rules are the rules of the package level Summarize.
+var rules = newRuleSources()
+
+// SetRules replaces the rules of a source, removing them if rules is empty. When sources have rules for the same
+// GVK, the rule of the source that sorts last wins. Invalid rules are ignored.
+func SetRules(source string, sourceRules []Rule) {
+	rules.set(source, sourceRules)
+}
+
+func (s *ruleSources) set(source string, sourceRules []Rule) {
+	// only the rules of the source are compiled, the rules of the other sources were when they were set
+	compiled := make([]compiledRule, 0, len(sourceRules))
+	for _, rule := range sourceRules {
+		if c, err := rule.compile(); err == nil {
+			compiled = append(compiled, c)
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(sourceRules) == 0 {
+		delete(s.sources, source)
+	} else {
+		s.sources[source] = compiled
+	}
+
+	names := make([]string, 0, len(s.sources))
+	for name := range s.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	byGVK := map[schema.GroupVersionKind]compiledRule{}
+	for _, name := range names {
+		for _, rule := range s.sources[name] {
+			byGVK[rule.gvk] = rule
+		}
+	}
+	s.byGVK = byGVK
+}
+
+func (s *ruleSources) get(gvk schema.GroupVersionKind) (compiledRule, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	rule, ok := s.byGVK[gvk]
+	return rule, ok
+}
+
+func (s *ruleSources) check(obj data.Object, conditions []Condition, summary Summary) Summary {
+	rule, ok := s.get((&unstructured.Unstructured{Object: obj}).GroupVersionKind())
+	if !ok {
+		return summary
+	}
+	return rule.apply(obj, conditions, summary)
+}
+
+func (r Rule) apply(obj data.Object, conditions []Condition, summary Summary) Summary {
+	stateSet := false
+	for _, c := range conditions {
+		for _, conditionRule := range r.Conditions {
+			if conditionRule.Type != c.Type() {
+				continue
+			}
+			status := metav1.ConditionStatus(c.Status())
+			switch {
+			case hasStatus(conditionRule.Error, status):
+				summary.Error = true
+				summary.State = conditionRule.state(c, "error")
+				summary.Message = append(summary.Message, c.Message())
+				stateSet = true
+			case hasStatus(conditionRule.Transitioning, status):
+				summary.Transitioning = true
+				if !summary.Error {
+					summary.State = conditionRule.state(c, strings.ToLower(c.Type()))
+					stateSet = true
+				}
+				summary.Message = append(summary.Message, c.Message())
+			}
+		}
+	}
+
+	if r.PhasePath != "" && !stateSet {
+		if phase := obj.String(fieldPath(r.PhasePath)...); phase != "" {
+			summary.State = phase
+			switch {
+			case contains(r.ErrorPhases, phase):
+				summary.Error = true
+			case contains(r.TransitioningPhases, phase):
+				summary.Transitioning = true
+			}
+		}
+	}
+
+	if r.MessagePath != "" {
+		if message := obj.String(fieldPath(r.MessagePath)...); message != "" {
+			summary.Message = append(summary.Message, message)
+		}
+	}
+
+	return summary
+}
+
+func (r compiledRule) apply(obj data.Object, conditions []Condition, summary Summary) Summary {
+	summary = r.Rule.apply(obj, conditions, summary)
+	if r.cel != nil {
+		summary = r.cel.apply(obj, summary)
+	}
+	return summary
+}
+
+func (c ConditionRule) state(condition Condition, def string) string {
+	switch c.State {
+	case "":
+		return def
+	case reason:
+		return condition.Reason()
+	}
+	return c.State
+}
+
+func fieldPath(path string) []string {
+	return strings.Split(path, ".")
+}
+
+func hasStatus(statuses []metav1.ConditionStatus, status metav1.ConditionStatus) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,183 @@
+// Package rules loads summary.Rules from files, ConfigMaps and CustomResourceDefinitions, and reloads them when
+// they change.
+package rules
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	apiextcontrollers "github.com/rancher/wrangler/v3/pkg/generated/controllers/apiextensions.k8s.io/v1"
+	corecontrollers "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
+	"github.com/rancher/wrangler/v3/pkg/summary"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+)
+
+// CRDAnnotation is the annotation of a CustomResourceDefinition holding the rule of its kind, in the format of
+// summary.Rule without GVK. The rule applies to all versions of the kind. CRD authors can describe the health of
+// their kind with CEL expressions there, for example:
+//
+//	summary.cattle.io/rule: |
+//	  cel:
+//	    state: "has(object.status.health) ? object.status.health : ''"
+//	    error: "has(object.status.health) && object.status.health == 'Broken'"
+const CRDAnnotation = "summary.cattle.io/rule"
+
+// Target receives the rules of a source, an empty list removing them.
+type Target func(source string, rules []summary.Rule)
+
+func targetOrDefault(target Target) Target {
+	if target == nil {
+		return summary.SetRules
+	}
+	return target
+}
+
+// LoadFile loads the rules of a file into target, summary.SetRules if nil.
+func LoadFile(path string, target Target) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read summary rules from %s: %w", path, err)
+	}
+	rules, err := summary.ParseRules(content)
+	if err != nil {
+		return fmt.Errorf("failed to load summary rules from %s: %w", path, err)
+	}
+	targetOrDefault(target)("file:"+path, rules)
+	return nil
+}
+
+// WatchFile loads the rules of a file into target, summary.SetRules if nil, and reloads them whenever the file
+// changes, checking every interval until ctx is done. Rules that fail to reload are logged and the previous rules
+// are kept.
+func WatchFile(ctx context.Context, path string, interval time.Duration, target Target) error {
+	if err := LoadFile(path, target); err != nil {
+		return err
+	}
+	modTime := fileModTime(path)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			current := fileModTime(path)
+			if current.Equal(modTime) {
+				continue
+			}
+			if err := LoadFile(path, target); err != nil {
+				logrus.Errorf("failed to reload summary rules: %v", err)
+				continue
+			}
+			modTime = current
+			logrus.Infof("Reloaded summary rules from %s", path)
+		}
+	}()
+	return nil
+}
+
+func fileModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// WatchConfigMap loads the rules in the keys of a ConfigMap into target, summary.SetRules if nil, whenever the
+// ConfigMap changes. Each key holds a list of rules, keys are loaded in order so later keys override rules of
+// earlier ones for the same GVK. Rules are removed when the ConfigMap is deleted.
+func WatchConfigMap(ctx context.Context, configMaps corecontrollers.ConfigMapController, namespace, name string, target Target) {
+	target = targetOrDefault(target)
+	source := "configmap:" + namespace + "/" + name
+	configMaps.OnChange(ctx, "summary-rules-"+name, func(key string, configMap *corev1.ConfigMap) (*corev1.ConfigMap, error) {
+		if key != namespace+"/"+name {
+			return configMap, nil
+		}
+		if configMap == nil {
+			target(source, nil)
+			return nil, nil
+		}
+		rules, err := configMapRules(configMap)
+		if err != nil {
+			// keep the previous rules, there is nothing to retry until the ConfigMap changes
+			logrus.Errorf("failed to load summary rules from ConfigMap %s: %v", key, err)
+			return configMap, nil
+		}
+		target(source, rules)
+		return configMap, nil
+	})
+}
+
+func configMapRules(configMap *corev1.ConfigMap) ([]summary.Rule, error) {
+	keys := make([]string, 0, len(configMap.Data))
+	for key := range configMap.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var result []summary.Rule
+	for _, key := range keys {
+		rules, err := summary.ParseRules([]byte(configMap.Data[key]))
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", key, err)
+		}
+		result = append(result, rules...)
+	}
+	return result, nil
+}
+
+// WatchCRDs loads the rules in the CRDAnnotation of CustomResourceDefinitions into target, summary.SetRules if
+// nil, whenever they change.
+func WatchCRDs(ctx context.Context, crds apiextcontrollers.CustomResourceDefinitionController, target Target) {
+	target = targetOrDefault(target)
+	crds.OnChange(ctx, "summary-rules", func(key string, crd *apiextv1.CustomResourceDefinition) (*apiextv1.CustomResourceDefinition, error) {
+		source := "crd:" + key
+		if crd == nil || crd.DeletionTimestamp != nil {
+			target(source, nil)
+			return crd, nil
+		}
+		rules, err := crdRules(crd)
+		if err != nil {
+			logrus.Errorf("failed to load summary rule of CustomResourceDefinition %s: %v", key, err)
+			return crd, nil
+		}
+		target(source, rules)
+		return crd, nil
+	})
+}
+
+func crdRules(crd *apiextv1.CustomResourceDefinition) ([]summary.Rule, error) {
+	annotation := crd.Annotations[CRDAnnotation]
+	if annotation == "" {
+		return nil, nil
+	}
+	var rule summary.Rule
+	if err := yaml.UnmarshalStrict([]byte(annotation), &rule); err != nil {
+		return nil, fmt.Errorf("failed to parse annotation %s: %w", CRDAnnotation, err)
+	}
+
+	var result []summary.Rule
+	for _, version := range crd.Spec.Versions {
+		rule.GVK = schema.GroupVersionKind{
+			Group:   crd.Spec.Group,
+			Version: version.Name,
+			Kind:    crd.Spec.Names.Kind,
+		}.String()
+		if err := rule.Validate(); err != nil {
+			return nil, err
+		}
+		result = append(result, rule)
+	}
+	return result, nil
+}
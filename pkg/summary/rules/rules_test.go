@@ -0,0 +1,146 @@
+package rules
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rancher/wrangler/v3/pkg/summary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// recorder is a Target keeping the last rules of each source.
+type recorder struct {
+	lock    sync.Mutex
+	sources map[string][]summary.Rule
+}
+
+func (r *recorder) set(source string, rules []summary.Rule) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.sources == nil {
+		r.sources = map[string][]summary.Rule{}
+	}
+	r.sources[source] = rules
+}
+
+func (r *recorder) get(source string) []summary.Rule {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.sources[source]
+}
+
+func TestWatchFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`[{"gvk": "example.com/v1, Kind=Widget", "phasePath": "status.phase"}]`), 0600))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rec := &recorder{}
+	require.NoError(t, WatchFile(ctx, path, 10*time.Millisecond, rec.set))
+	source := "file:" + path
+	require.Len(t, rec.get(source), 1)
+	assert.Equal(t, "status.phase", rec.get(source)[0].PhasePath)
+
+	// invalid rules are not loaded
+	later := time.Now().Add(time.Second)
+	replaceFile(t, path, `[{"gvk": "Widget"}]`, later)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, "status.phase", rec.get(source)[0].PhasePath)
+
+	later = later.Add(time.Second)
+	replaceFile(t, path, `[{"gvk": "example.com/v1, Kind=Widget", "phasePath": "status.state"}]`, later)
+	assert.Eventually(t, func() bool {
+		return rec.get(source)[0].PhasePath == "status.state"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Error(t, WatchFile(ctx, filepath.Join(t.TempDir(), "missing.yaml"), time.Second, rec.set))
+}
+
+// replaceFile replaces the content of the file at once, so it is never read half written.
+func replaceFile(t *testing.T, path, content string, modTime time.Time) {
+	t.Helper()
+	tmp := path + ".tmp"
+	require.NoError(t, os.WriteFile(tmp, []byte(content), 0600))
+	require.NoError(t, os.Chtimes(tmp, modTime, modTime))
+	require.NoError(t, os.Rename(tmp, path))
+}
+
+func TestConfigMapRules(t *testing.T) {
+	rules, err := configMapRules(&corev1.ConfigMap{Data: map[string]string{
+		"b.yaml": `[{"gvk": "example.com/v1, Kind=Widget", "phasePath": "status.b"}]`,
+		"a.yaml": `[{"gvk": "example.com/v1, Kind=Widget", "phasePath": "status.a"}]`,
+	}})
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	assert.Equal(t, "status.a", rules[0].PhasePath)
+	assert.Equal(t, "status.b", rules[1].PhasePath)
+
+	_, err = configMapRules(&corev1.ConfigMap{Data: map[string]string{"a.yaml": "not rules"}})
+	assert.Error(t, err)
+}
+
+func TestCRDRules(t *testing.T) {
+	crd := &apiextv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "widgets.example.com",
+			Annotations: map[string]string{
+				CRDAnnotation: `{"conditions": [{"type": "Synced", "error": ["False"]}]}`,
+			},
+		},
+		Spec: apiextv1.CustomResourceDefinitionSpec{
+			Group: "example.com",
+			Names: apiextv1.CustomResourceDefinitionNames{Kind: "Widget"},
+			Versions: []apiextv1.CustomResourceDefinitionVersion{
+				{Name: "v1"},
+				{Name: "v2"},
+			},
+		},
+	}
+	rules, err := crdRules(crd)
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	assert.Equal(t, "example.com/v1, Kind=Widget", rules[0].GVK)
+	assert.Equal(t, "example.com/v2, Kind=Widget", rules[1].GVK)
+	assert.Equal(t, "Synced", rules[1].Conditions[0].Type)
+
+	crd.Annotations[CRDAnnotation] = `{"conditions": [{"type": "Synced", "error": ["Maybe"]}]}`
+	_, err = crdRules(crd)
+	assert.Error(t, err)
+
+	delete(crd.Annotations, CRDAnnotation)
+	rules, err = crdRules(crd)
+	require.NoError(t, err)
+	assert.Empty(t, rules)
+}
+
+func TestCRDRules_CEL(t *testing.T) {
+	crd := &apiextv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "widgets.example.com",
+			Annotations: map[string]string{
+				CRDAnnotation: "cel:\n  state: \"has(object.status.health) ? object.status.health : ''\"\n",
+			},
+		},
+		Spec: apiextv1.CustomResourceDefinitionSpec{
+			Group:    "example.com",
+			Names:    apiextv1.CustomResourceDefinitionNames{Kind: "Widget"},
+			Versions: []apiextv1.CustomResourceDefinitionVersion{{Name: "v1"}},
+		},
+	}
+	rules, err := crdRules(crd)
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+	require.NotNil(t, rules[0].CEL)
+
+	crd.Annotations[CRDAnnotation] = "cel:\n  error: \"object.status.\"\n"
+	_, err = crdRules(crd)
+	assert.Error(t, err)
+}
@@ -0,0 +1,202 @@
+package summary
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const widgetRules = `
+- gvk: "example.com/v1, Kind=Widget"
+  conditions:
+  - type: Synced
+    error: ["False"]
+    transitioning: ["Unknown"]
+    state: syncing
+  - type: Degraded
+    error: ["True"]
+    state: "%REASON%"
+  phasePath: status.phase
+  errorPhases: ["Failed"]
+  transitioningPhases: ["Pending"]
+  messagePath: status.message
+`
+
+func widget(status map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "example.com/v1",
+		"kind":       "Widget",
+		"metadata":   map[string]interface{}{"name": "w", "namespace": "ns"},
+		"status":     status,
+	}}
+}
+
+func condition(conditionType, status, reason, message string) map[string]interface{} {
+	return map[string]interface{}{"type": conditionType, "status": status, "reason": reason, "message": message}
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules([]byte(widgetRules))
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+	assert.Equal(t, "status.phase", rules[0].PhasePath)
+	assert.Len(t, rules[0].Conditions, 2)
+
+	for name, content := range map[string]string{
+		"invalid gvk":       `[{"gvk": "Widget"}]`,
+		"condition no type": `[{"gvk": "example.com/v1, Kind=Widget", "conditions": [{"error": ["True"]}]}]`,
+		"invalid status":    `[{"gvk": "example.com/v1, Kind=Widget", "conditions": [{"type": "A", "error": ["Yes"]}]}]`,
+		"unknown field":     `[{"gvk": "example.com/v1, Kind=Widget", "phase": "status.phase"}]`,
+	} {
+		_, err := ParseRules([]byte(content))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestSummarize_Rules(t *testing.T) {
+	rules, err := ParseRules([]byte(widgetRules))
+	require.NoError(t, err)
+	SetRules("test", rules)
+	defer SetRules("test", nil)
+
+	testCases := []struct {
+		name     string
+		status   map[string]interface{}
+		expected Summary
+	}{
+		{
+			name: "error condition",
+			status: map[string]interface{}{"conditions": []interface{}{
+				condition("Synced", "False", "", "sync failed"),
+			}},
+			expected: Summary{State: "syncing", Error: true, Message: []string{"sync failed"}},
+		},
+		{
+			name: "transitioning condition",
+			status: map[string]interface{}{"conditions": []interface{}{
+				condition("Synced", "Unknown", "", "syncing"),
+			}},
+			expected: Summary{State: "syncing", Transitioning: true, Message: []string{"syncing"}},
+		},
+		{
+			name: "state from reason",
+			status: map[string]interface{}{"conditions": []interface{}{
+				condition("Degraded", "True", "OutOfGears", ""),
+			}},
+			expected: Summary{State: "outofgears", Error: true},
+		},
+		{
+			name:     "error phase",
+			status:   map[string]interface{}{"phase": "Failed", "message": "no gears left"},
+			expected: Summary{State: "failed", Error: true, Message: []string{"no gears left"}},
+		},
+		{
+			name:     "transitioning phase",
+			status:   map[string]interface{}{"phase": "Pending"},
+			expected: Summary{State: "pending", Transitioning: true},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			summary := Summarize(widget(tc.status))
+			assert.Equal(t, tc.expected.State, summary.State)
+			assert.Equal(t, tc.expected.Error, summary.Error)
+			assert.Equal(t, tc.expected.Transitioning, summary.Transitioning)
+			assert.Equal(t, tc.expected.Message, summary.Message)
+		})
+	}
+}
+
+func TestSetRules_Sources(t *testing.T) {
+	first := []Rule{{GVK: "example.com/v1, Kind=Widget", PhasePath: "status.first"}}
+	second := []Rule{{GVK: "example.com/v1, Kind=Widget", PhasePath: "status.second"}}
+	SetRules("a", first)
+	SetRules("b", second)
+	defer SetRules("a", nil)
+	defer SetRules("b", nil)
+
+	obj := widget(map[string]interface{}{"first": "one", "second": "two"})
+	assert.Equal(t, "two", Summarize(obj).State, "the source sorting last should win")
+
+	SetRules("b", nil)
+	assert.Equal(t, "one", Summarize(obj).State, "removing a source should restore the rules of the others")
+}
+
+func TestSetRules_CompilesOnlyTheSource(t *testing.T) {
+	sources := newRuleSources()
+	sources.set("a", []Rule{{GVK: "example.com/v1, Kind=Widget", CEL: &CELRule{State: "'ready'"}}})
+	gvk := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"}
+	before, ok := sources.get(gvk)
+	require.True(t, ok)
+
+	sources.set("b", []Rule{{GVK: "example.com/v1, Kind=Gadget", PhasePath: "status.phase"}})
+	after, ok := sources.get(gvk)
+	require.True(t, ok)
+	assert.Same(t, before.cel, after.cel, "the rules of other sources should not be compiled again")
+}
+
+func TestSummarize_CELRules(t *testing.T) {
+	rules, err := ParseRules([]byte(`
+- gvk: "example.com/v1, Kind=Widget"
+  cel:
+    state: "has(object.status.health) ? object.status.health : ''"
+    error: "has(object.status.health) && object.status.health == 'Broken'"
+    transitioning: "has(object.status.pendingChanges) && object.status.pendingChanges > 0"
+    message: "has(object.status.errors) ? object.status.errors : []"
+`))
+	require.NoError(t, err)
+	SetRules("test", rules)
+	defer SetRules("test", nil)
+
+	testCases := []struct {
+		name     string
+		status   map[string]interface{}
+		expected Summary
+	}{
+		{
+			name:     "healthy",
+			status:   map[string]interface{}{"health": "Healthy"},
+			expected: Summary{State: "healthy"},
+		},
+		{
+			name:     "broken with messages",
+			status:   map[string]interface{}{"health": "Broken", "errors": []interface{}{"gear 1 missing", "gear 2 missing"}},
+			expected: Summary{State: "broken", Error: true, Message: []string{"gear 1 missing", "gear 2 missing"}},
+		},
+		{
+			name:     "pending changes",
+			status:   map[string]interface{}{"health": "Updating", "pendingChanges": int64(2)},
+			expected: Summary{State: "updating", Transitioning: true},
+		},
+		{
+			name:     "missing fields",
+			status:   map[string]interface{}{},
+			expected: Summary{State: "active", Message: []string{"Resource is current"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			summary := Summarize(widget(tc.status))
+			assert.Equal(t, tc.expected.State, summary.State)
+			assert.Equal(t, tc.expected.Error, summary.Error)
+			assert.Equal(t, tc.expected.Transitioning, summary.Transitioning)
+			assert.Equal(t, tc.expected.Message, summary.Message)
+		})
+	}
+}
+
+func TestParseRules_CEL(t *testing.T) {
+	for name, content := range map[string]string{
+		"syntax error": `[{"gvk": "example.com/v1, Kind=Widget", "cel": {"state": "object.status."}}]`,
+		"wrong type":   `[{"gvk": "example.com/v1, Kind=Widget", "cel": {"error": "'yes'"}}]`,
+		"unknown var":  `[{"gvk": "example.com/v1, Kind=Widget", "cel": {"state": "obj.status.health"}}]`,
+	} {
+		_, err := ParseRules([]byte(content))
+		assert.Error(t, err, name)
+	}
+}
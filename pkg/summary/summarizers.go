@@ -132,38 +132,24 @@ var (
 		"Paused":      reason, // CAPI Cluster, MachineDeployment, MachineSet, Machine
 	}
 
-	Summarizers          []Summarizer
+	// Summarizers are the built-in summarizers of all objects, in the order they run.
+	//
+	// Deprecated: Summarizers is a copy made when the package is initialized, changes to it are not used by
+	// Summarize. Register summarizers with the RegisterSummarizer method of DefaultRegistry or of a Registry.
+	Summarizers []Summarizer
+	// ConditionSummarizers are the built-in condition summarizers of all objects, in the order they run.
+	//
+	// Deprecated: ConditionSummarizers is a copy made when the package is initialized, changes to it are not used
+	// by NormalizeConditions. Register summarizers with the RegisterConditionSummarizer method of DefaultRegistry or
+	// of a Registry.
 	ConditionSummarizers []Summarizer
 )
 
 type Summarizer func(obj data.Object, conditions []Condition, summary Summary) Summary
 
 func init() {
-	ConditionSummarizers = []Summarizer{
-		checkErrors,
-		checkGenericTransitioning,
-		checkRemoving,
-		checkCattleReady,
-	}
-
-	Summarizers = []Summarizer{
-		checkStatusSummary,
-		checkErrors,
-		checkTransitioning,
-		checkActive,
-		checkPhase,
-		checkInitializing,
-		checkRemoving,
-		checkStandard,
-		checkLoadBalancer,
-		checkPod,
-		checkHasPodSelector,
-		checkHasPodTemplate,
-		checkOwner,
-		checkApplyOwned,
-		checkCattleTypes,
-		checkGeneration,
-	}
+	ConditionSummarizers = builtinConditionSummarizers()
+	Summarizers = builtinSummarizers(rules)
 
 	initializeCheckErrors()
 }
@@ -345,6 +331,9 @@ func checkErrors(data data.Object, conditions []Condition, summary Summary) Summ
 
 	for _, c := range conditions {
 		status, found := conditionMapping[c.Type()]
+		if !found {
+			status, found = kstatusErrorConditions[c.Type()]
+		}
 		reasonIsError := c.Reason() == "Error"
 
 		if !found && !reasonIsError {
@@ -364,20 +353,14 @@ func checkErrors(data data.Object, conditions []Condition, summary Summary) Summ
 	return summary
 }
 
+// checkTransitioning runs the summarizers of the DefaultRegistry at PriorityTransitioning for the kind of the object,
+// such as the ones of the CAPI and operation kinds.
 func checkTransitioning(obj data.Object, conditions []Condition, summary Summary) Summary {
-	if isCAPIMachine(obj) {
-		return checkCAPIMachineTransitioning(conditions, summary)
+	gvk := (&unstructured.Unstructured{Object: obj}).GroupVersionKind()
+	for _, summarizer := range defaultRegistry.summarizersAt(gvk, PriorityTransitioning) {
+		summary = summarizer(obj, conditions, summary)
 	}
-	if isCAPIMachineSet(obj) || isCAPIMachineDeployment(obj) {
-		return checkCAPIMachineSetAndDeploymentTransitioning(obj, conditions, summary)
-	}
-	if isCAPICluster(obj) {
-		return checkCAPIClusterTransitioning(obj, conditions, summary)
-	}
-	if isOperation(obj) {
-		return checkOperationTransitioning(conditions, summary)
-	}
-	return checkGenericTransitioning(obj, conditions, summary)
+	return summary
 }
 
 // checkCAPIMachineTransitioning computes summary state for CAPI Machine objects
@@ -8,6 +8,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 type Summary struct {
@@ -22,6 +23,9 @@ type Summary struct {
 
 type SummarizeOptions struct {
 	HasObservedGeneration bool
+	// KStatus sets the state of the summary to its status in the vocabulary of kstatus, such as InProgress or
+	// Current, see Summary.KStatus.
+	KStatus bool
 }
 
 type Relationship struct {
@@ -90,11 +94,18 @@ func dedupMessage(messages []string) []string {
 	return result
 }
 
+// Summarize summarizes the object with the summarizers of the DefaultRegistry.
 func Summarize(runtimeObj runtime.Object) Summary {
-	return SummarizeWithOptions(runtimeObj, nil)
+	return defaultRegistry.Summarize(runtimeObj)
 }
 
+// SummarizeWithOptions summarizes the object with the summarizers of the DefaultRegistry.
 func SummarizeWithOptions(runtimeObj runtime.Object, opts *SummarizeOptions) Summary {
+	return defaultRegistry.SummarizeWithOptions(runtimeObj, opts)
+}
+
+// summarizeWith summarizes the object with the summarizers returned for its GVK.
+func summarizeWith(runtimeObj runtime.Object, opts *SummarizeOptions, summarizersFor func(gvk schema.GroupVersionKind) []Summarizer) Summary {
 	var (
 		obj     data.Object
 		err     error
@@ -113,8 +124,10 @@ func SummarizeWithOptions(runtimeObj runtime.Object, opts *SummarizeOptions) Sum
 		}
 	}
 
+	var gvk schema.GroupVersionKind
 	if unstr != nil {
 		obj = unstr.Object
+		gvk = unstr.GroupVersionKind()
 	}
 
 	conditions := getConditions(obj)
@@ -122,7 +135,7 @@ func SummarizeWithOptions(runtimeObj runtime.Object, opts *SummarizeOptions) Sum
 		summary.HasObservedGeneration = opts.HasObservedGeneration
 	}
 
-	for _, summarizer := range Summarizers {
+	for _, summarizer := range summarizersFor(gvk) {
 		summary = summarizer(obj, conditions, summary)
 	}
 
@@ -132,5 +145,8 @@ func SummarizeWithOptions(runtimeObj runtime.Object, opts *SummarizeOptions) Sum
 
 	summary.State = strings.ToLower(summary.State)
 	summary.Message = dedupMessage(summary.Message)
+	if opts != nil && opts.KStatus {
+		summary.State = string(summary.KStatus().Status)
+	}
 	return summary
 }
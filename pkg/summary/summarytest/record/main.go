@@ -0,0 +1,23 @@
+// The record command writes the current summaries of the objects of summary fixtures as their expected summaries,
+// see the summarytest package.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/rancher/wrangler/v3/pkg/summary/summarytest"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintf(os.Stderr, "usage: %s DIR...\n", os.Args[0])
+		os.Exit(2)
+	}
+	for _, dir := range os.Args[1:] {
+		if err := summarytest.Record(dir, nil); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to record summary fixtures of %s: %v\n", dir, err)
+			os.Exit(1)
+		}
+	}
+}
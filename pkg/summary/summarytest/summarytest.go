@@ -0,0 +1,198 @@
+// Package summarytest runs conformance tests of summarizers from YAML fixtures, so coverage for a kind can be added
+// without writing Go. Each fixture is a YAML file of a directory holding an object and its expected summary:
+//
+//	description: Deployment without enough available replicas
+//	object:
+//	  apiVersion: apps/v1
+//	  kind: Deployment
+//	  ...
+//	expected:
+//	  state: updating
+//	  transitioning: true
+//	  message:
+//	  - Deployment does not have minimum availability.
+//
+// The record command of this package writes the current summaries of the objects of a directory as their expected
+// summaries, to create or update golden files:
+//
+//	go run github.com/rancher/wrangler/v3/pkg/summary/summarytest/record testdata/fixtures
+package summarytest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/rancher/wrangler/v3/pkg/summary"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// Fixture is an object and its expected summary.
+type Fixture struct {
+	// Name is the name of the file of the fixture, without extension.
+	Name        string                 `json:"-"`
+	Description string                 `json:"description,omitempty"`
+	Object      map[string]interface{} `json:"object"`
+	Expected    Expected               `json:"expected"`
+}
+
+// Expected are the fields of a summary compared by Run.
+type Expected struct {
+	State         string   `json:"state,omitempty"`
+	Error         bool     `json:"error,omitempty"`
+	Transitioning bool     `json:"transitioning,omitempty"`
+	Message       []string `json:"message,omitempty"`
+}
+
+// SummarizeFunc summarizes the objects of the fixtures, such as summary.Summarize or Registry.Summarize.
+type SummarizeFunc func(runtime.Object) summary.Summary
+
+func expected(s summary.Summary) Expected {
+	return Expected{
+		State:         s.State,
+		Error:         s.Error,
+		Transitioning: s.Transitioning,
+		Message:       s.Message,
+	}
+}
+
+// Load reads the fixtures of the .yaml and .yml files of a directory, sorted by name.
+func Load(dir string) ([]Fixture, error) {
+	files, err := fixtureFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	fixtures := make([]Fixture, 0, len(files))
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var fixture Fixture
+		if err := yaml.UnmarshalStrict(content, &fixture); err != nil {
+			return nil, fmt.Errorf("failed to parse summary fixture %s: %w", file, err)
+		}
+		if len(fixture.Object) == 0 {
+			return nil, fmt.Errorf("summary fixture %s has no object", file)
+		}
+		fixture.Name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		fixtures = append(fixtures, fixture)
+	}
+	return fixtures, nil
+}
+
+func fixtureFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		switch filepath.Ext(entry.Name()) {
+		case ".yaml", ".yml":
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Run runs a subtest per fixture of the directory, comparing the summary of its object to the expected one.
+// Summarize defaults to summary.Summarize.
+func Run(t *testing.T, dir string, summarize SummarizeFunc) {
+	t.Helper()
+	if summarize == nil {
+		summarize = summary.Summarize
+	}
+
+	fixtures, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixtures) == 0 {
+		t.Fatalf("no summary fixtures in %s", dir)
+	}
+
+	for _, fixture := range fixtures {
+		t.Run(fixture.Name, func(t *testing.T) {
+			got := expected(summarize(&unstructured.Unstructured{Object: fixture.Object}))
+			if diff := compare(fixture.Expected, got); diff != "" {
+				t.Errorf("%s: summary does not match the fixture, %s", fixture.Description, diff)
+			}
+		})
+	}
+}
+
+func compare(want, got Expected) string {
+	var diffs []string
+	if want.State != got.State {
+		diffs = append(diffs, fmt.Sprintf("state is %q, want %q", got.State, want.State))
+	}
+	if want.Error != got.Error {
+		diffs = append(diffs, fmt.Sprintf("error is %t, want %t", got.Error, want.Error))
+	}
+	if want.Transitioning != got.Transitioning {
+		diffs = append(diffs, fmt.Sprintf("transitioning is %t, want %t", got.Transitioning, want.Transitioning))
+	}
+	if strings.Join(want.Message, "\n") != strings.Join(got.Message, "\n") || len(want.Message) != len(got.Message) {
+		diffs = append(diffs, fmt.Sprintf("message is %q, want %q", got.Message, want.Message))
+	}
+	return strings.Join(diffs, ", ")
+}
+
+// Record writes the current summaries of the objects of the fixtures of the directory as their expected summaries.
+// Comments of the files are not kept. Summarize defaults to summary.Summarize.
+func Record(dir string, summarize SummarizeFunc) error {
+	if summarize == nil {
+		summarize = summary.Summarize
+	}
+
+	files, err := fixtureFiles(dir)
+	if err != nil {
+		return err
+	}
+	fixtures, err := Load(dir)
+	if err != nil {
+		return err
+	}
+	for i, fixture := range fixtures {
+		fixture.Expected = expected(summarize(&unstructured.Unstructured{Object: fixture.Object}))
+		content, err := marshal(fixture)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(files[i], content, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// marshal writes the fields of the fixture in the order they are documented, as YAML sorts the keys of maps.
+func marshal(fixture Fixture) ([]byte, error) {
+	var content []byte
+	for _, field := range []map[string]interface{}{
+		{"description": fixture.Description},
+		{"object": fixture.Object},
+		{"expected": fixture.Expected},
+	} {
+		if field["description"] == "" {
+			continue
+		}
+		data, err := yaml.Marshal(field)
+		if err != nil {
+			return nil, err
+		}
+		content = append(content, data...)
+	}
+	return content, nil
+}
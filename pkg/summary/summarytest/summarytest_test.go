@@ -0,0 +1,61 @@
+package summarytest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/wrangler/v3/pkg/summary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const widget = `description: Widget without status
+object:
+  apiVersion: example.com/v1
+  kind: Widget
+  metadata:
+    name: w
+expected:
+  state: wrong
+`
+
+func TestRecord(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "widget.yaml"), []byte(widget), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("not a fixture"), 0644))
+
+	summarize := func(runtime.Object) summary.Summary {
+		return summary.Summary{State: "broken", Error: true, Message: []string{"is broken"}}
+	}
+	require.NoError(t, Record(dir, summarize))
+
+	fixtures, err := Load(dir)
+	require.NoError(t, err)
+	require.Len(t, fixtures, 1)
+	assert.Equal(t, "widget", fixtures[0].Name)
+	assert.Equal(t, "Widget without status", fixtures[0].Description)
+	assert.Equal(t, "Widget", fixtures[0].Object["kind"])
+	assert.Equal(t, Expected{State: "broken", Error: true, Message: []string{"is broken"}}, fixtures[0].Expected)
+
+	Run(t, dir, summarize)
+}
+
+func TestCompare(t *testing.T) {
+	assert.Empty(t, compare(Expected{State: "active"}, Expected{State: "active", Message: []string{}}))
+	assert.Equal(t, `state is "error", want "active", error is true, want false`,
+		compare(Expected{State: "active"}, Expected{State: "error", Error: true}))
+	assert.NotEmpty(t, compare(Expected{Message: []string{""}}, Expected{}))
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bad.yaml"), []byte("expected:\n  state: active\n"), 0644))
+	_, err := Load(dir)
+	assert.ErrorContains(t, err, "has no object")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bad.yaml"), []byte("object: {}\nunknown: true\n"), 0644))
+	_, err = Load(dir)
+	assert.Error(t, err)
+}
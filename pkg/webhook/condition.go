@@ -0,0 +1,104 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	v1 "k8s.io/api/admission/v1"
+)
+
+// conditionCostLimit bounds the cost of evaluating a match condition, as conditions run for every request to
+// their route.
+const conditionCostLimit = 1000000
+
+// conditionEnv returns the CEL environment shared by all match conditions.
+var conditionEnv = sync.OnceValues(func() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("object", cel.DynType),
+		cel.Variable("oldObject", cel.DynType),
+		cel.Variable("request", cel.DynType),
+	)
+})
+
+// matchCondition is a compiled CEL expression used to match admission requests.
+type matchCondition struct {
+	expression string
+	program    cel.Program
+	err        error
+}
+
+func newMatchCondition(expression string) *matchCondition {
+	m := &matchCondition{expression: expression}
+	m.program, m.err = compileCondition(expression)
+	return m
+}
+
+func compileCondition(expression string) (cel.Program, error) {
+	env, err := conditionEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	ast, issues := env.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if ast.OutputType() != cel.BoolType && ast.OutputType() != cel.DynType {
+		return nil, fmt.Errorf("expression must return a bool, got %s", ast.OutputType())
+	}
+
+	return env.Program(ast, cel.CostLimit(conditionCostLimit))
+}
+
+func (m *matchCondition) matches(req *v1.AdmissionRequest) (bool, error) {
+	if m.err != nil {
+		return false, m.err
+	}
+
+	vars := map[string]interface{}{}
+	for name, value := range map[string]interface{}{
+		"object":    req.Object.Raw,
+		"oldObject": req.OldObject.Raw,
+		"request":   req,
+	} {
+		converted, err := toCELValue(value)
+		if err != nil {
+			return false, err
+		}
+		vars[name] = converted
+	}
+
+	val, _, err := m.program.Eval(vars)
+	if err != nil {
+		return false, err
+	}
+	result, ok := val.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("expression returned %T, expected bool", val.Value())
+	}
+	return result, nil
+}
+
+// toCELValue converts raw JSON or a JSON serializable value to the generic types understood by CEL.
+// Empty raw JSON is converted to null.
+func toCELValue(value interface{}) (interface{}, error) {
+	raw, ok := value.([]byte)
+	if !ok {
+		var err error
+		raw, err = json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	var result interface{}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,103 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	v1 "k8s.io/api/admission/v1"
+	"k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// decodeReview decodes an admission/v1 or admission/v1beta1 AdmissionReview.
+// A v1beta1 review is converted to v1, the returned API version is the version of the request.
+func decodeReview(body io.Reader) (*v1.AdmissionReview, string, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	typeMeta := metav1.TypeMeta{}
+	if err := json.Unmarshal(data, &typeMeta); err != nil {
+		return nil, "", err
+	}
+
+	switch typeMeta.APIVersion {
+	case v1beta1.SchemeGroupVersion.String():
+		v1beta1Review := &v1beta1.AdmissionReview{}
+		if err := json.Unmarshal(data, v1beta1Review); err != nil {
+			return nil, typeMeta.APIVersion, err
+		}
+		review := &v1.AdmissionReview{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: v1.SchemeGroupVersion.String(),
+				Kind:       "AdmissionReview",
+			},
+		}
+		if v1beta1Review.Request != nil {
+			review.Request = convertV1beta1Request(v1beta1Review.Request)
+		}
+		return review, typeMeta.APIVersion, nil
+	case v1.SchemeGroupVersion.String(), "":
+		review := &v1.AdmissionReview{}
+		if err := json.Unmarshal(data, review); err != nil {
+			return nil, v1.SchemeGroupVersion.String(), err
+		}
+		return review, v1.SchemeGroupVersion.String(), nil
+	}
+	return nil, "", fmt.Errorf("unsupported AdmissionReview version %q", typeMeta.APIVersion)
+}
+
+// encodeReview returns the review in the given API version for encoding.
+func encodeReview(review *v1.AdmissionReview, apiVersion string) interface{} {
+	if apiVersion != v1beta1.SchemeGroupVersion.String() {
+		return review
+	}
+	v1beta1Review := &v1beta1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: apiVersion,
+			Kind:       "AdmissionReview",
+		},
+	}
+	if review.Response != nil {
+		v1beta1Review.Response = convertV1Response(review.Response)
+	}
+	return v1beta1Review
+}
+
+func convertV1beta1Request(in *v1beta1.AdmissionRequest) *v1.AdmissionRequest {
+	return &v1.AdmissionRequest{
+		UID:                in.UID,
+		Kind:               in.Kind,
+		Resource:           in.Resource,
+		SubResource:        in.SubResource,
+		RequestKind:        in.RequestKind,
+		RequestResource:    in.RequestResource,
+		RequestSubResource: in.RequestSubResource,
+		Name:               in.Name,
+		Namespace:          in.Namespace,
+		Operation:          v1.Operation(in.Operation),
+		UserInfo:           in.UserInfo,
+		Object:             in.Object,
+		OldObject:          in.OldObject,
+		DryRun:             in.DryRun,
+		Options:            in.Options,
+	}
+}
+
+func convertV1Response(in *v1.AdmissionResponse) *v1beta1.AdmissionResponse {
+	out := &v1beta1.AdmissionResponse{
+		UID:              in.UID,
+		Allowed:          in.Allowed,
+		Result:           in.Result,
+		Patch:            in.Patch,
+		AuditAnnotations: in.AuditAnnotations,
+		Warnings:         in.Warnings,
+	}
+	if in.PatchType != nil {
+		patchType := v1beta1.PatchType(*in.PatchType)
+		out.PatchType = &patchType
+	}
+	return out
+}
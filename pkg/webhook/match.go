@@ -1,7 +1,19 @@
 package webhook
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/rancher/wrangler/v3/pkg/generic"
+	"github.com/sirupsen/logrus"
 	v1 "k8s.io/api/admission/v1"
+	admissionregv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -18,16 +30,143 @@ type RouteMatch struct {
 	namespace   string
 	operation   v1.Operation
 	objType     runtime.Object
+
+	objectSelector    labels.Selector
+	namespaceSelector labels.Selector
+	namespaceCache    generic.NonNamespacedCacheInterface[*corev1.Namespace]
+	users             []string
+	userGroups        []string
+	conditions        []*matchCondition
+
+	routeName        string
+	timeout          time.Duration
+	failurePolicy    admissionregv1.FailurePolicyType
+	skipOnMatchError bool
 }
 
 func (r *RouteMatch) admit(response *Response, request *Request) error {
-	if r.handler != nil {
-		return r.handler.Admit(response, request)
+	if r.handler == nil {
+		return nil
+	}
+
+	start := time.Now()
+	result, err := r.admitWithTimeout(response, request)
+	if result == "" {
+		result = resultFor(response, err)
+	}
+	observeAdmission(r.getRouteName(), request.Operation, result, time.Since(start))
+	return err
+}
+
+// admitWithTimeout calls the handler and applies the failure policy if the handler does not return before the timeout.
+// A non-empty result is returned if the handler timed out.
+func (r *RouteMatch) admitWithTimeout(response *Response, request *Request) (string, error) {
+	if r.timeout <= 0 {
+		return "", r.callHandler(response, request)
+	}
+
+	ctx, cancel := context.WithTimeout(request.Context, r.timeout)
+	defer cancel()
+
+	timedRequest := *request
+	timedRequest.Context = ctx
+	// the handler writes to its own copy so a late handler can not modify the response after the timeout
+	timedResponse := &Response{AdmissionResponse: *response.AdmissionResponse.DeepCopy()}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- r.callHandler(timedResponse, &timedRequest)
+	}()
+
+	select {
+	case err := <-done:
+		*response = *timedResponse
+		return "", err
+	case <-ctx.Done():
+	}
+
+	logrus.Errorf("admission handler for route %s timed out after %s", r.getRouteName(), r.timeout)
+	if r.failurePolicy == admissionregv1.Ignore {
+		response.Allowed = true
+		response.Result = nil
+		response.AddWarning("admission webhook route %s timed out, request was allowed", r.getRouteName())
+	} else {
+		response.Deny(http.StatusGatewayTimeout, "admission webhook route %s timed out after %s", r.getRouteName(), r.timeout)
+	}
+	return resultTimeout, nil
+}
+
+// callHandler calls the handler and converts a panic in the handler to an error.
+func (r *RouteMatch) callHandler(response *Response, request *Request) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			logrus.Errorf("panic in admission handler for route %s: %v\n%s", r.getRouteName(), recovered, debug.Stack())
+			err = &panicError{value: recovered}
+		}
+	}()
+	return r.handler.Admit(response, request)
+}
+
+// getRouteName returns the name of the route used in metrics and logs.
+// If no name was set the name is built from the values the route matches on.
+func (r *RouteMatch) getRouteName() string {
+	if r.routeName != "" {
+		return r.routeName
+	}
+	var parts []string
+	for _, part := range []string{r.group, r.version, r.kind, r.resource, r.subResource, string(r.operation)} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 {
+		return "default"
 	}
-	return nil
+	return strings.Join(parts, "/")
+}
+
+// panicError is returned for a handler that panicked.
+type panicError struct {
+	value interface{}
+}
+
+func (p *panicError) Error() string {
+	return fmt.Sprintf("admission handler panicked: %v", p.value)
+}
+
+func (r *RouteMatch) matches(req *v1.AdmissionRequest) (bool, error) {
+	if !r.matchesRequest(req) || !checkAny(r.users, req.UserInfo.Username) || !checkAny(r.userGroups, req.UserInfo.Groups...) {
+		return false, nil
+	}
+
+	if r.objectSelector != nil {
+		ok, err := matchesObjectSelector(r.objectSelector, req)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+
+	if r.namespaceSelector != nil {
+		ok, err := matchesNamespaceSelector(r.namespaceCache, r.namespaceSelector, req)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+
+	for _, condition := range r.conditions {
+		ok, err := condition.matches(req)
+		if err != nil {
+			return false, fmt.Errorf("failed to evaluate match condition %q: %w", condition.expression, err)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+
+	return true, nil
 }
 
-func (r *RouteMatch) matches(req *v1.AdmissionRequest) bool {
+func (r *RouteMatch) matchesRequest(req *v1.AdmissionRequest) bool {
 	var group, version, kind, resource string
 
 	if req.RequestKind != nil {
@@ -62,6 +201,20 @@ func checkString(expected, actual string) bool {
 	return expected == actual
 }
 
+func checkAny(expected []string, actual ...string) bool {
+	if len(expected) == 0 {
+		return true
+	}
+	for _, e := range expected {
+		for _, a := range actual {
+			if e == a {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func checkBool(expected, actual *bool) bool {
 	if expected == nil {
 		return true
@@ -77,6 +230,13 @@ func checkBool(expected, actual *bool) bool {
 // DryRun matches admission request with the matching DryRun value.
 func (r *RouteMatch) DryRun(dryRun bool) *RouteMatch { r.dryRun = &dryRun; return r }
 
+// FailurePolicy sets whether the request is allowed (Ignore) or denied (Fail) when the handler times out.
+// The default is Fail.
+func (r *RouteMatch) FailurePolicy(policy admissionregv1.FailurePolicyType) *RouteMatch {
+	r.failurePolicy = policy
+	return r
+}
+
 // Group matches admission request with the matching Group value.
 func (r *RouteMatch) Group(group string) *RouteMatch { r.group = group; return r }
 
@@ -86,6 +246,15 @@ func (r *RouteMatch) HandleFunc(handler HandlerFunc) *RouteMatch { r.handler = h
 // Handle sets the Handler to be called for matching admission request.
 func (r *RouteMatch) Handle(handler Handler) *RouteMatch { r.handler = handler; return r }
 
+// MatchCondition matches admission request for which the CEL expression evaluates to true.
+// The expression can reference the variables object, oldObject and request, which hold the
+// decoded objects and the AdmissionRequest. If the expression fails to compile, fails to evaluate
+// or exceeds its cost limit the request is rejected, unless the route is set to SkipOnMatchError.
+func (r *RouteMatch) MatchCondition(expression string) *RouteMatch {
+	r.conditions = append(r.conditions, newMatchCondition(expression))
+	return r
+}
+
 // Kind matches admission request with the matching Kind value.
 func (r *RouteMatch) Kind(kind string) *RouteMatch { r.kind = kind; return r }
 
@@ -95,18 +264,57 @@ func (r *RouteMatch) Name(name string) *RouteMatch { r.name = name; return r }
 // Namespace matches admission request with the matching Namespace value.
 func (r *RouteMatch) Namespace(namespace string) *RouteMatch { r.namespace = namespace; return r }
 
+// NamespaceSelector matches admission request whose namespace has labels matching the selector.
+// Namespaces are looked up in the given cache. Requests for Namespace objects are matched against
+// the labels of the object itself, requests for other cluster scoped objects always match. If the
+// namespace can't be looked up the request is rejected, unless the route is set to SkipOnMatchError.
+func (r *RouteMatch) NamespaceSelector(cache generic.NonNamespacedCacheInterface[*corev1.Namespace], selector labels.Selector) *RouteMatch {
+	r.namespaceCache = cache
+	r.namespaceSelector = selector
+	return r
+}
+
+// ObjectSelector matches admission request where the object or old object has labels matching the selector.
+func (r *RouteMatch) ObjectSelector(selector labels.Selector) *RouteMatch {
+	r.objectSelector = selector
+	return r
+}
+
 // Operation matches admission request with the matching Operation value.
 func (r *RouteMatch) Operation(operation v1.Operation) *RouteMatch { r.operation = operation; return r }
 
 // Resource matches admission request with the matching Resource value.
 func (r *RouteMatch) Resource(resource string) *RouteMatch { r.resource = resource; return r }
 
+// RouteName sets the name used for the route in metrics and logs.
+func (r *RouteMatch) RouteName(name string) *RouteMatch { r.routeName = name; return r }
+
+// SkipOnMatchError makes the route not match, instead of rejecting the request, when its matchers fail
+// so the next routes are tried.
+func (r *RouteMatch) SkipOnMatchError() *RouteMatch { r.skipOnMatchError = true; return r }
+
 // SubResource matches admission request with the matching SubResource value.
 func (r *RouteMatch) SubResource(sr string) *RouteMatch { r.subResource = sr; return r }
 
+// Timeout sets how long the handler may run before the failure policy is applied.
+// The context of the Request passed to the handler is canceled when the timeout expires.
+func (r *RouteMatch) Timeout(timeout time.Duration) *RouteMatch { r.timeout = timeout; return r }
+
 // Type specifies the runtime.Object to use for decoding.
 func (r *RouteMatch) Type(objType runtime.Object) *RouteMatch { r.objType = objType; return r }
 
+// User matches admission request made by any of the given usernames.
+func (r *RouteMatch) User(usernames ...string) *RouteMatch {
+	r.users = append(r.users, usernames...)
+	return r
+}
+
+// UserGroup matches admission request made by a user in any of the given groups.
+func (r *RouteMatch) UserGroup(groups ...string) *RouteMatch {
+	r.userGroups = append(r.userGroups, groups...)
+	return r
+}
+
 // Version matches admission request with the matching Version value.
 func (r *RouteMatch) Version(version string) *RouteMatch { r.version = version; return r }
 
@@ -115,6 +323,11 @@ func (r *RouteMatch) Version(version string) *RouteMatch { r.version = version;
 // DryRun matches admission request with the matching DryRun value.
 func (r *Router) DryRun(dryRun bool) *RouteMatch { return r.next().DryRun(dryRun) }
 
+// FailurePolicy sets whether the request is allowed (Ignore) or denied (Fail) when the handler times out.
+func (r *Router) FailurePolicy(policy admissionregv1.FailurePolicyType) *RouteMatch {
+	return r.next().FailurePolicy(policy)
+}
+
 // Group matches admission request with the matching Group value.
 func (r *Router) Group(group string) *RouteMatch { return r.next().Group(group) }
 
@@ -133,19 +346,49 @@ func (r *Router) Name(name string) *RouteMatch { return r.next().Name(name) }
 // Namespace matches admission request with the matching Namespace value.
 func (r *Router) Namespace(namespace string) *RouteMatch { return r.next().Namespace(namespace) }
 
+// MatchCondition matches admission request for which the CEL expression evaluates to true.
+func (r *Router) MatchCondition(expression string) *RouteMatch {
+	return r.next().MatchCondition(expression)
+}
+
+// NamespaceSelector matches admission request whose namespace has labels matching the selector.
+func (r *Router) NamespaceSelector(cache generic.NonNamespacedCacheInterface[*corev1.Namespace], selector labels.Selector) *RouteMatch {
+	return r.next().NamespaceSelector(cache, selector)
+}
+
+// ObjectSelector matches admission request where the object or old object has labels matching the selector.
+func (r *Router) ObjectSelector(selector labels.Selector) *RouteMatch {
+	return r.next().ObjectSelector(selector)
+}
+
 // Operation matches admission request with the matching Operation value.
 func (r *Router) Operation(operation v1.Operation) *RouteMatch { return r.next().Operation(operation) }
 
 // Resource matches admission request with the matching Resource value.
 func (r *Router) Resource(resource string) *RouteMatch { return r.next().Resource(resource) }
 
+// RouteName sets the name used for the route in metrics and logs.
+func (r *Router) RouteName(name string) *RouteMatch { return r.next().RouteName(name) }
+
+// SkipOnMatchError makes the route not match, instead of rejecting the request, when its matchers fail.
+func (r *Router) SkipOnMatchError() *RouteMatch { return r.next().SkipOnMatchError() }
+
 // SubResource matches admission request with the matching SubResource value.
 func (r *Router) SubResource(subResource string) *RouteMatch {
 	return r.next().SubResource(subResource)
 }
 
+// Timeout sets how long the handler may run before the failure policy is applied.
+func (r *Router) Timeout(timeout time.Duration) *RouteMatch { return r.next().Timeout(timeout) }
+
 // Type specifies the runtime.Object to use for decoding.
 func (r *Router) Type(objType runtime.Object) *RouteMatch { return r.next().Type(objType) }
 
+// User matches admission request made by any of the given usernames.
+func (r *Router) User(usernames ...string) *RouteMatch { return r.next().User(usernames...) }
+
+// UserGroup matches admission request made by a user in any of the given groups.
+func (r *Router) UserGroup(groups ...string) *RouteMatch { return r.next().UserGroup(groups...) }
+
 // Version matches admission request with the matching Version value.
 func (r *Router) Version(version string) *RouteMatch { return r.next().Version(version) }
@@ -0,0 +1,120 @@
+package webhook
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rancher/wrangler/v3/pkg/generic/fake"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	v1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestRouteMatchSelectors(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	nsCache := fake.NewMockNonNamespacedCacheInterface[*corev1.Namespace](ctrl)
+	nsCache.EXPECT().Get("tenant-a").Return(&corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "tenant-a", Labels: map[string]string{"tenant": "a"}},
+	}, nil).AnyTimes()
+
+	request := func(namespace string, objLabels string) *v1.AdmissionRequest {
+		return &v1.AdmissionRequest{
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "ConfigMap"},
+			Namespace: namespace,
+			Name:      "test",
+			Operation: v1.Create,
+			UserInfo: authenticationv1.UserInfo{
+				Username: "alice",
+				Groups:   []string{"system:authenticated", "admins"},
+			},
+			Object: runtime.RawExtension{
+				Raw: []byte(`{"metadata":{"name":"test","labels":` + objLabels + `},"data":{"key":"value"}}`),
+			},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		route   *RouteMatch
+		request *v1.AdmissionRequest
+		want    bool
+		wantErr bool
+	}{
+		{
+			name:    "object selector matches",
+			route:   (&RouteMatch{}).ObjectSelector(labels.SelectorFromSet(labels.Set{"app": "web"})),
+			request: request("tenant-a", `{"app":"web"}`),
+			want:    true,
+		},
+		{
+			name:    "object selector does not match",
+			route:   (&RouteMatch{}).ObjectSelector(labels.SelectorFromSet(labels.Set{"app": "web"})),
+			request: request("tenant-a", `{"app":"db"}`),
+			want:    false,
+		},
+		{
+			name:    "namespace selector matches",
+			route:   (&RouteMatch{}).NamespaceSelector(nsCache, labels.SelectorFromSet(labels.Set{"tenant": "a"})),
+			request: request("tenant-a", `{}`),
+			want:    true,
+		},
+		{
+			name:    "namespace selector does not match",
+			route:   (&RouteMatch{}).NamespaceSelector(nsCache, labels.SelectorFromSet(labels.Set{"tenant": "b"})),
+			request: request("tenant-a", `{}`),
+			want:    false,
+		},
+		{
+			name:    "user matches",
+			route:   (&RouteMatch{}).User("bob", "alice"),
+			request: request("tenant-a", `{}`),
+			want:    true,
+		},
+		{
+			name:    "user group does not match",
+			route:   (&RouteMatch{}).UserGroup("system:masters"),
+			request: request("tenant-a", `{}`),
+			want:    false,
+		},
+		{
+			name:    "match condition matches",
+			route:   (&RouteMatch{}).MatchCondition(`object.data.key == "value" && request.userInfo.username == "alice"`),
+			request: request("tenant-a", `{}`),
+			want:    true,
+		},
+		{
+			name:    "match condition does not match",
+			route:   (&RouteMatch{}).MatchCondition(`oldObject != null`),
+			request: request("tenant-a", `{}`),
+			want:    false,
+		},
+		{
+			name:    "invalid match condition",
+			route:   (&RouteMatch{}).MatchCondition(`object.data.key ==`),
+			request: request("tenant-a", `{}`),
+			wantErr: true,
+		},
+		{
+			name:    "match condition over its cost limit",
+			route:   (&RouteMatch{}).MatchCondition(`object.items.all(x, object.items.all(y, x == x))`),
+			request: request("tenant-a", `{"items":[`+strings.Repeat(`1,`, 1100)+`1]}`),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.route.matches(tt.request)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
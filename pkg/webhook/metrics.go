@@ -0,0 +1,89 @@
+package webhook
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/admission/v1"
+)
+
+const (
+	metricsEnv       = "CATTLE_PROMETHEUS_METRICS"
+	webhookSubsystem = "wrangler_webhook"
+
+	routeLabel     = "route"
+	operationLabel = "operation"
+	resultLabel    = "result"
+
+	resultAllowed = "allowed"
+	resultDenied  = "denied"
+	resultError   = "error"
+	resultPanic   = "panic"
+	resultTimeout = "timeout"
+)
+
+var (
+	prometheusMetrics = false
+
+	totalAdmissionRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: webhookSubsystem,
+			Name:      "total_admission_requests",
+			Help:      "Total count of admission requests handled per route and result",
+		},
+		[]string{routeLabel, operationLabel, resultLabel},
+	)
+
+	admissionDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: webhookSubsystem,
+			Name:      "admission_duration_seconds",
+			Help:      "Histogram of the durations of admission handlers per route and result",
+		},
+		[]string{routeLabel, operationLabel, resultLabel},
+	)
+)
+
+func init() {
+	if os.Getenv(metricsEnv) == "true" {
+		MustRegisterMetrics(prometheus.DefaultRegisterer)
+	}
+}
+
+// MustRegisterMetrics registers the webhook router metrics with the provided registerer.
+func MustRegisterMetrics(registerer prometheus.Registerer) {
+	prometheusMetrics = true
+	registerer.MustRegister(
+		totalAdmissionRequests,
+		admissionDuration,
+	)
+}
+
+func observeAdmission(route string, operation v1.Operation, result string, duration time.Duration) {
+	if !prometheusMetrics {
+		return
+	}
+	labels := prometheus.Labels{
+		routeLabel:     route,
+		operationLabel: string(operation),
+		resultLabel:    result,
+	}
+	totalAdmissionRequests.With(labels).Inc()
+	admissionDuration.With(labels).Observe(duration.Seconds())
+}
+
+// resultFor returns the result label for a handler that returned.
+func resultFor(response *Response, err error) string {
+	var pErr *panicError
+	switch {
+	case errors.As(err, &pErr):
+		return resultPanic
+	case err != nil:
+		return resultError
+	case response.Allowed:
+		return resultAllowed
+	}
+	return resultDenied
+}
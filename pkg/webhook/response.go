@@ -0,0 +1,111 @@
+package webhook
+
+import (
+	"fmt"
+	"net/http"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// AddWarning appends a warning that will be returned to the API client.
+// Identical warnings are only sent once.
+func (r *Response) AddWarning(format string, args ...interface{}) {
+	warning := fmt.Sprintf(format, args...)
+	for _, existing := range r.Warnings {
+		if existing == warning {
+			return
+		}
+	}
+	r.Warnings = append(r.Warnings, warning)
+}
+
+// AddAuditAnnotation records an annotation that the API server will add to the audit event of the request.
+// The API server prefixes the key with the name of the admission webhook.
+// Setting the same key twice overwrites the previous value.
+func (r *Response) AddAuditAnnotation(key, value string) {
+	if r.AuditAnnotations == nil {
+		r.AuditAnnotations = map[string]string{}
+	}
+	r.AuditAnnotations[key] = value
+}
+
+// Deny rejects the request with the given HTTP status code and message.
+func (r *Response) Deny(code int32, format string, args ...interface{}) {
+	r.DenyWithStatus(&metav1.Status{
+		Status:  metav1.StatusFailure,
+		Code:    code,
+		Reason:  reasonForCode(code),
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// DenyWithStatus rejects the request and returns the given status to the API client.
+func (r *Response) DenyWithStatus(status *metav1.Status) {
+	r.Allowed = false
+	r.Result = status
+}
+
+// DenyWithError rejects the request using the status of err.
+// If err does not carry an API status the request is denied as forbidden with the error as the message.
+func (r *Response) DenyWithError(err error) {
+	if status, ok := err.(errors.APIStatus); ok {
+		s := status.Status()
+		r.DenyWithStatus(&s)
+		return
+	}
+	r.Deny(http.StatusForbidden, "%s", err.Error())
+}
+
+// Invalid rejects the request as invalid if errs is not empty.
+// The returned status carries a cause for every field error so API clients can report them per field.
+// Invalid returns true if the request was denied.
+func (r *Response) Invalid(request *Request, errs field.ErrorList) bool {
+	if len(errs) == 0 {
+		return false
+	}
+	gk := schema.GroupKind{Group: request.Kind.Group, Kind: request.Kind.Kind}
+	r.DenyWithStatus(&errors.NewInvalid(gk, request.Name, errs).ErrStatus)
+	return true
+}
+
+// Forbidden rejects the request as forbidden for the resource in the request.
+func (r *Response) Forbidden(request *Request, err error) {
+	gr := schema.GroupResource{Group: request.Resource.Group, Resource: request.Resource.Resource}
+	r.DenyWithStatus(&errors.NewForbidden(gr, request.Name, err).ErrStatus)
+}
+
+// reasonForCode returns the StatusReason the API server uses for the given HTTP status code.
+func reasonForCode(code int32) metav1.StatusReason {
+	switch code {
+	case http.StatusBadRequest:
+		return metav1.StatusReasonBadRequest
+	case http.StatusUnauthorized:
+		return metav1.StatusReasonUnauthorized
+	case http.StatusForbidden:
+		return metav1.StatusReasonForbidden
+	case http.StatusNotFound:
+		return metav1.StatusReasonNotFound
+	case http.StatusMethodNotAllowed:
+		return metav1.StatusReasonMethodNotAllowed
+	case http.StatusConflict:
+		return metav1.StatusReasonConflict
+	case http.StatusGone:
+		return metav1.StatusReasonGone
+	case http.StatusRequestEntityTooLarge:
+		return metav1.StatusReasonRequestEntityTooLarge
+	case http.StatusUnprocessableEntity:
+		return metav1.StatusReasonInvalid
+	case http.StatusTooManyRequests:
+		return metav1.StatusReasonTooManyRequests
+	case http.StatusInternalServerError:
+		return metav1.StatusReasonInternalError
+	case http.StatusServiceUnavailable:
+		return metav1.StatusReasonServiceUnavailable
+	case http.StatusGatewayTimeout:
+		return metav1.StatusReasonTimeout
+	}
+	return metav1.StatusReasonUnknown
+}
@@ -0,0 +1,141 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func TestResponseWarningsAndAuditAnnotations(t *testing.T) {
+	resp := &Response{}
+	resp.AddWarning("field %s is deprecated", "spec.foo")
+	resp.AddWarning("field %s is deprecated", "spec.foo")
+	resp.AddWarning("field %s is deprecated", "spec.bar")
+	resp.AddAuditAnnotation("policy", "first")
+	resp.AddAuditAnnotation("policy", "second")
+
+	assert.Equal(t, []string{"field spec.foo is deprecated", "field spec.bar is deprecated"}, resp.Warnings)
+	assert.Equal(t, map[string]string{"policy": "second"}, resp.AuditAnnotations)
+}
+
+func TestResponseDenials(t *testing.T) {
+	request := &Request{
+		AdmissionRequest: v1.AdmissionRequest{
+			Name:     "test",
+			Kind:     metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+			Resource: metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		deny       func(resp *Response)
+		wantCode   int32
+		wantReason metav1.StatusReason
+		wantCauses []metav1.StatusCause
+	}{
+		{
+			name:       "deny with code",
+			deny:       func(resp *Response) { resp.Deny(http.StatusConflict, "already %s", "exists") },
+			wantCode:   http.StatusConflict,
+			wantReason: metav1.StatusReasonConflict,
+		},
+		{
+			name:       "deny with plain error",
+			deny:       func(resp *Response) { resp.DenyWithError(fmt.Errorf("nope")) },
+			wantCode:   http.StatusForbidden,
+			wantReason: metav1.StatusReasonForbidden,
+		},
+		{
+			name: "deny with api error",
+			deny: func(resp *Response) {
+				resp.DenyWithError(errors.NewNotFound(schema.GroupResource{Resource: "secrets"}, "missing"))
+			},
+			wantCode:   http.StatusNotFound,
+			wantReason: metav1.StatusReasonNotFound,
+		},
+		{
+			name: "invalid",
+			deny: func(resp *Response) {
+				resp.Invalid(request, field.ErrorList{
+					field.Required(field.NewPath("spec", "replicas"), "must be set"),
+				})
+			},
+			wantCode:   http.StatusUnprocessableEntity,
+			wantReason: metav1.StatusReasonInvalid,
+			wantCauses: []metav1.StatusCause{{
+				Type:    metav1.CauseTypeFieldValueRequired,
+				Message: "Required value: must be set",
+				Field:   "spec.replicas",
+			}},
+		},
+		{
+			name:       "forbidden",
+			deny:       func(resp *Response) { resp.Forbidden(request, fmt.Errorf("not allowed")) },
+			wantCode:   http.StatusForbidden,
+			wantReason: metav1.StatusReasonForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &Response{AdmissionResponse: v1.AdmissionResponse{Allowed: true}}
+			tt.deny(resp)
+			assert.False(t, resp.Allowed)
+			if assert.NotNil(t, resp.Result) {
+				assert.Equal(t, metav1.StatusFailure, resp.Result.Status)
+				assert.Equal(t, tt.wantCode, resp.Result.Code)
+				assert.Equal(t, tt.wantReason, resp.Result.Reason)
+				if tt.wantCauses != nil {
+					assert.Equal(t, tt.wantCauses, resp.Result.Details.Causes)
+				}
+			}
+		})
+	}
+}
+
+func TestResponseInvalidWithoutErrors(t *testing.T) {
+	resp := &Response{AdmissionResponse: v1.AdmissionResponse{Allowed: true}}
+	assert.False(t, resp.Invalid(&Request{}, nil))
+	assert.True(t, resp.Allowed)
+	assert.Nil(t, resp.Result)
+}
+
+func TestResponseCreatePatch(t *testing.T) {
+	request := &Request{
+		AdmissionRequest: v1.AdmissionRequest{
+			Object: runtime.RawExtension{Raw: []byte(`{"metadata":{"name":"a","labels":{"changed":"old","removed":"x"}}}`)},
+		},
+	}
+	newObj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":   "a",
+			"labels": map[string]interface{}{"changed": "new", "added": "y"},
+		},
+	}}
+
+	resp := &Response{}
+	require.NoError(t, resp.CreatePatch(request, newObj))
+	require.NotNil(t, resp.PatchType)
+	assert.Equal(t, v1.PatchTypeJSONPatch, *resp.PatchType)
+
+	var ops []map[string]interface{}
+	require.NoError(t, json.Unmarshal(resp.Patch, &ops))
+	assert.ElementsMatch(t, []map[string]interface{}{
+		{"op": "add", "path": "/metadata/labels/added", "value": "y"},
+		{"op": "replace", "path": "/metadata/labels/changed", "value": "new"},
+		{"op": "remove", "path": "/metadata/labels/removed"},
+	}, ops)
+
+	assert.Error(t, resp.CreatePatch(request, newObj), "the patch can only be created once")
+}
@@ -7,8 +7,8 @@ import (
 	"fmt"
 	"net/http"
 
-	jsonpatch "github.com/evanphx/json-patch"
 	"github.com/sirupsen/logrus"
+	"gomodules.xyz/jsonpatch/v2"
 	v1 "k8s.io/api/admission/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -25,40 +25,44 @@ func NewRouter() *Router {
 	return &Router{}
 }
 
-// Router manages request and the calling of matching handlers.
+// Router manages request and the calling of matching handlers. Routes are tried in the order they were added and
+// the first matching route handles the request. If the matchers of a route fail, for example because a match
+// condition fails to evaluate or the Namespace of the request can't be looked up, the request is rejected with the
+// error, unless the route is set to SkipOnMatchError in which case it does not match and the next routes are tried.
+// Requests that no route matches are rejected with an error.
 type Router struct {
 	matches []*RouteMatch
 }
 
-func (r *Router) sendError(rw http.ResponseWriter, review *v1.AdmissionReview, err error) {
+func (r *Router) sendError(rw http.ResponseWriter, review *v1.AdmissionReview, apiVersion string, err error) {
 	logrus.Error(err)
 	if review == nil || review.Request == nil {
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	review.Response.Result = &errors.NewInternalError(err).ErrStatus
-	writeResponse(rw, review)
+	writeResponse(rw, review, apiVersion)
 }
 
-func writeResponse(rw http.ResponseWriter, review *v1.AdmissionReview) {
+func writeResponse(rw http.ResponseWriter, review *v1.AdmissionReview, apiVersion string) {
 	rw.Header().Set("Content-Type", "application/json")
-	err := json.NewEncoder(rw).Encode(review)
+	err := json.NewEncoder(rw).Encode(encodeReview(review, apiVersion))
 	if err != nil {
 		logrus.Errorf("Failed to write response: %s", err)
 	}
 }
 
 // ServeHTTP inspects the http.Request and calls the Admit function on all matching handlers.
+// Both admission/v1 and admission/v1beta1 AdmissionReviews are accepted, the response uses the version of the request.
 func (r *Router) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-	review := &v1.AdmissionReview{}
-	err := json.NewDecoder(req.Body).Decode(review)
+	review, apiVersion, err := decodeReview(req.Body)
 	if err != nil {
-		r.sendError(rw, review, err)
+		r.sendError(rw, review, apiVersion, err)
 		return
 	}
 
 	if review.Request == nil {
-		r.sendError(rw, review, fmt.Errorf("request is not set"))
+		r.sendError(rw, review, apiVersion, fmt.Errorf("request is not set"))
 		return
 	}
 
@@ -71,16 +75,24 @@ func (r *Router) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	review.Response = &response.AdmissionResponse
 
 	if err := r.admit(response, review.Request, req); err != nil {
-		r.sendError(rw, review, err)
+		r.sendError(rw, review, apiVersion, err)
 		return
 	}
 
-	writeResponse(rw, review)
+	writeResponse(rw, review, apiVersion)
 }
 
 func (r *Router) admit(response *Response, request *v1.AdmissionRequest, req *http.Request) error {
 	for _, m := range r.matches {
-		if m.matches(request) {
+		matched, err := m.matches(request)
+		if err != nil {
+			if !m.skipOnMatchError {
+				return fmt.Errorf("failed to match route %s: %w", m.getRouteName(), err)
+			}
+			logrus.Warnf("failed to match route %s: %v", m.getRouteName(), err)
+			continue
+		}
+		if matched {
 			err := m.admit(response, &Request{
 				AdmissionRequest: *request,
 				Context:          req.Context(),
@@ -140,7 +152,12 @@ func (r *Response) CreatePatch(request *Request, newObj runtime.Object) error {
 		return err
 	}
 
-	patch, err := jsonpatch.CreateMergePatch(request.Object.Raw, newBytes)
+	ops, err := jsonpatch.CreatePatch(request.Object.Raw, newBytes)
+	if err != nil {
+		return err
+	}
+
+	patch, err := json.Marshal(ops)
 	if err != nil {
 		return err
 	}
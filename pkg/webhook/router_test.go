@@ -0,0 +1,198 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/admission/v1"
+	"k8s.io/api/admission/v1beta1"
+	admissionregv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func serve(t *testing.T, router *Router, request *v1.AdmissionRequest) *v1.AdmissionResponse {
+	t.Helper()
+	body, err := json.Marshal(&v1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request:  request,
+	})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body)))
+
+	review := &v1.AdmissionReview{}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(review))
+	require.NotNil(t, review.Response)
+	return review.Response
+}
+
+func TestRouterHandlerFailures(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+
+	slow := func(resp *Response, req *Request) error {
+		select {
+		case <-block:
+		case <-req.Context.Done():
+		}
+		resp.Allowed = true
+		return nil
+	}
+
+	tests := []struct {
+		name        string
+		route       func(r *Router)
+		wantAllowed bool
+		wantCode    int32
+		wantWarning bool
+	}{
+		{
+			name: "panic is returned as internal error",
+			route: func(r *Router) {
+				r.HandleFunc(func(resp *Response, req *Request) error { panic("boom") })
+			},
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name: "timeout fails closed by default",
+			route: func(r *Router) {
+				r.HandleFunc(slow).Timeout(10 * time.Millisecond)
+			},
+			wantCode: http.StatusGatewayTimeout,
+		},
+		{
+			name: "timeout fails open",
+			route: func(r *Router) {
+				r.HandleFunc(slow).Timeout(10 * time.Millisecond).FailurePolicy(admissionregv1.Ignore)
+			},
+			wantAllowed: true,
+			wantWarning: true,
+		},
+		{
+			name: "handler finishing before timeout",
+			route: func(r *Router) {
+				r.HandleFunc(func(resp *Response, req *Request) error {
+					resp.Allowed = true
+					return nil
+				}).Timeout(time.Minute)
+			},
+			wantAllowed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := NewRouter()
+			tt.route(router)
+
+			resp := serve(t, router, &v1.AdmissionRequest{
+				UID:       types.UID("uid"),
+				Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+				Operation: v1.Create,
+			})
+
+			assert.Equal(t, types.UID("uid"), resp.UID)
+			assert.Equal(t, tt.wantAllowed, resp.Allowed)
+			if tt.wantCode != 0 && assert.NotNil(t, resp.Result) {
+				assert.Equal(t, tt.wantCode, resp.Result.Code)
+			}
+			assert.Equal(t, tt.wantWarning, len(resp.Warnings) > 0)
+		})
+	}
+}
+
+func TestRouterMatchErrors(t *testing.T) {
+	router := NewRouter()
+	router.Kind("ConfigMap").RouteName("broken").MatchCondition(`object.data.key ==`).HandleFunc(func(resp *Response, req *Request) error {
+		resp.Allowed = false
+		return nil
+	})
+	request := &v1.AdmissionRequest{
+		UID:         types.UID("uid"),
+		Kind:        metav1.GroupVersionKind{Version: "v1", Kind: "ConfigMap"},
+		RequestKind: &metav1.GroupVersionKind{Version: "v1", Kind: "ConfigMap"},
+		Operation:   v1.Create,
+	}
+
+	allow := func(resp *Response, req *Request) error {
+		resp.Allowed = true
+		return nil
+	}
+	router.Kind("ConfigMap").HandleFunc(allow)
+
+	// the request is rejected with the error of the route, later routes are not tried
+	resp := serve(t, router, request)
+	assert.False(t, resp.Allowed)
+	require.NotNil(t, resp.Result)
+	assert.Contains(t, resp.Result.Message, "failed to match route broken")
+
+	// a route set to skip on match errors does not match, the next routes are tried
+	router = NewRouter()
+	router.Kind("ConfigMap").RouteName("skipped").MatchCondition(`object.data.key ==`).SkipOnMatchError().HandleFunc(func(resp *Response, req *Request) error {
+		resp.Allowed = false
+		return nil
+	})
+	router.Kind("ConfigMap").HandleFunc(allow)
+	resp = serve(t, router, request)
+	assert.True(t, resp.Allowed)
+	assert.Nil(t, resp.Result)
+}
+
+func TestRouterV1beta1(t *testing.T) {
+	router := NewRouter()
+	router.Kind("ConfigMap").HandleFunc(func(resp *Response, req *Request) error {
+		obj, err := req.DecodeObject()
+		if err != nil {
+			return err
+		}
+		newObj := obj.DeepCopyObject().(*unstructured.Unstructured)
+		newObj.SetLabels(map[string]string{"managed": "true"})
+		resp.Allowed = true
+		resp.AddWarning("converted")
+		return resp.CreatePatch(req, newObj)
+	})
+
+	body, err := json.Marshal(&v1beta1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1beta1", Kind: "AdmissionReview"},
+		Request: &v1beta1.AdmissionRequest{
+			UID:         types.UID("uid"),
+			Kind:        metav1.GroupVersionKind{Version: "v1", Kind: "ConfigMap"},
+			RequestKind: &metav1.GroupVersionKind{Version: "v1", Kind: "ConfigMap"},
+			Operation:   v1beta1.Create,
+			Object:      runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"test"}}`)},
+		},
+	})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body)))
+
+	review := &v1beta1.AdmissionReview{}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(review))
+	assert.Equal(t, "admission.k8s.io/v1beta1", review.APIVersion)
+	assert.Equal(t, "AdmissionReview", review.Kind)
+	require.NotNil(t, review.Response)
+	assert.Equal(t, types.UID("uid"), review.Response.UID)
+	assert.True(t, review.Response.Allowed)
+	assert.Equal(t, []string{"converted"}, review.Response.Warnings)
+	assert.JSONEq(t, `[{"op":"add","path":"/metadata/labels","value":{"managed":"true"}}]`, string(review.Response.Patch))
+	if assert.NotNil(t, review.Response.PatchType) {
+		assert.Equal(t, v1beta1.PatchTypeJSONPatch, *review.Response.PatchType)
+	}
+}
+
+func TestRouterUnsupportedVersion(t *testing.T) {
+	rec := httptest.NewRecorder()
+	body := `{"apiVersion":"admission.k8s.io/v2","kind":"AdmissionReview","request":{"uid":"uid"}}`
+	NewRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte(body))))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+}
@@ -0,0 +1,71 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/rancher/wrangler/v3/pkg/generic"
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// matchesObjectSelector checks the selector against the labels of the object and the old object in the request.
+// The request matches if either of them match, the same as the object selector of a webhook configuration.
+func matchesObjectSelector(selector labels.Selector, req *v1.AdmissionRequest) (bool, error) {
+	for _, raw := range []runtime.RawExtension{req.Object, req.OldObject} {
+		if len(raw.Raw) == 0 {
+			continue
+		}
+		objLabels, err := rawLabels(raw.Raw)
+		if err != nil {
+			return false, err
+		}
+		if selector.Matches(objLabels) {
+			return true, nil
+		}
+	}
+	return selector.Empty(), nil
+}
+
+// matchesNamespaceSelector checks the selector against the labels of the namespace of the request.
+func matchesNamespaceSelector(cache generic.NonNamespacedCacheInterface[*corev1.Namespace], selector labels.Selector, req *v1.AdmissionRequest) (bool, error) {
+	if req.Kind.Group == "" && req.Kind.Kind == "Namespace" {
+		raw := req.Object.Raw
+		if len(raw) == 0 {
+			raw = req.OldObject.Raw
+		}
+		nsLabels, err := rawLabels(raw)
+		if err != nil {
+			return false, err
+		}
+		return selector.Matches(nsLabels), nil
+	}
+
+	if req.Namespace == "" {
+		return true, nil
+	}
+
+	if cache == nil {
+		return false, fmt.Errorf("no namespace cache set for namespace selector")
+	}
+	ns, err := cache.Get(req.Namespace)
+	if err != nil {
+		return false, fmt.Errorf("failed to get namespace %s: %w", req.Namespace, err)
+	}
+	return selector.Matches(labels.Set(ns.Labels)), nil
+}
+
+// rawLabels returns the labels of the serialized object.
+func rawLabels(raw []byte) (labels.Set, error) {
+	if len(raw) == 0 {
+		return labels.Set{}, nil
+	}
+	obj := &metav1.PartialObjectMetadata{}
+	if err := json.Unmarshal(raw, obj); err != nil {
+		return nil, fmt.Errorf("failed to decode object metadata: %w", err)
+	}
+	return labels.Set(obj.Labels), nil
+}
@@ -0,0 +1,74 @@
+package testing
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	gotesting "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// TestCase is a single admission request and the result it is expected to produce.
+type TestCase struct {
+	// Name of the sub test.
+	Name string
+	// Request to send to the handler.
+	Request Request
+	// WantAllowed is whether the request is expected to be admitted.
+	WantAllowed bool
+	// WantCode is the expected status code of a denied request, it is not checked if zero.
+	WantCode int32
+	// WantMessage is the expected message of the result status, it is not checked if empty.
+	WantMessage string
+	// WantPatched is the expected object after the patch of the response is applied, it is not checked if nil.
+	WantPatched runtime.Object
+	// WantWarnings are the expected warnings of the response.
+	WantWarnings []string
+	// WantErr is whether the handler is expected to fail to produce a valid response.
+	WantErr bool
+}
+
+// Run runs each test case as a sub test against the handler, usually a *webhook.Router.
+func Run(t *gotesting.T, handler http.Handler, tests []TestCase) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *gotesting.T) {
+			result, err := Admit(handler, tt.Request)
+			if tt.WantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.WantAllowed, result.Allowed(), "allowed, message: %s", result.Message())
+			if tt.WantCode != 0 && assert.NotNil(t, result.Response.Result, "result status") {
+				assert.Equal(t, tt.WantCode, result.Response.Result.Code, "status code")
+			}
+			if tt.WantMessage != "" {
+				assert.Equal(t, tt.WantMessage, result.Message(), "message")
+			}
+			assert.ElementsMatch(t, tt.WantWarnings, result.Warnings(), "warnings")
+			if tt.WantPatched != nil {
+				assertPatched(t, tt.WantPatched, result)
+			}
+		})
+	}
+}
+
+// assertPatched compares the patched object with the expected object after both are decoded to generic JSON,
+// so fields set by encoding the request such as apiVersion and kind do not need to be set on the expected object.
+func assertPatched(t *gotesting.T, want runtime.Object, result *Result) {
+	t.Helper()
+	got := reflect.New(reflect.TypeOf(want).Elem()).Interface().(runtime.Object)
+	require.NoError(t, result.DecodePatched(got), "decoding patched object")
+	got.GetObjectKind().SetGroupVersionKind(want.GetObjectKind().GroupVersionKind())
+
+	wantJSON, err := json.Marshal(want)
+	require.NoError(t, err)
+	gotJSON, err := json.Marshal(got)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(wantJSON), string(gotJSON), "patched object")
+}
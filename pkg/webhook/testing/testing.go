@@ -0,0 +1,237 @@
+// Package testing provides helpers to run admission requests through a webhook.Router in memory.
+package testing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/rancher/wrangler/v3/pkg/gvk"
+	v1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// Request describes an admission request built from typed objects.
+type Request struct {
+	// Operation is the operation of the request, it defaults to CREATE.
+	Operation v1.Operation
+	// Object is the new object of the request, nil for DELETE.
+	Object runtime.Object
+	// OldObject is the existing object of the request, nil for CREATE.
+	OldObject runtime.Object
+	// Resource of the request, if not set it is guessed from the kind of the object.
+	Resource *metav1.GroupVersionResource
+	// SubResource of the request.
+	SubResource string
+	// DryRun marks the request as a dry run.
+	DryRun bool
+	// UserInfo of the user making the request.
+	UserInfo authenticationv1.UserInfo
+}
+
+// NewAdmissionReview builds an admission/v1 AdmissionReview for the given request.
+// The kind, name and namespace of the request are taken from Object or OldObject if Object is nil.
+func NewAdmissionReview(req Request) (*v1.AdmissionReview, error) {
+	operation := req.Operation
+	if operation == "" {
+		operation = v1.Create
+	}
+
+	ref := req.Object
+	if ref == nil {
+		ref = req.OldObject
+	}
+	if ref == nil {
+		return nil, fmt.Errorf("either Object or OldObject must be set")
+	}
+
+	kind, err := objectKind(ref)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := meta.Accessor(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	resource := req.Resource
+	if resource == nil {
+		plural, _ := meta.UnsafeGuessKindToResource(kind)
+		resource = &metav1.GroupVersionResource{Group: plural.Group, Version: plural.Version, Resource: plural.Resource}
+	}
+
+	object, err := encode(req.Object, kind)
+	if err != nil {
+		return nil, err
+	}
+	oldObject, err := encode(req.OldObject, kind)
+	if err != nil {
+		return nil, err
+	}
+
+	requestKind := metav1.GroupVersionKind{Group: kind.Group, Version: kind.Version, Kind: kind.Kind}
+	return &v1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1.SchemeGroupVersion.String(),
+			Kind:       "AdmissionReview",
+		},
+		Request: &v1.AdmissionRequest{
+			UID:             uuid.NewUUID(),
+			Kind:            requestKind,
+			Resource:        *resource,
+			SubResource:     req.SubResource,
+			RequestKind:     &requestKind,
+			RequestResource: resource,
+			Name:            metadata.GetName(),
+			Namespace:       metadata.GetNamespace(),
+			Operation:       operation,
+			UserInfo:        req.UserInfo,
+			Object:          object,
+			OldObject:       oldObject,
+			DryRun:          &req.DryRun,
+		},
+	}, nil
+}
+
+// objectKind returns the GroupVersionKind of the object, falling back to the client-go scheme for built-in
+// types that are not registered in the wrangler scheme.
+func objectKind(obj runtime.Object) (schema.GroupVersionKind, error) {
+	kind, err := gvk.Get(obj)
+	if err == nil {
+		return kind, nil
+	}
+	kinds, _, schemeErr := scheme.Scheme.ObjectKinds(obj)
+	if schemeErr != nil || len(kinds) == 0 {
+		return schema.GroupVersionKind{}, err
+	}
+	return kinds[0], nil
+}
+
+// encode serializes the object with its apiVersion and kind set.
+func encode(obj runtime.Object, kind schema.GroupVersionKind) (runtime.RawExtension, error) {
+	if obj == nil {
+		return runtime.RawExtension{}, nil
+	}
+	obj = obj.DeepCopyObject()
+	typeAccessor, err := meta.TypeAccessor(obj)
+	if err != nil {
+		return runtime.RawExtension{}, err
+	}
+	apiVersion, kindName := kind.ToAPIVersionAndKind()
+	typeAccessor.SetAPIVersion(apiVersion)
+	typeAccessor.SetKind(kindName)
+
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return runtime.RawExtension{}, err
+	}
+	return runtime.RawExtension{Raw: data}, nil
+}
+
+// Result is the decoded response of a handler to an admission request.
+type Result struct {
+	Review   *v1.AdmissionReview
+	Response *v1.AdmissionResponse
+	// Patched is the Object of the request with the patch of the response applied.
+	// If the response has no patch it is the unmodified Object.
+	Patched []byte
+}
+
+// Allowed returns whether the request was admitted.
+func (r *Result) Allowed() bool {
+	return r.Response.Allowed
+}
+
+// Warnings returns the warnings the handler returned.
+func (r *Result) Warnings() []string {
+	return r.Response.Warnings
+}
+
+// Message returns the message of the result status, if any.
+func (r *Result) Message() string {
+	if r.Response.Result == nil {
+		return ""
+	}
+	return r.Response.Result.Message
+}
+
+// DecodePatched decodes the patched object into the given object.
+func (r *Result) DecodePatched(into runtime.Object) error {
+	return json.Unmarshal(r.Patched, into)
+}
+
+// Admit sends the request to the handler, usually a *webhook.Router, and returns the decoded result.
+// An error is returned if the response is malformed or the patch of the response can not be applied.
+func Admit(handler http.Handler, req Request) (*Result, error) {
+	review, err := NewAdmissionReview(req)
+	if err != nil {
+		return nil, err
+	}
+	return AdmitReview(handler, review)
+}
+
+// AdmitReview sends the AdmissionReview to the handler and returns the decoded result.
+func AdmitReview(handler http.Handler, review *v1.AdmissionReview) (*Result, error) {
+	body, err := json.Marshal(review)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := httptest.NewRecorder()
+	httpReq := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+	handler.ServeHTTP(rec, httpReq)
+
+	if rec.Code != http.StatusOK {
+		return nil, fmt.Errorf("webhook returned HTTP status %d: %s", rec.Code, strings.TrimSpace(rec.Body.String()))
+	}
+
+	respReview := &v1.AdmissionReview{}
+	if err := json.Unmarshal(rec.Body.Bytes(), respReview); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if respReview.Response == nil {
+		return nil, fmt.Errorf("response is not set")
+	}
+	if review.Request != nil && respReview.Response.UID != review.Request.UID {
+		return nil, fmt.Errorf("response UID %q does not match request UID %q", respReview.Response.UID, review.Request.UID)
+	}
+
+	result := &Result{
+		Review:   respReview,
+		Response: respReview.Response,
+	}
+	if review.Request != nil {
+		result.Patched = review.Request.Object.Raw
+	}
+	if len(respReview.Response.Patch) == 0 {
+		return result, nil
+	}
+
+	if !respReview.Response.Allowed {
+		return nil, fmt.Errorf("response denies the request but has a patch")
+	}
+	if respReview.Response.PatchType == nil || *respReview.Response.PatchType != v1.PatchTypeJSONPatch {
+		return nil, fmt.Errorf("response has a patch without patch type %s", v1.PatchTypeJSONPatch)
+	}
+	jsonPatch, err := jsonpatch.DecodePatch(respReview.Response.Patch)
+	if err != nil {
+		return nil, fmt.Errorf("response patch is not a valid JSON patch: %w", err)
+	}
+	patched, err := jsonPatch.Apply(result.Patched)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply response patch: %w", err)
+	}
+	result.Patched = patched
+	return result, nil
+}
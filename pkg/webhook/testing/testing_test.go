@@ -0,0 +1,123 @@
+package testing
+
+import (
+	"net/http"
+	gotesting "testing"
+
+	"github.com/rancher/wrangler/v3/pkg/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newRouter() *webhook.Router {
+	router := webhook.NewRouter()
+	router.Kind("ConfigMap").Type(&corev1.ConfigMap{}).HandleFunc(func(resp *webhook.Response, req *webhook.Request) error {
+		obj, err := req.DecodeObject()
+		if err != nil {
+			return err
+		}
+		configMap := obj.(*corev1.ConfigMap)
+		if configMap.Data["forbidden"] != "" {
+			resp.Deny(http.StatusForbidden, "key forbidden is not allowed")
+			return nil
+		}
+		if configMap.Data["old"] != "" {
+			resp.AddWarning("key old is deprecated")
+		}
+
+		resp.Allowed = true
+		if configMap.Labels["managed"] == "" {
+			newConfigMap := configMap.DeepCopy()
+			if newConfigMap.Labels == nil {
+				newConfigMap.Labels = map[string]string{}
+			}
+			newConfigMap.Labels["managed"] = "true"
+			return resp.CreatePatch(req, newConfigMap)
+		}
+		return nil
+	})
+	return router
+}
+
+func configMap(labels map[string]string, data map[string]string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "default",
+			Labels:    labels,
+		},
+		Data: data,
+	}
+}
+
+func TestRun(t *gotesting.T) {
+	Run(t, newRouter(), []TestCase{
+		{
+			Name:        "adds label",
+			Request:     Request{Object: configMap(nil, map[string]string{"key": "value"})},
+			WantAllowed: true,
+			WantPatched: configMap(map[string]string{"managed": "true"}, map[string]string{"key": "value"}),
+		},
+		{
+			Name: "keeps existing label",
+			Request: Request{
+				Operation: v1.Update,
+				Object:    configMap(map[string]string{"managed": "false"}, nil),
+				OldObject: configMap(nil, nil),
+			},
+			WantAllowed: true,
+			WantPatched: configMap(map[string]string{"managed": "false"}, nil),
+		},
+		{
+			Name:         "warns",
+			Request:      Request{Object: configMap(map[string]string{"managed": "true"}, map[string]string{"old": "value"})},
+			WantAllowed:  true,
+			WantWarnings: []string{"key old is deprecated"},
+		},
+		{
+			Name:        "denies",
+			Request:     Request{Object: configMap(nil, map[string]string{"forbidden": "value"})},
+			WantAllowed: false,
+			WantCode:    http.StatusForbidden,
+			WantMessage: "key forbidden is not allowed",
+		},
+		{
+			Name:     "no route",
+			Request:  Request{Object: &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "test"}}},
+			WantCode: http.StatusInternalServerError,
+		},
+	})
+}
+
+func TestAdmitRejectsInvalidPatch(t *gotesting.T) {
+	router := webhook.NewRouter()
+	router.HandleFunc(func(resp *webhook.Response, req *webhook.Request) error {
+		resp.Allowed = true
+		resp.Patch = []byte(`{"metadata":{"labels":{"managed":"true"}}}`)
+		patchType := v1.PatchTypeJSONPatch
+		resp.PatchType = &patchType
+		return nil
+	})
+
+	_, err := Admit(router, Request{Object: configMap(nil, nil)})
+	assert.Error(t, err)
+}
+
+func TestNewAdmissionReview(t *gotesting.T) {
+	review, err := NewAdmissionReview(Request{
+		Operation: v1.Delete,
+		OldObject: configMap(nil, nil),
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "admission.k8s.io/v1", review.APIVersion)
+	assert.Equal(t, metav1.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, review.Request.Kind)
+	assert.Equal(t, metav1.GroupVersionResource{Version: "v1", Resource: "configmaps"}, review.Request.Resource)
+	assert.Equal(t, "test", review.Request.Name)
+	assert.Equal(t, "default", review.Request.Namespace)
+	assert.Empty(t, review.Request.Object.Raw)
+	assert.NotEmpty(t, review.Request.OldObject.Raw)
+}